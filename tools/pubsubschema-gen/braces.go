@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fixBraceFormatting puts every closing brace of a declaration block on a
+// line of its own, indented like the line that opened the block, with no
+// blank lines before it, and starts a new line after it when another
+// declaration follows on the same line. Empty blocks ({}) and braces inside
+// option values are left alone, as are strings and comments. Unbalanced
+// braces are an error.
+func fixBraceFormatting(src string) (string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+	type block struct {
+		indent  string
+		line    int
+		literal bool
+	}
+	var blocks []block
+	var b strings.Builder
+	pos, brackets := 0, 0
+	stmtStart := true
+	option := false
+	for i, t := range toks {
+		gap := src[pos:t.start]
+		if t.kind == tokComment {
+			b.WriteString(gap + t.text)
+			pos = t.end
+			continue
+		}
+		if stmtStart {
+			option = t.text == "option"
+			stmtStart = false
+		}
+		switch t.text {
+		case "[":
+			brackets++
+		case "]":
+			brackets--
+		case "{":
+			literal := option || brackets > 0 || (len(blocks) > 0 && blocks[len(blocks)-1].literal)
+			blocks = append(blocks, block{indent: lineIndent(src, t.start), line: t.line, literal: literal})
+			stmtStart = !literal
+		case "}":
+			if len(blocks) == 0 {
+				return "", fmt.Errorf("line %d: unbalanced }", t.line)
+			}
+			bl := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			if !bl.literal && i > 0 && toks[i-1].text != "{" {
+				gap = "\n" + bl.indent
+			}
+			if !bl.literal {
+				b.WriteString(gap + t.text)
+				pos = t.end
+				if i+1 < len(toks) {
+					next := toks[i+1]
+					if next.kind != tokComment && next.line == t.line && next.text != ";" && next.text != "}" {
+						b.WriteString("\n" + bl.indent)
+						pos = next.start
+					}
+				}
+				stmtStart = true
+				continue
+			}
+		case ";":
+			stmtStart = true
+		}
+		b.WriteString(gap + t.text)
+		pos = t.end
+	}
+	if len(blocks) > 0 {
+		return "", fmt.Errorf("line %d: unclosed {", blocks[len(blocks)-1].line)
+	}
+	b.WriteString(src[pos:])
+	return b.String(), nil
+}
+
+// lineIndent returns the leading whitespace of the line containing offset.
+func lineIndent(src string, offset int) string {
+	start := strings.LastIndexByte(src[:offset], '\n') + 1
+	end := start
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return src[start:end]
+}
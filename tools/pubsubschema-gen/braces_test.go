@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixBraceFormatting(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "already formatted",
+			src:  "message E {\n  string id = 1;\n}\n",
+			want: "message E {\n  string id = 1;\n}\n",
+		},
+		{
+			name: "brace after the last field",
+			src:  "message E {\n  string id = 1;}\n",
+			want: "message E {\n  string id = 1;\n}\n",
+		},
+		{
+			name: "blank lines before the brace",
+			src:  "message E {\n  string id = 1;\n\n\n}\n",
+			want: "message E {\n  string id = 1;\n}\n",
+		},
+		{
+			name: "misindented brace",
+			src:  "message E {\n  string id = 1;\n    }\n",
+			want: "message E {\n  string id = 1;\n}\n",
+		},
+		{
+			name: "declaration after the brace",
+			src:  "message A {\n  string id = 1;\n} message B {\n  string id = 1;\n}\n",
+			want: "message A {\n  string id = 1;\n}\nmessage B {\n  string id = 1;\n}\n",
+		},
+		{
+			name: "nested",
+			src:  "message E {\n  message Inner {\n    string x = 1;}}\n",
+			want: "message E {\n  message Inner {\n    string x = 1;\n  }\n}\n",
+		},
+		{
+			name: "oneof and enum",
+			src:  "message E {\n  oneof v {\n    string a = 1;  }\n  enum K {\n    K_UNSPECIFIED = 0;\n\n  }\n}\n",
+			want: "message E {\n  oneof v {\n    string a = 1;\n  }\n  enum K {\n    K_UNSPECIFIED = 0;\n  }\n}\n",
+		},
+		{
+			name: "empty blocks",
+			src:  "message E {}\nmessage F { }\n",
+			want: "message E {}\nmessage F { }\n",
+		},
+		{
+			name: "trailing semicolon",
+			src:  "message E {\n  string id = 1;};\n",
+			want: "message E {\n  string id = 1;\n};\n",
+		},
+		{
+			name: "aggregate options",
+			src:  "message E {\n  option (meta) = { owner: \"a\" sub { x: 1 } };\n  string id = 1 [(rule) = { min: 1 }];\n}\n",
+			want: "message E {\n  option (meta) = { owner: \"a\" sub { x: 1 } };\n  string id = 1 [(rule) = { min: 1 }];\n}\n",
+		},
+		{
+			name: "strings and comments",
+			src:  "// }\nmessage E {\n  string id = 1 [default = \"}\"]; /* { */}\n",
+			want: "// }\nmessage E {\n  string id = 1 [default = \"}\"]; /* { */\n}\n",
+		},
+		{
+			name: "tabs",
+			src:  "message E {\n\tmessage I {\n\t\tstring x = 1;}\n}\n",
+			want: "message E {\n\tmessage I {\n\t\tstring x = 1;\n\t}\n}\n",
+		},
+		{name: "unbalanced", src: "message E {\n}\n}\n", wantErr: "line 3: unbalanced }"},
+		{name: "unclosed", src: "message E {\n  message I {\n}\n", wantErr: "line 1: unclosed {"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fixBraceFormatting(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("fixBraceFormatting =\n%q\nwant\n%q", got, tt.want)
+			}
+			if again, err := fixBraceFormatting(got); err != nil || again != got {
+				t.Errorf("not idempotent: %q, %v", again, err)
+			}
+		})
+	}
+}
+
+func TestFixBraceFormattingFlag(t *testing.T) {
+	src := "syntax = \"proto3\";\nmessage TestEvent {\n  string id = 1;}\n"
+	out := generate(t, map[string]string{testEventFile: src}, "--fix-brace-formatting")
+	if data := readFile(t, filepath.Join(out, testEventSchema+".schema.yaml")); !strings.Contains(data, "    string id = 1;\n    }\n") {
+		t.Errorf("schema:\n%s", data)
+	}
+	out = generate(t, map[string]string{testEventFile: src})
+	if data := readFile(t, filepath.Join(out, testEventSchema+".schema.yaml")); !strings.Contains(data, "string id = 1;}\n") {
+		t.Errorf("braces changed without --fix-brace-formatting:\n%s", data)
+	}
+	err := generateErr(t, map[string]string{testEventFile: src + "}\n"}, "--fix-brace-formatting")
+	if !strings.Contains(err.Error(), testEventFile+": line 4: unbalanced }") {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// bufVersionSuffix matches the package version component Buf's
+// PACKAGE_VERSION_SUFFIX lint rule accepts, e.g. v1, v2beta1, v1test.
+var bufVersionSuffix = regexp.MustCompile(`^v\d+((alpha|beta)\d*|p\d+(alpha|beta)\d*|test\w*)?$`)
+
+// bufIndexEntry is one generated schema, collected when --buf-index is set.
+type bufIndexEntry struct {
+	pkg    string
+	schema string
+	source string
+	output string
+}
+
+type bufIndex struct {
+	Module   string       `json:"module"`
+	Packages []bufPackage `json:"packages"`
+}
+
+type bufPackage struct {
+	Name string `json:"name"`
+	// Version is the package's version suffix; it is omitted for packages
+	// without one.
+	Version string         `json:"version,omitempty"`
+	Files   []bufIndexFile `json:"files"`
+}
+
+type bufIndexFile struct {
+	Schema string `json:"schema"`
+	Source string `json:"source"`
+	Output string `json:"output"`
+}
+
+// bufIndexPackage is the group of in in the index: its package as
+// inputPackage finds it, or, when not even the filename has one, its schema
+// name.
+func bufIndexPackage(in *schemaInput) (string, error) {
+	pf, err := in.proto()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pf.pkg != "":
+		return pf.pkg, nil
+	case in.strippedPackage != "":
+		return in.strippedPackage, nil
+	}
+	if pkg := filenamePackage(in.path); pkg != "" {
+		return pkg, nil
+	}
+	return in.name, nil
+}
+
+func bufPackageVersion(pkg string) string {
+	last := pkg[strings.LastIndex(pkg, ".")+1:]
+	if bufVersionSuffix.MatchString(last) {
+		return last
+	}
+	return ""
+}
+
+// bufConfig is the part of a buf.yaml that names its modules. Version v2
+// lists them under modules; v1 files are a single module at their directory.
+type bufConfig struct {
+	Version string `yaml:"version"`
+	Name    string `yaml:"name"`
+	Modules []struct {
+		Path string `yaml:"path"`
+		Name string `yaml:"name"`
+	} `yaml:"modules"`
+}
+
+// findBufModule reads the nearest buf.yaml in dir or above it and returns
+// the name of its module, or its path relative to the buf.yaml when it has
+// no name. Of several modules, the one containing dir is used; when none
+// does, the --pubsub-dir holds generated files from outside the modules, and
+// only a lone module is unambiguous.
+func findBufModule(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for root := abs; ; root = filepath.Dir(root) {
+		data, err := os.ReadFile(filepath.Join(root, "buf.yaml"))
+		if os.IsNotExist(err) {
+			if filepath.Dir(root) == root {
+				return "", fmt.Errorf("no buf.yaml in %s or above it; set --buf-module", dir)
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		module, err := bufConfigModule(data, root, abs)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", filepath.Join(root, "buf.yaml"), err)
+		}
+		return module, nil
+	}
+}
+
+// bufConfigModule picks the module of the buf.yaml data in root for dir.
+func bufConfigModule(data []byte, root, dir string) (string, error) {
+	var cfg bufConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return "", err
+	}
+	if cfg.Version != "v2" {
+		if cfg.Name != "" {
+			return cfg.Name, nil
+		}
+		return ".", nil
+	}
+	if len(cfg.Modules) == 0 {
+		return ".", nil
+	}
+	pick := -1
+	for i, m := range cfg.Modules {
+		if rel, err := filepath.Rel(filepath.Join(root, m.Path), dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			pick = i
+			break
+		}
+	}
+	if pick < 0 {
+		if len(cfg.Modules) > 1 {
+			return "", fmt.Errorf("%d modules and none contains %s; set --buf-module", len(cfg.Modules), dir)
+		}
+		pick = 0
+	}
+	if m := cfg.Modules[pick]; m.Name != "" {
+		return m.Name, nil
+	}
+	return filepath.ToSlash(filepath.Clean(cfg.Modules[pick].Path)), nil
+}
+
+// writeBufIndex writes the schemas grouped by proto package, both sorted by
+// name, under the --buf-module.
+func writeBufIndex(path string, p *plan, opts options) error {
+	byPkg := make(map[string][]bufIndexFile)
+	for _, e := range p.bufEntries {
+		byPkg[e.pkg] = append(byPkg[e.pkg], bufIndexFile{
+			Schema: e.schema,
+			Source: e.source,
+			Output: filepath.ToSlash(filepath.Join(p.outputDir, e.output)),
+		})
+	}
+	index := bufIndex{Module: opts.bufModule, Packages: []bufPackage{}}
+	for pkg, files := range byPkg {
+		sort.Slice(files, func(i, j int) bool { return files[i].Schema < files[j].Schema })
+		index.Packages = append(index.Packages, bufPackage{Name: pkg, Version: bufPackageVersion(pkg), Files: files})
+	}
+	sort.Slice(index.Packages, func(i, j int) bool { return index.Packages[i].Name < index.Packages[j].Name })
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data)+"\n")
+}
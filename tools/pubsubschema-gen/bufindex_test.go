@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBufPackageVersion(t *testing.T) {
+	tests := []struct {
+		pkg  string
+		want string
+	}{
+		{"coreapp.config.v1", "v1"},
+		{"coreapp.config.v2beta1", "v2beta1"},
+		{"coreapp.config.v1alpha", "v1alpha"},
+		{"coreapp.config.v1p1beta1", "v1p1beta1"},
+		{"coreapp.config.v1test", "v1test"},
+		{"v3", "v3"},
+		{"coreapp.config", ""},
+		{"coreapp.v1.config", ""},
+		{"coreapp.config.version", ""},
+		{"coreapp.config.V1", ""},
+		{"loose", ""},
+	}
+	for _, tt := range tests {
+		if got := bufPackageVersion(tt.pkg); got != tt.want {
+			t.Errorf("bufPackageVersion(%q) = %q, want %q", tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestBufIndex(t *testing.T) {
+	inputs := map[string]string{
+		"a.v1.Orders.pubsub.proto":     "syntax = \"proto3\";\npackage a.v1;\nmessage Orders {}\n",
+		"a.v1.Refund.pubsub.proto":     "syntax = \"proto3\";\npackage a.v1;\nmessage Refund {}\n",
+		"b.v2beta1.Audit.pubsub.proto": "syntax = \"proto3\";\npackage b.v2beta1;\nmessage Audit {}\n",
+		"c.Plain.pubsub.proto":         "syntax = \"proto3\";\npackage c;\nmessage Plain {}\n",
+		"d.v3.Unnamed.pubsub.proto":    "syntax = \"proto3\";\nmessage Unnamed {}\n",
+		"Loose.pubsub.proto":           "syntax = \"proto3\";\nmessage Loose {}\n",
+	}
+	tests := []struct {
+		name   string
+		args   []string
+		module string
+	}{
+		{name: "declared package", module: "proto"},
+		{name: "stripped package", args: []string{"--strip-package"}, module: "proto"},
+		{name: "module flag", args: []string{"--buf-module", "buf.build/coreapp/events"}, module: "buf.build/coreapp/events"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The inputs are generated outside the only module, like
+			// gen/proto/infra/pubsub next to proto.
+			root := t.TempDir()
+			in, out := filepath.Join(root, "gen", "pubsub"), filepath.Join(t.TempDir(), "out")
+			index := filepath.Join(t.TempDir(), "buf-index.json")
+			writeTree(t, root, map[string]string{"buf.yaml": "version: v2\nmodules:\n  - path: proto\n"})
+			writeTree(t, in, inputs)
+			args := append([]string{"--pubsub-dir", in, "--output-dir", out, "--buf-index", index}, tt.args...)
+			if _, stderr, err := runTool(t, args...); err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			var got bufIndex
+			if err := json.Unmarshal([]byte(readFile(t, index)), &got); err != nil {
+				t.Fatal(err)
+			}
+			file := func(schema, source string) bufIndexFile {
+				return bufIndexFile{Schema: schema, Source: source, Output: filepath.ToSlash(filepath.Join(out, schema+".schema.yaml"))}
+			}
+			want := bufIndex{
+				Module: tt.module,
+				Packages: []bufPackage{
+					{Name: "a.v1", Version: "v1", Files: []bufIndexFile{file("a-v1-orders", "a.v1.Orders.pubsub.proto"), file("a-v1-refund", "a.v1.Refund.pubsub.proto")}},
+					{Name: "b.v2beta1", Version: "v2beta1", Files: []bufIndexFile{file("b-v2beta1-audit", "b.v2beta1.Audit.pubsub.proto")}},
+					{Name: "c", Files: []bufIndexFile{file("c-plain", "c.Plain.pubsub.proto")}},
+					{Name: "d.v3", Version: "v3", Files: []bufIndexFile{file("d-v3-unnamed", "d.v3.Unnamed.pubsub.proto")}},
+					{Name: "loose", Files: []bufIndexFile{file("loose", "Loose.pubsub.proto")}},
+				},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("index =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFindBufModule(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		dir     string
+		want    string
+		wantErr string
+	}{
+		{name: "v2 lone module", config: "version: v2\nmodules:\n  - path: proto\n", dir: "gen/pubsub", want: "proto"},
+		{name: "v2 named module", config: "version: v2\nmodules:\n  - path: proto\n    name: buf.build/acme/events\n", dir: "gen/pubsub", want: "buf.build/acme/events"},
+		{name: "v2 containing module", config: "version: v2\nmodules:\n  - path: proto\n  - path: ./events/\n", dir: "events/pubsub", want: "events"},
+		{name: "v2 no modules", config: "version: v2\n", dir: "pubsub", want: "."},
+		{name: "v1 named", config: "version: v1\nname: buf.build/acme/events\n", dir: "pubsub", want: "buf.build/acme/events"},
+		{name: "v1 unnamed", config: "version: v1\n", dir: "pubsub", want: "."},
+		{name: "ambiguous", config: "version: v2\nmodules:\n  - path: proto\n  - path: vendor\n", dir: "gen/pubsub", wantErr: "2 modules and none contains"},
+		{name: "invalid", config: "version: [\n", dir: "pubsub", wantErr: "buf.yaml: yaml:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{"buf.yaml": tt.config, tt.dir + "/a.pubsub.proto": ""})
+			got, err := findBufModule(filepath.Join(root, tt.dir))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("findBufModule = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := findBufModule(t.TempDir()); err == nil || !strings.Contains(err.Error(), "set --buf-module") {
+		t.Errorf("without a buf.yaml: error = %v", err)
+	}
+}
+
+func TestBufModuleRequiresBufIndex(t *testing.T) {
+	err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--buf-module", "proto")
+	if !strings.Contains(err.Error(), "--buf-module requires --buf-index") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestBufIndexStateFile(t *testing.T) {
+	dir := t.TempDir()
+	err := generateErr(t, map[string]string{testEventFile: testEventProto},
+		"--buf-index", filepath.Join(dir, "buf-index.json"), "--buf-module", "proto", "--state-file", filepath.Join(dir, "state.json"))
+	if !strings.Contains(err.Error(), "--state-file skips unchanged inputs and cannot be combined with") {
+		t.Errorf("error = %v", err)
+	}
+}
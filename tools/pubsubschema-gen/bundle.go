@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const subscriptionSuffix = ".subscription.yaml"
+
+// subscriptionTopic is the topic in's subscriptions attach to: its own,
+// unless a +topic directive names another generated or hand-authored one.
+func subscriptionTopic(in *schemaInput) (string, error) {
+	topic, err := in.directive("topic")
+	if err != nil || topic == "" {
+		return in.name, err
+	}
+	if err := validateSchemaName(topic); err != nil {
+		return "", fmt.Errorf("%s: invalid +topic: %w", in.path, err)
+	}
+	return topic, nil
+}
+
+// subscriptionFile renders the --bundle subscription of consumer to topic.
+// It is named <schema>-<consumer>.
+func subscriptionFile(in *schemaInput, consumer, topic string) (plannedFile, error) {
+	name := in.name + "-" + sanitizeSchemaName(consumer)
+	if err := validateSchemaName(name); err != nil {
+		return plannedFile{}, fmt.Errorf("%s: subscription for consumer %q: %w", in.path, consumer, err)
+	}
+	var b strings.Builder
+	b.WriteString(generatedMarker + "\n")
+	b.WriteString("apiVersion: " + topicAPIVersion + "\n")
+	b.WriteString("kind: PubSubSubscription\n")
+	b.WriteString("metadata:\n")
+	b.WriteString("  name: " + name + "\n")
+	writeYAMLMap(&b, "  ", "labels", in.labels)
+	b.WriteString("spec:\n")
+	b.WriteString("  topicRef:\n")
+	b.WriteString("    name: " + topic + "\n")
+	return plannedFile{name: name + subscriptionSuffix, schemaName: in.name, contents: b.String(), ref: topic}, nil
+}
+
+// planBundle checks the references between the bundle's resources before
+// anything is written: every topic's schemaRef must name a generated schema
+// and every subscription's topicRef a generated topic or one hand-authored in
+// the output directory. It then marks subscription files no longer generated
+// as stale.
+func (p *plan) planBundle() error {
+	schemas := make(map[string]bool, len(p.schemas))
+	for _, s := range p.schemas {
+		schemas[s.schemaName] = true
+	}
+	topics, err := handAuthoredTopics(p.outputDir)
+	if err != nil {
+		return err
+	}
+	for _, t := range p.topics {
+		if !schemas[t.ref] {
+			return fmt.Errorf("topic %s references schema %s, which is not generated", t.schemaName, t.ref)
+		}
+		topics[t.schemaName] = true
+	}
+	seen := make(map[string]string, len(p.subscriptions))
+	names := make([]string, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		name := strings.TrimSuffix(sub.name, subscriptionSuffix)
+		if !topics[sub.ref] {
+			return fmt.Errorf("subscription %s references topic %s, which is neither generated nor defined in %s", name, sub.ref, p.outputDir)
+		}
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("subscription name %s derived from the consumers of both %s and %s", name, other, sub.schemaName)
+		}
+		seen[name] = sub.schemaName
+		names = append(names, name)
+	}
+	stale, err := staleFiles(p.outputDir, subscriptionSuffix, names)
+	if err != nil {
+		return err
+	}
+	p.stale = append(p.stale, stale...)
+	return nil
+}
+
+// handAuthoredTopics returns the names of the PubSubTopics defined in the
+// YAML files of dir that pruning leaves alone. A missing directory has none.
+func handAuthoredTopics(dir string) (map[string]bool, error) {
+	topics := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return topics, nil
+		}
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || prunedSuffix(name, false) != "" || !(strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc struct {
+				Kind     string `yaml:"kind"`
+				Metadata struct {
+					Name string `yaml:"name"`
+				} `yaml:"metadata"`
+			}
+			if err := dec.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Join(dir, name), err)
+			}
+			if doc.Kind == "PubSubTopic" && doc.Metadata.Name != "" {
+				topics[doc.Metadata.Name] = true
+			}
+		}
+	}
+	return topics, nil
+}
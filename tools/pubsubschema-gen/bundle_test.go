@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBundle(t *testing.T) {
+	inputs := map[string]string{
+		"a.v1.Orders.pubsub.proto":  "// +consumers: billing, audit\nsyntax = \"proto3\";\nmessage Orders {}\n",
+		"a.v1.Refunds.pubsub.proto": "// +consumers: ledger\n// +topic: a-v1-orders\nsyntax = \"proto3\";\nmessage Refunds {}\n",
+		"a.v1.Quiet.pubsub.proto":   "syntax = \"proto3\";\nmessage Quiet {}\n",
+	}
+	out := generate(t, inputs, "--bundle")
+	want := []string{
+		"a-v1-orders-audit.subscription.yaml",
+		"a-v1-orders-billing.subscription.yaml",
+		"a-v1-orders.schema.yaml",
+		"a-v1-orders.topic.yaml",
+		"a-v1-quiet.schema.yaml",
+		"a-v1-quiet.topic.yaml",
+		"a-v1-refunds-ledger.subscription.yaml",
+		"a-v1-refunds.schema.yaml",
+		"a-v1-refunds.topic.yaml",
+	}
+	if got := kustomizationResources(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+	for sub, topic := range map[string]string{
+		"a-v1-orders-audit":   "a-v1-orders",
+		"a-v1-orders-billing": "a-v1-orders",
+		"a-v1-refunds-ledger": "a-v1-orders",
+	} {
+		var doc struct {
+			Spec struct {
+				TopicRef struct {
+					Name string `yaml:"name"`
+				} `yaml:"topicRef"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, sub+subscriptionSuffix))), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Spec.TopicRef.Name != topic {
+			t.Errorf("%s topicRef = %s, want %s", sub, doc.Spec.TopicRef.Name, topic)
+		}
+	}
+	if got := readTopic(t, out, "a-v1-refunds").Spec.SchemaSettings.SchemaRef.Name; got != "a-v1-refunds" {
+		t.Errorf("a-v1-refunds topic schemaRef = %s", got)
+	}
+}
+
+func TestBundleReferences(t *testing.T) {
+	src := "// +consumers: ledger\n// +topic: shared-events\nsyntax = \"proto3\";\nmessage Refunds {}\n"
+	tests := []struct {
+		name     string
+		existing map[string]string
+		src      string
+		wantErr  string
+	}{
+		{
+			name:     "hand-authored topic",
+			existing: map[string]string{"shared.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: x\n---\napiVersion: " + topicAPIVersion + "\nkind: PubSubTopic\nmetadata:\n  name: shared-events\n"},
+			src:      src,
+		},
+		{
+			name:    "dangling topic",
+			src:     src,
+			wantErr: "subscription a-v1-refunds-ledger references topic shared-events, which is neither generated nor defined in ",
+		},
+		{
+			// A generated topic file is pruned, so it does not count.
+			name:     "stale generated topic",
+			existing: map[string]string{"shared-events.topic.yaml": "apiVersion: " + topicAPIVersion + "\nkind: PubSubTopic\nmetadata:\n  name: shared-events\n"},
+			src:      src,
+			wantErr:  "references topic shared-events, which is neither generated",
+		},
+		{
+			name:     "broken hand-authored file",
+			existing: map[string]string{"broken.yaml": "kind: [\n"},
+			src:      src,
+			wantErr:  "broken.yaml: yaml:",
+		},
+		{
+			name:    "invalid +topic",
+			src:     "// +consumers: ledger\n// +topic: Shared_Events\nsyntax = \"proto3\";\nmessage Refunds {}\n",
+			wantErr: "invalid +topic: schema name \"Shared_Events\"",
+		},
+		{
+			name:    "conflicting +topic",
+			src:     "// +consumers: ledger\n// +topic: shared-a\n// +topic: shared-b\nsyntax = \"proto3\";\nmessage Refunds {}\n",
+			wantErr: `conflicting +topic directives "shared-a" and "shared-b"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+			writeTree(t, in, map[string]string{"a.v1.Refunds.pubsub.proto": tt.src})
+			writeTree(t, out, tt.existing)
+			_, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--bundle")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := kustomizationResources(t, out); !reflect.DeepEqual(got, []string{"a-v1-refunds-ledger.subscription.yaml", "a-v1-refunds.schema.yaml", "a-v1-refunds.topic.yaml"}) {
+					t.Errorf("resources = %v", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			want := make(map[string]string)
+			for name, contents := range tt.existing {
+				want[name] = contents
+			}
+			if got := readTree(t, out); !reflect.DeepEqual(got, want) {
+				t.Errorf("output dir changed before the failure: %v", generatedFiles(t, out))
+			}
+		})
+	}
+}
+
+func TestBundleSubscriptionNameCollision(t *testing.T) {
+	err := generateErr(t, map[string]string{
+		"a.v1.Orders.pubsub.proto":    "// +consumers: eu-billing\nsyntax = \"proto3\";\nmessage Orders {}\n",
+		"a.v1.Orders_eu.pubsub.proto": "// +consumers: billing\nsyntax = \"proto3\";\nmessage Orders_eu {}\n",
+	}, "--bundle")
+	if !strings.Contains(err.Error(), "subscription name a-v1-orders-eu-billing derived from the consumers of both a-v1-orders and a-v1-orders-eu") {
+		t.Errorf("error = %v", err)
+	}
+}
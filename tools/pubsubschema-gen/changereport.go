@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Change statuses of a --change-report entry. A schema is breaking as soon as
+// one change is; unknown means the previous definition could not be read.
+const (
+	changeNew       = "new"
+	changeUnchanged = "unchanged"
+	changeAdditive  = "additive"
+	changeBreaking  = "breaking"
+	changeRemoved   = "removed"
+	changeUnknown   = "unknown"
+)
+
+// schemaChange is one --change-report entry: how a schema's new definition
+// differs from the one currently in --output-dir.
+type schemaChange struct {
+	Schema   string   `json:"schema"`
+	Status   string   `json:"status"`
+	Additive []string `json:"additive,omitempty"`
+	Breaking []string `json:"breaking,omitempty"`
+	Note     string   `json:"note,omitempty"`
+}
+
+// previousDefinition reads the definition from the schema file about to be
+// overwritten, or returns "" when there is none. In YAML modes it is the
+// definition field of the schema or, in configmap mode, the ConfigMap's.
+func previousDefinition(path string, opts options) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if opts.outputFormat == formatRaw {
+		def := strings.ReplaceAll(string(data), "\r\n", "\n")
+		def = strings.TrimPrefix(def, rawGeneratedMarker+"\n")
+		if opts.definitionLinePrefix != "" {
+			lines := strings.Split(def, "\n")
+			for i := range lines {
+				lines[i] = strings.TrimPrefix(lines[i], opts.definitionLinePrefix)
+			}
+			def = strings.Join(lines, "\n")
+		}
+		return def, nil
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	for {
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", fmt.Errorf("no definition found")
+			}
+			return "", fmt.Errorf("parsing YAML: %w", err)
+		}
+		keys := append([]string{"spec"}, opts.shape.definitionPath...)
+		if doc["kind"] == "ConfigMap" {
+			keys = []string{"data", "definition"}
+		}
+		var cur interface{} = doc
+		for _, k := range keys {
+			m, _ := cur.(map[string]interface{})
+			cur = m[k]
+		}
+		if def, ok := cur.(string); ok {
+			return def, nil
+		}
+	}
+}
+
+// compareDefinitions classifies the differences between two parsed
+// definitions. New messages, fields and enum values are additive; removed
+// ones, and fields or values whose number, type or label changed, are
+// potentially breaking. Messages and enums are matched by their nested path,
+// fields and values by name.
+func compareDefinitions(old, cur *protoFile) (additive, breaking []string) {
+	oldMsgs, curMsgs := messagesByPath(old), messagesByPath(cur)
+	for _, path := range messagePaths(oldMsgs) {
+		m, ok := curMsgs[path]
+		if !ok {
+			breaking = append(breaking, "message "+path+" removed")
+			continue
+		}
+		curFields := make(map[string]protoField, len(m.fields))
+		for _, f := range m.fields {
+			curFields[f.name] = f
+		}
+		oldFields := make(map[string]bool, len(oldMsgs[path].fields))
+		for _, f := range oldMsgs[path].fields {
+			oldFields[f.name] = true
+			nf, ok := curFields[f.name]
+			switch {
+			case !ok:
+				breaking = append(breaking, fmt.Sprintf("field %s.%s = %d removed", path, f.name, f.number))
+			case nf.number != f.number:
+				breaking = append(breaking, fmt.Sprintf("field %s.%s renumbered from %d to %d", path, f.name, f.number, nf.number))
+			case nf.typeString() != f.typeString():
+				breaking = append(breaking, fmt.Sprintf("field %s.%s changed from %s to %s", path, f.name, f.typeString(), nf.typeString()))
+			}
+		}
+		for _, f := range m.fields {
+			if !oldFields[f.name] {
+				additive = append(additive, fmt.Sprintf("field %s.%s = %d added", path, f.name, f.number))
+			}
+		}
+	}
+	for _, path := range messagePaths(curMsgs) {
+		if _, ok := oldMsgs[path]; !ok {
+			additive = append(additive, "message "+path+" added")
+		}
+	}
+	oldEnums, curEnums := enumsByPath(old), enumsByPath(cur)
+	for _, path := range enumPaths(oldEnums) {
+		e, ok := curEnums[path]
+		if !ok {
+			breaking = append(breaking, "enum "+path+" removed")
+			continue
+		}
+		curValues := make(map[string]int, len(e.values))
+		for _, v := range e.values {
+			curValues[v.name] = v.number
+		}
+		oldValues := make(map[string]bool, len(oldEnums[path].values))
+		for _, v := range oldEnums[path].values {
+			oldValues[v.name] = true
+			n, ok := curValues[v.name]
+			switch {
+			case !ok:
+				breaking = append(breaking, fmt.Sprintf("enum value %s.%s = %d removed", path, v.name, v.number))
+			case n != v.number:
+				breaking = append(breaking, fmt.Sprintf("enum value %s.%s renumbered from %d to %d", path, v.name, v.number, n))
+			}
+		}
+		for _, v := range e.values {
+			if !oldValues[v.name] {
+				additive = append(additive, fmt.Sprintf("enum value %s.%s = %d added", path, v.name, v.number))
+			}
+		}
+	}
+	for _, path := range enumPaths(curEnums) {
+		if _, ok := oldEnums[path]; !ok {
+			additive = append(additive, "enum "+path+" added")
+		}
+	}
+	return additive, breaking
+}
+
+func messagesByPath(pf *protoFile) map[string]*protoMessage {
+	msgs := make(map[string]*protoMessage)
+	pf.walkMessages(func(path string, m *protoMessage) { msgs[path] = m })
+	return msgs
+}
+
+func enumsByPath(pf *protoFile) map[string]*protoEnum {
+	enums := make(map[string]*protoEnum)
+	for _, e := range pf.enums {
+		enums[e.name] = e
+	}
+	pf.walkMessages(func(path string, m *protoMessage) {
+		for _, e := range m.enums {
+			enums[path+"."+e.name] = e
+		}
+	})
+	return enums
+}
+
+func messagePaths(msgs map[string]*protoMessage) []string {
+	paths := make([]string, 0, len(msgs))
+	for path := range msgs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func enumPaths(enums map[string]*protoEnum) []string {
+	paths := make([]string, 0, len(enums))
+	for path := range enums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// schemaChangeFor compares in's definition with the one in the output
+// directory.
+func schemaChangeFor(in *schemaInput, file string, opts options) (schemaChange, error) {
+	c := schemaChange{Schema: in.name}
+	prev, err := previousDefinition(filepath.Join(opts.outputDir, file), opts)
+	switch {
+	case err != nil:
+		c.Status, c.Note = changeUnknown, "previous definition: "+err.Error()
+		return c, nil
+	case prev == "":
+		c.Status = changeNew
+		return c, nil
+	}
+	cur, err := in.proto()
+	if err != nil {
+		return c, err
+	}
+	old, err := parseProto(prev)
+	if err != nil {
+		c.Status, c.Note = changeUnknown, "previous definition: "+err.Error()
+		return c, nil
+	}
+	c.Additive, c.Breaking = compareDefinitions(old, cur)
+	switch {
+	case len(c.Breaking) > 0:
+		c.Status = changeBreaking
+	case len(c.Additive) > 0:
+		c.Status = changeAdditive
+	default:
+		c.Status = changeUnchanged
+	}
+	return c, nil
+}
+
+// writeChangeReport writes the per-schema changes sorted by schema name,
+// including the schemas about to be pruned.
+func writeChangeReport(path string, p *plan, opts options) error {
+	changes := append([]schemaChange{}, p.changes...)
+	for _, name := range p.stale {
+		if strings.HasSuffix(name, opts.outputSuffix()) {
+			changes = append(changes, schemaChange{Schema: strings.TrimSuffix(name, opts.outputSuffix()), Status: changeRemoved})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Schema < changes[j].Schema })
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data)+"\n")
+}
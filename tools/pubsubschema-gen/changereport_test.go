@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareDefinitions(t *testing.T) {
+	const base = "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n  repeated int32 n = 2;\n  message Inner {\n    bool ok = 1;\n  }\n}\nenum K {\n  K_UNSPECIFIED = 0;\n  K_A = 1;\n}\n"
+	tests := []struct {
+		name         string
+		cur          string
+		wantAdditive []string
+		wantBreaking []string
+	}{
+		{name: "unchanged", cur: base},
+		{
+			name:         "reordered and commented",
+			cur:          "syntax = \"proto3\";\nenum K {\n  K_A = 1;\n  K_UNSPECIFIED = 0;\n}\n// E is an event.\nmessage E {\n  message Inner {\n    bool ok = 1;\n  }\n  repeated int32 n = 2;\n  string id = 1;\n}\n",
+			wantAdditive: nil,
+		},
+		{
+			name:         "additive",
+			cur:          "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n  repeated int32 n = 2;\n  string note = 3;\n  message Inner {\n    bool ok = 1;\n    int64 at = 2;\n  }\n}\nmessage F {}\nenum K {\n  K_UNSPECIFIED = 0;\n  K_A = 1;\n  K_B = 2;\n}\nenum L {\n  L_UNSPECIFIED = 0;\n}\n",
+			wantAdditive: []string{"field E.note = 3 added", "field E.Inner.at = 2 added", "message F added", "enum value K.K_B = 2 added", "enum L added"},
+		},
+		{
+			name:         "removed field and message",
+			cur:          "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n}\nenum K {\n  K_UNSPECIFIED = 0;\n  K_A = 1;\n}\n",
+			wantBreaking: []string{"field E.n = 2 removed", "message E.Inner removed"},
+		},
+		{
+			name:         "renumbered and retyped",
+			cur:          "syntax = \"proto3\";\nmessage E {\n  string id = 5;\n  int32 n = 2;\n  message Inner {\n    string ok = 1;\n  }\n}\nenum K {\n  K_UNSPECIFIED = 0;\n  K_A = 2;\n}\n",
+			wantBreaking: []string{"field E.id renumbered from 1 to 5", "field E.n changed from repeated int32 to int32", "field E.Inner.ok changed from bool to string", "enum value K.K_A renumbered from 1 to 2"},
+		},
+		{
+			name:         "removed enum and value",
+			cur:          "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n  repeated int32 n = 2;\n  message Inner {\n    bool ok = 1;\n  }\n  enum K {\n    K_UNSPECIFIED = 0;\n  }\n}\n",
+			wantAdditive: []string{"enum E.K added"},
+			wantBreaking: []string{"enum K removed"},
+		},
+		{
+			name:         "mixed",
+			cur:          "syntax = \"proto3\";\nmessage E {\n  repeated int32 n = 2;\n  string id2 = 1;\n  message Inner {\n    bool ok = 1;\n  }\n}\nenum K {\n  K_UNSPECIFIED = 0;\n}\n",
+			wantAdditive: []string{"field E.id2 = 1 added"},
+			wantBreaking: []string{"field E.id = 1 removed", "enum value K.K_A = 1 removed"},
+		},
+	}
+	old, err := parseProto(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur, err := parseProto(tt.cur)
+			if err != nil {
+				t.Fatal(err)
+			}
+			additive, breaking := compareDefinitions(old, cur)
+			if !reflect.DeepEqual(additive, tt.wantAdditive) {
+				t.Errorf("additive = %q, want %q", additive, tt.wantAdditive)
+			}
+			if !reflect.DeepEqual(breaking, tt.wantBreaking) {
+				t.Errorf("breaking = %q, want %q", breaking, tt.wantBreaking)
+			}
+		})
+	}
+}
+
+func TestChangeReport(t *testing.T) {
+	before := map[string]string{
+		"a.v1.Same.pubsub.proto":  "syntax = \"proto3\";\npackage a.v1;\nmessage Same {\n  string id = 1;\n}\n",
+		"a.v1.Grow.pubsub.proto":  "syntax = \"proto3\";\npackage a.v1;\nmessage Grow {\n  string id = 1;\n}\n",
+		"a.v1.Break.pubsub.proto": "syntax = \"proto3\";\npackage a.v1;\nmessage Break {\n  string id = 1;\n  string name = 2;\n}\n",
+		"a.v1.Gone.pubsub.proto":  "syntax = \"proto3\";\npackage a.v1;\nmessage Gone {}\n",
+	}
+	after := map[string]string{
+		"a.v1.Same.pubsub.proto":  before["a.v1.Same.pubsub.proto"],
+		"a.v1.Grow.pubsub.proto":  "syntax = \"proto3\";\npackage a.v1;\nmessage Grow {\n  string id = 1;\n  string note = 2;\n}\n",
+		"a.v1.Break.pubsub.proto": "syntax = \"proto3\";\npackage a.v1;\nmessage Break {\n  string id = 3;\n}\n",
+		"a.v1.Fresh.pubsub.proto": "syntax = \"proto3\";\npackage a.v1;\nmessage Fresh {}\n",
+	}
+	want := []schemaChange{
+		{Schema: "a-v1-break", Status: changeBreaking, Breaking: []string{"field Break.id renumbered from 1 to 3", "field Break.name = 2 removed"}},
+		{Schema: "a-v1-fresh", Status: changeNew},
+		{Schema: "a-v1-gone", Status: changeRemoved},
+		{Schema: "a-v1-grow", Status: changeAdditive, Additive: []string{"field Grow.note = 2 added"}},
+		{Schema: "a-v1-same", Status: changeUnchanged},
+	}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "config connector"},
+		{name: "crossplane", args: []string{"--output-format", "crossplane"}},
+		{name: "configmap", args: []string{"--definition-mode", "configmap"}},
+		{name: "raw", args: []string{"--output-format", "raw"}},
+		{name: "raw with line prefix", args: []string{"--output-format", "raw", "--definition-line-prefix", "# "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			report := filepath.Join(t.TempDir(), "changes.json")
+			writeTree(t, in, before)
+			if _, stderr, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", out}, tt.args...)...); err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			in = filepath.Join(t.TempDir(), "pubsub")
+			writeTree(t, in, after)
+			if _, stderr, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", out, "--change-report", report}, tt.args...)...); err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			var got []schemaChange
+			if err := json.Unmarshal([]byte(readFile(t, report)), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("report =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestChangeReportUnreadablePrevious(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	report := filepath.Join(t.TempDir(), "changes.json")
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	writeTree(t, out, map[string]string{testEventSchema + ".schema.yaml": "apiVersion: v1\nkind: Other\n"})
+	if _, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--change-report", report); err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	var got []schemaChange
+	if err := json.Unmarshal([]byte(readFile(t, report)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []schemaChange{{Schema: testEventSchema, Status: changeUnknown, Note: "previous definition: no definition found"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+const defaultIOConcurrency = 8
+
+// semaphore bounds the number of concurrent filesystem operations.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n < 1 {
+		n = 1
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire() { s <- struct{}{} }
+func (s semaphore) release() { <-s }
+
+// forEachParallel runs fn for every index in [0, n) with at most sem's
+// capacity in flight, and joins the errors in index order so the result is
+// deterministic.
+func forEachParallel(n int, sem semaphore, fn func(i int) error) error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem.acquire()
+		go func(i int) {
+			defer wg.Done()
+			defer sem.release()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// readInput reads an input file under the --read-concurrency limit, through
+// opts.readFile when it is set.
+func (opts options) readInput(path string) ([]byte, error) {
+	if opts.readSem != nil {
+		opts.readSem.acquire()
+		defer opts.readSem.release()
+	}
+	if opts.readFile != nil {
+		return opts.readFile(path)
+	}
+	return os.ReadFile(path)
+}
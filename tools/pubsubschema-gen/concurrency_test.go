@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingReader is an instrumented options.readFile that records how many
+// reads are in flight at once and which files were read.
+type countingReader struct {
+	mu             sync.Mutex
+	inFlight, peak int
+	reads          map[string]int
+}
+
+func (r *countingReader) readFile(path string) ([]byte, error) {
+	r.mu.Lock()
+	r.inFlight++
+	if r.inFlight > r.peak {
+		r.peak = r.inFlight
+	}
+	r.reads[filepath.Base(path)]++
+	r.mu.Unlock()
+	// Slow storage: keep the read open long enough for others to pile up.
+	time.Sleep(2 * time.Millisecond)
+	defer func() {
+		r.mu.Lock()
+		r.inFlight--
+		r.mu.Unlock()
+	}()
+	return os.ReadFile(path)
+}
+
+func TestReadConcurrencyIsBounded(t *testing.T) {
+	inputs, root := inlineFixture(t)
+	for i := 0; i < 20; i++ {
+		inputs[fmt.Sprintf("a.v1.Event%02d.pubsub.proto", i)] = fmt.Sprintf("syntax = \"proto3\";\nimport \"b/v1/other.proto\";\nmessage Event%02d {\n  Other other = 1;\n}\n", i)
+	}
+	tests := []struct {
+		concurrency, readConcurrency int
+	}{
+		{8, 1},
+		{8, 2},
+		{2, 4},
+		{16, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("concurrency=%d read-concurrency=%d", tt.concurrency, tt.readConcurrency), func(t *testing.T) {
+			dir, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+			writeTree(t, dir, inputs)
+			var files []string
+			for name := range inputs {
+				files = append(files, filepath.Join(dir, name))
+			}
+			sort.Strings(files)
+			reader := &countingReader{reads: make(map[string]int)}
+			opts := testOptions()
+			opts.pubsubDir, opts.outputDir = dir, out
+			opts.protoRoots, opts.inlineImports = []string{root}, true
+			opts.concurrency, opts.readConcurrency = tt.concurrency, tt.readConcurrency
+			opts.readSem = newSemaphore(tt.readConcurrency)
+			opts.readFile = reader.readFile
+			if err := generateAll(files, opts); err != nil {
+				t.Fatal(err)
+			}
+			if reader.peak > tt.readConcurrency {
+				t.Errorf("%d reads in flight, want at most %d", reader.peak, tt.readConcurrency)
+			}
+			if reader.peak < tt.readConcurrency {
+				t.Errorf("at most %d reads in flight; the limit of %d was never reached", reader.peak, tt.readConcurrency)
+			}
+			// Imports are read through the same limit: every input imports
+			// other.proto, which imports common.proto.
+			want := map[string]int{"other.proto": len(inputs), "common.proto": len(inputs)}
+			for name := range inputs {
+				want[name] = 1
+			}
+			if len(reader.reads) != len(want) {
+				t.Errorf("read %d distinct files, want %d", len(reader.reads), len(want))
+			}
+			for name, n := range want {
+				if reader.reads[name] != n {
+					t.Errorf("%s read %d times, want %d", name, reader.reads[name], n)
+				}
+			}
+		})
+	}
+}
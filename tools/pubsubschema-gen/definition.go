@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defSegment is one contiguous part of an embedded definition and the file it
+// came from. A definition has a single segment unless imports are inlined.
+type defSegment struct {
+	source string
+	text   string
+}
+
+// transformDefinition applies the opt-in rewrites of the embedded definition.
+// It runs after normalizeNewlines and before any validation, so checks see
+// exactly what will be published. Text rewrites apply to each segment on its
+// own so the source map stays accurate.
+func transformDefinition(in *schemaInput, opts options) error {
+	if opts.stripPackage {
+		def, pkg, err := stripPackage(in.definition)
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+		in.definition, in.strippedPackage = def, pkg
+	}
+	segs := []defSegment{{source: in.rel, text: in.definition}}
+	if opts.inlineImports {
+		var err error
+		if segs, err = inlineImports(in, opts); err != nil {
+			return err
+		}
+	}
+	for i := range segs {
+		text, err := transformSegment(segs[i].text, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", segs[i].source, err)
+		}
+		segs[i].text = text
+	}
+	header := ""
+	if opts.fixProto3Syntax {
+		missing, err := missingSyntax(segs[0].text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+		if missing {
+			header = proto3SyntaxStatement + "\n"
+		}
+	}
+	if opts.definitionFilenameComment {
+		header += "// source: " + in.rel + "\n"
+	}
+	def, sourceMap := assembleDefinition(segs, 1+strings.Count(header, "\n"))
+	in.definition, in.sourceMap = header+def, sourceMap
+	in.parsed, in.parseErr = nil, nil
+	if opts.definitionFooter != "" {
+		// The footer is not part of any source, so the source map ignores it.
+		in.definition = normalizeNewlines(strings.TrimRight(in.definition, "\n") + "\n" + opts.definitionFooter)
+		if _, err := in.proto(); err != nil {
+			return fmt.Errorf("--definition-footer: %w", err)
+		}
+	}
+	return nil
+}
+
+// proto3SyntaxStatement is what --enforce-proto3 --fix adds to definitions
+// without a syntax statement.
+const proto3SyntaxStatement = `syntax = "proto3";`
+
+// missingSyntax reports whether src has neither a syntax nor an edition
+// statement.
+func missingSyntax(src string) (bool, error) {
+	stmts, err := topLevelStatements(src)
+	if err != nil {
+		return false, err
+	}
+	for _, st := range stmts {
+		if st.keyword == "syntax" || st.keyword == "edition" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func transformSegment(text string, opts options) (string, error) {
+	if opts.fieldFilterOption != "" {
+		var err error
+		if text, err = filterFields(text, opts.fieldFilterOption); err != nil {
+			return "", err
+		}
+	}
+	if opts.comments != commentsAll {
+		var err error
+		if text, err = stripComments(text, opts.comments == commentsTop); err != nil {
+			return "", err
+		}
+	}
+	if opts.fixBraceFormatting {
+		var err error
+		if text, err = fixBraceFormatting(text); err != nil {
+			return "", err
+		}
+	}
+	if opts.normalizeWhitespace {
+		var err error
+		if text, err = normalizeWhitespace(text); err != nil {
+			return "", err
+		}
+	}
+	if opts.trimLeadingBlankLines {
+		text = trimLeadingBlankLines(text)
+	}
+	return normalizeNewlines(text), nil
+}
+
+// normalizedIndent is one brace level of --normalize-whitespace indentation.
+const normalizedIndent = "  "
+
+// normalizeWhitespace rewrites only the whitespace between tokens: a run of
+// spaces and tabs becomes one space, trailing whitespace goes, and each line
+// is indented by its brace depth. Line breaks, strings and comments are kept
+// as they are.
+func normalizeWhitespace(src string) (string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	depth, pos := 0, 0
+	for _, t := range toks {
+		gap := src[pos:t.start]
+		if t.text == "}" && depth > 0 {
+			depth--
+		}
+		switch {
+		case strings.Contains(gap, "\n"):
+			b.WriteString(strings.Repeat("\n", strings.Count(gap, "\n")))
+			b.WriteString(strings.Repeat(normalizedIndent, depth))
+		case gap != "" && pos > 0:
+			b.WriteString(" ")
+		}
+		b.WriteString(t.text)
+		if t.text == "{" {
+			depth++
+		}
+		pos = t.end
+	}
+	b.WriteString(strings.Repeat("\n", strings.Count(src[pos:], "\n")))
+	return b.String(), nil
+}
+
+// trimLeadingBlankLines drops whitespace-only lines before the first line with
+// content. Interior blank lines are left alone.
+func trimLeadingBlankLines(s string) string {
+	for {
+		nl := strings.IndexByte(s, '\n')
+		if nl < 0 || strings.TrimSpace(s[:nl]) != "" {
+			return s
+		}
+		s = s[nl+1:]
+	}
+}
+
+// assembleDefinition joins segments with a blank line between them and
+// returns the definition with its source map: one "source:first-last" entry
+// per segment, using 1-based line numbers of the final definition, whose
+// first segment starts on line firstLine.
+func assembleDefinition(segs []defSegment, firstLine int) (string, string) {
+	var b strings.Builder
+	var entries []string
+	line := firstLine
+	for i, s := range segs {
+		if i > 0 {
+			b.WriteString("\n")
+			line++
+		}
+		n := strings.Count(s.text, "\n")
+		b.WriteString(s.text)
+		entries = append(entries, s.source+":"+strconv.Itoa(line)+"-"+strconv.Itoa(line+n-1))
+		line += n
+	}
+	return b.String(), strings.Join(entries, ",")
+}
+
+// stripPackage removes the top-level package statement from src and returns
+// the package name. Message bodies are not touched.
+func stripPackage(src string) (string, string, error) {
+	stmts, err := topLevelStatements(src)
+	if err != nil {
+		return "", "", err
+	}
+	for _, st := range stmts {
+		if st.keyword == "package" {
+			return cutRanges(src, [][2]int{{st.start, st.end}}), st.arg, nil
+		}
+	}
+	return src, "", nil
+}
+
+const (
+	commentsAll  = "all"
+	commentsTop  = "top"
+	commentsNone = "none"
+)
+
+// stripComments removes comments from src using the tokenizer, so comment
+// markers inside string literals are left alone. With keepTop, the comments
+// before the first declaration are retained.
+func stripComments(src string, keepTop bool) (string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+	var cuts [][2]int
+	seenDecl := false
+	for _, t := range toks {
+		if t.kind != tokComment {
+			seenDecl = true
+			continue
+		}
+		if keepTop && !seenDecl {
+			continue
+		}
+		cuts = append(cuts, [2]int{t.start, t.end})
+	}
+	return cutRanges(src, cuts), nil
+}
+
+// cutRanges removes the sorted, non-overlapping byte ranges from src. A range
+// that is alone on its line(s) is removed together with the line; one that
+// starts a line keeps the indentation and takes the whitespace after it;
+// otherwise it takes its leading whitespace with it.
+func cutRanges(src string, ranges [][2]int) string {
+	var b strings.Builder
+	pos := 0
+	for _, r := range ranges {
+		start, end := r[0], r[1]
+		for start > pos && (src[start-1] == ' ' || src[start-1] == '\t') {
+			start--
+		}
+		rest := end
+		for rest < len(src) && (src[rest] == ' ' || src[rest] == '\t') {
+			rest++
+		}
+		lineStart := start == 0 || src[start-1] == '\n'
+		switch {
+		case lineStart && (rest == len(src) || src[rest] == '\n'):
+			if rest < len(src) {
+				end = rest + 1
+			}
+		case lineStart:
+			start, end = r[0], rest
+		}
+		b.WriteString(src[pos:start])
+		pos = end
+	}
+	b.WriteString(src[pos:])
+	return b.String()
+}
+
+// topLevelStatement is a statement at file scope: its keyword (syntax,
+// package, import, option, message, ...), its argument (the import path or
+// package name), and its byte range including the terminating semicolon or
+// closing brace.
+type topLevelStatement struct {
+	keyword    string
+	arg        string
+	start, end int
+}
+
+func topLevelStatements(src string) ([]topLevelStatement, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []topLevelStatement
+	var cur *topLevelStatement
+	depth := 0
+	for _, t := range toks {
+		if t.kind == tokComment {
+			continue
+		}
+		if cur == nil {
+			if t.text == ";" {
+				continue
+			}
+			cur = &topLevelStatement{keyword: t.text, start: t.start}
+			continue
+		}
+		if cur.arg == "" {
+			switch {
+			case cur.keyword == "import" && t.kind == tokString:
+				cur.arg = unquote(t.text)
+			case cur.keyword == "package" && t.kind == tokIdent:
+				cur.arg = t.text
+			}
+		}
+		switch t.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+		// Blocks end definitions (message Foo { ... }); a brace inside an option
+		// value is still followed by the statement's semicolon.
+		blockEnd := t.text == "}" && cur.keyword != "option"
+		if depth == 0 && (t.text == ";" || blockEnd) {
+			cur.end = t.end
+			stmts = append(stmts, *cur)
+			cur = nil
+		}
+	}
+	if cur != nil {
+		return nil, fmt.Errorf("unterminated %s statement", cur.keyword)
+	}
+	return stmts, nil
+}
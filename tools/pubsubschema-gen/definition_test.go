@@ -0,0 +1,467 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestStripComments(t *testing.T) {
+	src := `// Top comment about the file.
+// Second line.
+syntax = "proto3";
+
+// Leading comment.
+message E {
+  string url = 1 [default = "http://example.com"]; // trailing
+  /* block */ string name = 2;
+}
+`
+	tests := []struct {
+		name    string
+		keepTop bool
+		want    string
+	}{
+		{
+			name: "none",
+			want: `syntax = "proto3";
+
+message E {
+  string url = 1 [default = "http://example.com"];
+  string name = 2;
+}
+`,
+		},
+		{
+			name:    "top",
+			keepTop: true,
+			want: `// Top comment about the file.
+// Second line.
+syntax = "proto3";
+
+message E {
+  string url = 1 [default = "http://example.com"];
+  string name = 2;
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripComments(src, tt.keepTop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("stripComments =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommentsFlag(t *testing.T) {
+	tests := []struct {
+		comments string
+		want     string
+	}{
+		{commentsAll, testEventProto},
+		{commentsTop, "syntax = \"proto3\";\n\npackage coreapp.test.v1;\n\nmessage TestEvent {\n  string id = 1;\n  string name = 2;\n}\n"},
+		{commentsNone, "syntax = \"proto3\";\n\npackage coreapp.test.v1;\n\nmessage TestEvent {\n  string id = 1;\n  string name = 2;\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comments, func(t *testing.T) {
+			opts := testOptions()
+			opts.comments = tt.comments
+			if got := testInput(t, testEventFile, testEventProto, opts).definition; got != tt.want {
+				t.Errorf("definition =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		cut  string
+		want string
+	}{
+		{"own line", "a;\n  // c\nb;\n", "// c", "a;\nb;\n"},
+		{"trailing", "a; // c\nb;\n", "// c", "a;\nb;\n"},
+		{"leading keeps indentation", "  /* c */ b;\n", "/* c */", "  b;\n"},
+		{"end of file", "a;\n// c", "// c", "a;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := strings.Index(tt.src, tt.cut)
+			if got := cutRanges(tt.src, [][2]int{{start, start + len(tt.cut)}}); got != tt.want {
+				t.Errorf("cutRanges = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// inlineFixture is an input importing two files under one --proto-root, one
+// of which imports the other.
+func inlineFixture(t *testing.T) (inputs map[string]string, root string) {
+	t.Helper()
+	root = t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a/v1/common.proto": "syntax = \"proto3\";\n\npackage a.v1;\n\nimport \"google/protobuf/timestamp.proto\";\n\nmessage Common {\n  google.protobuf.Timestamp at = 1;\n}\n",
+		"b/v1/other.proto":  "syntax = \"proto3\";\n\npackage b.v1;\n\nimport \"a/v1/common.proto\";\n\nmessage Other {\n  string id = 1;\n}\n",
+	})
+	src := "syntax = \"proto3\";\n\npackage coreapp.test.v1;\n\nimport \"b/v1/other.proto\";\nimport \"a/v1/common.proto\";\n\nmessage TestEvent {\n  Common common = 1;\n  Other other = 2;\n}\n"
+	return map[string]string{testEventFile: src}, root
+}
+
+func TestSourceMapReflectsInlinedSegments(t *testing.T) {
+	inputs, root := inlineFixture(t)
+	tests := []struct {
+		name string
+		args []string
+		// want maps each source-map entry to the text of its line range.
+		want []string
+	}{
+		{
+			name: "single source",
+			want: []string{testEventFile + ":1-11"},
+		},
+		{
+			name: "inlined",
+			args: []string{"--proto-root", root, "--inline-imports"},
+			want: []string{testEventFile + ":1-10", "a/v1/common.proto:12-14", "b/v1/other.proto:16-18"},
+		},
+		{
+			name: "after a filename comment",
+			args: []string{"--proto-root", root, "--inline-imports", "--definition-filename-comment"},
+			want: []string{testEventFile + ":2-11", "a/v1/common.proto:13-15", "b/v1/other.proto:17-19"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, inputs, append([]string{"--annotate-source-map"}, tt.args...)...)
+			var doc struct {
+				Metadata struct {
+					Annotations map[string]string `yaml:"annotations"`
+				} `yaml:"metadata"`
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			sourceMap := doc.Metadata.Annotations[annotationPrefix+"source-map"]
+			if got := strings.Split(sourceMap, ","); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("source map = %v, want %v", got, tt.want)
+			}
+			lines := strings.Split(doc.Spec.Definition, "\n")
+			for _, entry := range tt.want {
+				source, span, _ := strings.Cut(entry, ":")
+				first, last, _ := strings.Cut(span, "-")
+				from, _ := strconv.Atoi(first)
+				to, _ := strconv.Atoi(last)
+				text := strings.Join(lines[from-1:to], "\n")
+				// Every range starts at its file's first kept line and ends at
+				// the closing brace of its last message.
+				if source != testEventFile && !strings.HasPrefix(text, "message ") {
+					t.Errorf("%s starts with %q, want its message", entry, lines[from-1])
+				}
+				if lines[to-1] != "}" {
+					t.Errorf("%s ends with %q, want a closing brace", entry, lines[to-1])
+				}
+			}
+		})
+	}
+}
+
+func TestInlineImportsDefinition(t *testing.T) {
+	inputs, root := inlineFixture(t)
+	opts := testOptions()
+	opts.protoRoots, opts.inlineImports = []string{root}, true
+	got := testInput(t, testEventFile, inputs[testEventFile], opts).definition
+	want := `syntax = "proto3";
+import "google/protobuf/timestamp.proto";
+
+package coreapp.test.v1;
+
+
+message TestEvent {
+  Common common = 1;
+  Other other = 2;
+}
+
+message Common {
+  google.protobuf.Timestamp at = 1;
+}
+
+message Other {
+  string id = 1;
+}
+`
+	if got != want {
+		t.Errorf("definition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStripPackage(t *testing.T) {
+	body := "message E {\n  // package x.y; inside a comment\n  string package = 1;\n}\n"
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantPkg string
+	}{
+		{
+			name:    "with package",
+			src:     "syntax = \"proto3\";\npackage coreapp.test.v1;\n" + body,
+			want:    "syntax = \"proto3\";\n" + body,
+			wantPkg: "coreapp.test.v1",
+		},
+		{
+			name: "without package",
+			src:  "syntax = \"proto3\";\n" + body,
+			want: "syntax = \"proto3\";\n" + body,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, pkg, err := stripPackage(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || pkg != tt.wantPkg {
+				t.Errorf("stripPackage = %q, %q; want %q, %q", got, pkg, tt.want, tt.wantPkg)
+			}
+		})
+	}
+}
+
+func TestStripPackageLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		args []string
+		want string
+	}{
+		{"stripped", testEventProto, []string{"--strip-package"}, "coreapp.test.v1"},
+		{"kept", testEventProto, nil, "coreapp.test.v1"},
+		// Without a package statement the filename supplies it.
+		{"from filename", "syntax = \"proto3\";\nmessage TestEvent {}\n", []string{"--strip-package"}, "coreapp.test.v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: tt.src}, append([]string{"--package-label", "proto-package"}, tt.args...)...)
+			var doc struct {
+				Metadata struct {
+					Labels map[string]string `yaml:"labels"`
+				} `yaml:"metadata"`
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := doc.Metadata.Labels["proto-package"]; got != tt.want {
+				t.Errorf("proto-package label = %q, want %q", got, tt.want)
+			}
+			if stripped := !strings.Contains(doc.Spec.Definition, "package "); stripped != (len(tt.args) > 0) {
+				t.Errorf("definition:\n%s", doc.Spec.Definition)
+			}
+		})
+	}
+}
+
+func TestTrimLeadingBlankLines(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"none", "syntax = \"proto3\";\n", "syntax = \"proto3\";\n"},
+		{"blank lines", "\n\n\nsyntax = \"proto3\";\n", "syntax = \"proto3\";\n"},
+		{"whitespace-only lines", "  \n\t\n \r\nsyntax = \"proto3\";\n", "syntax = \"proto3\";\n"},
+		{"interior blanks kept", "\nsyntax = \"proto3\";\n\n\nmessage E {}\n", "syntax = \"proto3\";\n\n\nmessage E {}\n"},
+		{"leading indentation kept", "\n  syntax = \"proto3\";\n", "  syntax = \"proto3\";\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimLeadingBlankLines(tt.src); got != tt.want {
+				t.Errorf("trimLeadingBlankLines(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimLeadingBlankLinesFlag(t *testing.T) {
+	src := "\n\n\n" + testEventProto
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default keeps them", nil, src},
+		{"trimmed", []string{"--trim-leading-blank-lines"}, testEventProto},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: src}, tt.args...)
+			var doc struct {
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Spec.Definition != tt.want {
+				t.Errorf("spec.definition = %q, want %q", doc.Spec.Definition, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"runs of spaces and tabs", "message  E\t{ }\n", "message E { }\n"},
+		{"trailing whitespace", "message E {}  \t\n", "message E {}\n"},
+		{"indentation by depth", "message E {\n\tmessage F {\n string id = 1;\n        }\n}\n", "message E {\n  message F {\n    string id = 1;\n  }\n}\n"},
+		{"gaps are not added", "string id=1;\n", "string id=1;\n"},
+		{"strings kept", "string s = 1 [default = \"a  b\\t  \"];\n", "string s = 1 [default = \"a  b\\t  \"];\n"},
+		{"comments kept", "//   spaced    comment  \n/* a\n   b */\n", "//   spaced    comment  \n/* a\n   b */\n"},
+		{"blank lines kept", "a;\n\n\n  b;\n", "a;\n\n\nb;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeWhitespace(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeWhitespace(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWhitespaceEquivalentInputs(t *testing.T) {
+	canonical := "syntax = \"proto3\";\nmessage E {\n  string id = 1 [default = \"a  b\"];\n  message Inner {\n    int32 n = 1;\n  }\n}\n"
+	variants := []string{
+		canonical,
+		"syntax   =\t\"proto3\";\nmessage  E  {\n\tstring   id = 1   [default =  \"a  b\"];\n  message Inner {\n        int32 n = 1;   \n }\n}\n",
+		"syntax = \"proto3\";  \nmessage E {\t\n    string id = 1 [default = \"a  b\"];\n    message Inner {\n        int32 n = 1;\n    }\n}   \n",
+	}
+	want := readTree(t, generate(t, map[string]string{testEventFile: canonical}))
+	for i, src := range variants {
+		if got := readTree(t, generate(t, map[string]string{testEventFile: src}, "--normalize-whitespace")); !reflect.DeepEqual(got, want) {
+			t.Errorf("variant %d generates\n%v\nwant\n%v", i, got, want)
+		}
+	}
+}
+
+func TestDefinitionFooter(t *testing.T) {
+	tests := []struct {
+		name   string
+		footer string
+		want   string
+	}{
+		{"comment", "// end of TestEvent", testEventProto + "// end of TestEvent\n"},
+		{"trailing newlines folded", "// end\n\n\n", testEventProto + "// end\n"},
+		{"several lines", "// generated from coreapp\n// do not edit", testEventProto + "// generated from coreapp\n// do not edit\n"},
+		{"declaration", "message Trailer {}", testEventProto + "message Trailer {}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: testEventProto + "\n\n"}, "--definition-footer", tt.footer)
+			var doc struct {
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Spec.Definition != tt.want {
+				t.Errorf("spec.definition = %q, want %q", doc.Spec.Definition, tt.want)
+			}
+			if _, err := parseProto(doc.Spec.Definition); err != nil {
+				t.Errorf("definition does not re-parse: %v", err)
+			}
+		})
+	}
+}
+
+func TestDefinitionFooterMustParse(t *testing.T) {
+	err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--definition-footer", "/* unterminated")
+	if !strings.HasPrefix(err.Error(), "--definition-footer: parse ") || !strings.Contains(err.Error(), testEventFile) {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestDefinitionFilenameComment(t *testing.T) {
+	noSyntax := strings.Replace(testEventProto, "syntax = \"proto3\";\n\n", "", 1)
+	tests := []struct {
+		name string
+		file string
+		src  string
+		args []string
+		want string
+	}{
+		{"off by default", testEventFile, testEventProto, nil, testEventProto},
+		{"top level", testEventFile, testEventProto, []string{"--definition-filename-comment"}, "// source: " + testEventFile + "\n" + testEventProto},
+		{"nested path", "billing/" + testEventFile, testEventProto, []string{"--definition-filename-comment", "--glob", "*/*.pubsub.proto"}, "// source: billing/" + testEventFile + "\n" + testEventProto},
+		{"after an added syntax", testEventFile, noSyntax, []string{"--definition-filename-comment", "--enforce-proto3", "--fix"}, "syntax = \"proto3\";\n// source: " + testEventFile + "\n" + noSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{tt.file: tt.src}, tt.args...)
+			var doc struct {
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Spec.Definition != tt.want {
+				t.Errorf("spec.definition = %q, want %q", doc.Spec.Definition, tt.want)
+			}
+			pf, err := parseProto(doc.Spec.Definition)
+			if err != nil {
+				t.Fatalf("definition does not re-parse: %v", err)
+			}
+			if pf.pkg != "coreapp.test.v1" || len(pf.messages) != 1 || pf.messages[0].name != "TestEvent" {
+				t.Errorf("definition parses as package %q with messages %+v", pf.pkg, pf.messages)
+			}
+		})
+	}
+}
+
+func TestMissingSyntax(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{src: "syntax = \"proto3\";\nmessage E {}\n", want: false},
+		{src: "// leading comment\nsyntax = \"proto2\";\nmessage E {}\n", want: false},
+		{src: "edition = \"2023\";\nmessage E {}\n", want: false},
+		{src: "package a.v1;\nmessage E {}\n", want: true},
+		{src: "// syntax = \"proto3\";\nmessage E {}\n", want: true},
+		{src: "message E {\n  string syntax = 1;\n}\n", want: true},
+		{src: "", want: true},
+	}
+	for _, tt := range tests {
+		got, err := missingSyntax(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("missingSyntax(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fieldOptionsExtendee is the message custom field options extend.
+const fieldOptionsExtendee = ".google.protobuf.FieldOptions"
+
+// descriptorSetSource decodes a binary FileDescriptorSet and re-emits its last
+// file, the one protoc was asked to compile, as proto source. Fields whose
+// options set the boolean extension filterOption are dropped from the
+// descriptor first. Build the set with --include_imports so the extension's
+// declaration is in it, and with --include_source_info to keep the comments,
+// and with them the +directives.
+func descriptorSetSource(raw []byte, filterOption string) (string, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return "", fmt.Errorf("parsing descriptor set: %w", err)
+	}
+	if len(set.File) == 0 {
+		return "", fmt.Errorf("descriptor set has no files")
+	}
+	var filter protowire.Number
+	if filterOption != "" {
+		var err error
+		if filter, err = findFieldOption(set.File, filterOption); err != nil {
+			return "", err
+		}
+	}
+	fd := set.File[len(set.File)-1]
+	src, err := printDescriptor(fd, set.File[:len(set.File)-1], filter)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", fd.GetName(), err)
+	}
+	return src, nil
+}
+
+// findFieldOption returns the field number of the boolean FieldOptions
+// extension named option in files. Parentheses and a leading dot are
+// optional, and an unqualified name matches the extension in any package, as
+// long as only one does.
+func findFieldOption(files []*descriptorpb.FileDescriptorProto, option string) (protowire.Number, error) {
+	want := strings.TrimPrefix(strings.Trim(option, "()"), ".")
+	var matches []string
+	var found *descriptorpb.FieldDescriptorProto
+	var walk func(scope string, exts []*descriptorpb.FieldDescriptorProto, msgs []*descriptorpb.DescriptorProto)
+	walk = func(scope string, exts []*descriptorpb.FieldDescriptorProto, msgs []*descriptorpb.DescriptorProto) {
+		for _, ext := range exts {
+			full := joinScope(scope, ext.GetName())
+			if ext.GetExtendee() == fieldOptionsExtendee && (full == want || strings.HasSuffix(full, "."+want)) {
+				matches = append(matches, full)
+				found = ext
+			}
+		}
+		for _, m := range msgs {
+			walk(joinScope(scope, m.GetName()), m.Extension, m.NestedType)
+		}
+	}
+	for _, fd := range files {
+		walk(fd.GetPackage(), fd.Extension, fd.MessageType)
+	}
+	switch {
+	case len(matches) == 0:
+		return 0, fmt.Errorf("--field-filter-option: no extension of google.protobuf.FieldOptions named %s in the descriptor set (build it with --include_imports)", want)
+	case len(matches) > 1:
+		return 0, fmt.Errorf("--field-filter-option: %s is ambiguous: %s", want, strings.Join(matches, ", "))
+	case found.GetType() != descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return 0, fmt.Errorf("--field-filter-option: %s is not a bool option", matches[0])
+	}
+	return protowire.Number(found.GetNumber()), nil
+}
+
+func joinScope(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// fieldOptionSet reports whether the options of f set the boolean extension
+// num to true. The extension is not registered, so it is read from the
+// options' unknown fields; like the protobuf runtime, the last value wins.
+func fieldOptionSet(f *descriptorpb.FieldDescriptorProto, num protowire.Number) bool {
+	if num == 0 || f.Options == nil {
+		return false
+	}
+	set := false
+	b := f.Options.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return false
+		}
+		b = b[l:]
+		if n == num && typ == protowire.VarintType {
+			v, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return false
+			}
+			set, b = v != 0, b[m:]
+			continue
+		}
+		m := protowire.ConsumeFieldValue(n, typ, b)
+		if m < 0 {
+			return false
+		}
+		b = b[m:]
+	}
+	return set
+}
+
+// Field numbers in descriptor.proto, used to build SourceCodeInfo paths.
+const (
+	fileSyntaxPath      = 12
+	filePackagePath     = 2
+	fileDependencyPath  = 3
+	fileMessagePath     = 4
+	fileEnumPath        = 5
+	messageFieldPath    = 2
+	messageNestedPath   = 3
+	messageEnumPath     = 4
+	messageExtRangePath = 5
+	messageOneofPath    = 8
+	messageReservedPath = 9
+	messageResNamePath  = 10
+	enumValuePath       = 2
+	enumReservedPath    = 4
+	enumResNamePath     = 5
+)
+
+// descriptorPrinter re-emits a FileDescriptorProto as proto source. Services,
+// extensions and custom options are left out: a Pub/Sub definition only uses
+// the messages and enums. Imports that only served what was left out are
+// dropped too.
+type descriptorPrinter struct {
+	b      strings.Builder
+	filter protowire.Number
+	// used are the full names of the message and enum types the printed
+	// fields reference.
+	used map[string]bool
+	// locations are the source locations by path, when the set was built with
+	// --include_source_info.
+	locations map[string]*descriptorpb.SourceCodeInfo_Location
+	// mapEntries are the synthesized map entry messages by full name.
+	mapEntries map[string]*descriptorpb.DescriptorProto
+	proto3     bool
+}
+
+// printDescriptor prints fd. imports are the other files of the descriptor
+// set, which tell which of fd's imports the printed source still needs.
+func printDescriptor(fd *descriptorpb.FileDescriptorProto, imports []*descriptorpb.FileDescriptorProto, filter protowire.Number) (string, error) {
+	p := &descriptorPrinter{
+		filter:     filter,
+		used:       make(map[string]bool),
+		locations:  make(map[string]*descriptorpb.SourceCodeInfo_Location),
+		mapEntries: make(map[string]*descriptorpb.DescriptorProto),
+	}
+	for _, loc := range fd.GetSourceCodeInfo().GetLocation() {
+		if key := pathKey(loc.Path); p.locations[key] == nil {
+			p.locations[key] = loc
+		}
+	}
+	var collect func(scope string, msgs []*descriptorpb.DescriptorProto)
+	collect = func(scope string, msgs []*descriptorpb.DescriptorProto) {
+		for _, m := range msgs {
+			full := scope + "." + m.GetName()
+			if m.GetOptions().GetMapEntry() {
+				p.mapEntries[full] = m
+			}
+			collect(full, m.NestedType)
+		}
+	}
+	pkg := ""
+	if fd.GetPackage() != "" {
+		pkg = "." + fd.GetPackage()
+	}
+	collect(pkg, fd.MessageType)
+
+	syntax := fd.GetSyntax()
+	switch syntax {
+	case "":
+		syntax = "proto2"
+	case "proto2", "proto3":
+	default:
+		return "", fmt.Errorf("syntax %q is not supported in descriptor sets", syntax)
+	}
+	p.proto3 = syntax == "proto3"
+	p.statement("", descPath(nil, fileSyntaxPath), "syntax = "+strconv.Quote(syntax)+";")
+	if fd.Package != nil {
+		p.b.WriteString("\n")
+		p.statement("", descPath(nil, filePackagePath), "package "+fd.GetPackage()+";")
+	}
+	// The declarations go first so the imports they reference are known.
+	header := p.b.String()
+	p.b.Reset()
+	var items []declItem
+	for i, m := range fd.MessageType {
+		m, at := m, descPath(nil, fileMessagePath, i)
+		items = append(items, declItem{at, func() error { return p.message("", at, pkg, m) }})
+	}
+	for i, e := range fd.EnumType {
+		e, at := e, descPath(nil, fileEnumPath, i)
+		items = append(items, declItem{at, func() error { return p.enum("", at, e) }})
+	}
+	for _, it := range p.sorted(items) {
+		p.b.WriteString("\n")
+		if err := it.print(); err != nil {
+			return "", err
+		}
+	}
+	body := p.b.String()
+	p.b.Reset()
+	p.b.WriteString(header)
+	needed := neededImports(fd, imports, p.used)
+	first := true
+	for i, dep := range fd.Dependency {
+		if !needed[dep] {
+			continue
+		}
+		if first {
+			p.b.WriteString("\n")
+			first = false
+		}
+		kind := ""
+		if containsIndex(fd.PublicDependency, i) {
+			kind = "public "
+		} else if containsIndex(fd.WeakDependency, i) {
+			kind = "weak "
+		}
+		p.statement("", descPath(nil, fileDependencyPath, i), "import "+kind+strconv.Quote(dep)+";")
+	}
+	p.b.WriteString(body)
+	return p.b.String(), nil
+}
+
+// neededImports returns the dependencies of fd that declare a type in used,
+// themselves or through their public imports. A dependency missing from
+// files, as in a set built without --include_imports, is kept, since what it
+// declares is unknown.
+func neededImports(fd *descriptorpb.FileDescriptorProto, files []*descriptorpb.FileDescriptorProto, used map[string]bool) map[string]bool {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto, len(files))
+	for _, f := range files {
+		byName[f.GetName()] = f
+	}
+	var provides func(name string, seen map[string]bool) bool
+	provides = func(name string, seen map[string]bool) bool {
+		f := byName[name]
+		if f == nil {
+			return true
+		}
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+		for _, t := range declaredTypes(f) {
+			if used[t] {
+				return true
+			}
+		}
+		for _, i := range f.PublicDependency {
+			if int(i) < len(f.Dependency) && provides(f.Dependency[i], seen) {
+				return true
+			}
+		}
+		return false
+	}
+	needed := make(map[string]bool, len(fd.Dependency))
+	for _, dep := range fd.Dependency {
+		needed[dep] = provides(dep, make(map[string]bool))
+	}
+	return needed
+}
+
+// declaredTypes returns the full names, with a leading dot, of the messages
+// and enums f declares, at any depth.
+func declaredTypes(f *descriptorpb.FileDescriptorProto) []string {
+	var names []string
+	var walk func(scope string, msgs []*descriptorpb.DescriptorProto, enums []*descriptorpb.EnumDescriptorProto)
+	walk = func(scope string, msgs []*descriptorpb.DescriptorProto, enums []*descriptorpb.EnumDescriptorProto) {
+		for _, e := range enums {
+			names = append(names, scope+"."+e.GetName())
+		}
+		for _, m := range msgs {
+			full := scope + "." + m.GetName()
+			names = append(names, full)
+			walk(full, m.NestedType, m.EnumType)
+		}
+	}
+	scope := ""
+	if f.GetPackage() != "" {
+		scope = "." + f.GetPackage()
+	}
+	walk(scope, f.MessageType, f.EnumType)
+	return names
+}
+
+// declItem is one declaration to print, at path.
+type declItem struct {
+	path  []int32
+	print func() error
+}
+
+// sorted orders items by where they started in the source, so messages,
+// enums and fields keep their original order even though the descriptor
+// groups them by kind. Without source info the descriptor order is kept.
+func (p *descriptorPrinter) sorted(items []declItem) []declItem {
+	line := func(it declItem) int32 {
+		if loc := p.locations[pathKey(it.path)]; loc != nil && len(loc.Span) > 0 {
+			return loc.Span[0]
+		}
+		return -1
+	}
+	sort.SliceStable(items, func(i, j int) bool { return line(items[i]) < line(items[j]) })
+	return items
+}
+
+func (p *descriptorPrinter) message(indent string, at []int32, scope string, m *descriptorpb.DescriptorProto) error {
+	full := scope + "." + m.GetName()
+	p.open(indent, at, "message "+m.GetName())
+	inner := indent + "  "
+	if m.GetOptions().GetDeprecated() {
+		p.b.WriteString(inner + "option deprecated = true;\n")
+	}
+	var items []declItem
+	oneofs := make([][]int, len(m.OneofDecl))
+	for i, f := range m.Field {
+		if fieldOptionSet(f, p.filter) {
+			continue
+		}
+		if f.OneofIndex != nil && !f.GetProto3Optional() {
+			oneofs[f.GetOneofIndex()] = append(oneofs[f.GetOneofIndex()], i)
+			continue
+		}
+		f, fat := f, descPath(at, messageFieldPath, i)
+		items = append(items, declItem{fat, func() error { return p.field(inner, fat, f) }})
+	}
+	for i, o := range m.OneofDecl {
+		// A oneof left without fields would not compile.
+		if len(oneofs[i]) == 0 {
+			continue
+		}
+		o, oat, fields := o, descPath(at, messageOneofPath, i), oneofs[i]
+		items = append(items, declItem{oat, func() error {
+			p.open(inner, oat, "oneof "+o.GetName())
+			for _, fi := range fields {
+				if err := p.field(inner+"  ", descPath(at, messageFieldPath, fi), m.Field[fi]); err != nil {
+					return err
+				}
+			}
+			p.b.WriteString(inner + "}\n")
+			return nil
+		}})
+	}
+	for i, nested := range m.NestedType {
+		if nested.GetOptions().GetMapEntry() {
+			continue
+		}
+		nested, nat := nested, descPath(at, messageNestedPath, i)
+		items = append(items, declItem{nat, func() error { return p.message(inner, nat, full, nested) }})
+	}
+	for i, e := range m.EnumType {
+		e, eat := e, descPath(at, messageEnumPath, i)
+		items = append(items, declItem{eat, func() error { return p.enum(inner, eat, e) }})
+	}
+	if len(m.ExtensionRange) > 0 {
+		var ranges []string
+		for _, r := range m.ExtensionRange {
+			ranges = append(ranges, numberRange(r.GetStart(), r.GetEnd()-1, maxFieldNumber))
+		}
+		rat := descPath(at, messageExtRangePath)
+		items = append(items, declItem{rat, func() error {
+			p.statement(inner, rat, "extensions "+strings.Join(ranges, ", ")+";")
+			return nil
+		}})
+	}
+	if len(m.ReservedRange) > 0 {
+		var ranges []string
+		for _, r := range m.ReservedRange {
+			ranges = append(ranges, numberRange(r.GetStart(), r.GetEnd()-1, maxFieldNumber))
+		}
+		rat := descPath(at, messageReservedPath)
+		items = append(items, declItem{rat, func() error {
+			p.statement(inner, rat, "reserved "+strings.Join(ranges, ", ")+";")
+			return nil
+		}})
+	}
+	if len(m.ReservedName) > 0 {
+		nat := descPath(at, messageResNamePath)
+		items = append(items, declItem{nat, func() error {
+			p.statement(inner, nat, "reserved "+quoteAll(m.ReservedName)+";")
+			return nil
+		}})
+	}
+	for _, it := range p.sorted(items) {
+		if err := it.print(); err != nil {
+			return err
+		}
+	}
+	p.b.WriteString(indent + "}\n")
+	return nil
+}
+
+func (p *descriptorPrinter) field(indent string, at []int32, f *descriptorpb.FieldDescriptorProto) error {
+	var b strings.Builder
+	switch {
+	case f.GetProto3Optional():
+		b.WriteString("optional ")
+	case f.OneofIndex != nil:
+	case f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+		if _, ok := p.mapEntries[f.GetTypeName()]; !ok {
+			b.WriteString("repeated ")
+		}
+	case f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+		b.WriteString("required ")
+	case !p.proto3:
+		b.WriteString("optional ")
+	}
+	typ, err := p.fieldType(f)
+	if err != nil {
+		return err
+	}
+	b.WriteString(typ + " " + f.GetName() + " = " + strconv.Itoa(int(f.GetNumber())))
+	var opts []string
+	if f.DefaultValue != nil {
+		opts = append(opts, "default = "+defaultValue(f))
+	}
+	if f.Options != nil && f.Options.Packed != nil {
+		opts = append(opts, "packed = "+strconv.FormatBool(f.Options.GetPacked()))
+	}
+	if f.GetOptions().GetDeprecated() {
+		opts = append(opts, "deprecated = true")
+	}
+	// protoc fills in json_name for every field; only a custom one was written.
+	if f.JsonName != nil && f.GetJsonName() != jsonName(f.GetName()) {
+		opts = append(opts, "json_name = "+strconv.Quote(f.GetJsonName()))
+	}
+	if len(opts) > 0 {
+		b.WriteString(" [" + strings.Join(opts, ", ") + "]")
+	}
+	p.statement(indent, at, b.String()+";")
+	return nil
+}
+
+func (p *descriptorPrinter) fieldType(f *descriptorpb.FieldDescriptorProto) (string, error) {
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return "", fmt.Errorf("field %s: groups are not supported", f.GetName())
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		if entry, ok := p.mapEntries[f.GetTypeName()]; ok && len(entry.Field) == 2 {
+			key, err := p.fieldType(entry.Field[0])
+			if err != nil {
+				return "", err
+			}
+			value, err := p.fieldType(entry.Field[1])
+			if err != nil {
+				return "", err
+			}
+			return "map<" + key + ", " + value + ">", nil
+		}
+		p.used[f.GetTypeName()] = true
+		return f.GetTypeName(), nil
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		p.used[f.GetTypeName()] = true
+		return f.GetTypeName(), nil
+	}
+	return strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_")), nil
+}
+
+func (p *descriptorPrinter) enum(indent string, at []int32, e *descriptorpb.EnumDescriptorProto) error {
+	p.open(indent, at, "enum "+e.GetName())
+	inner := indent + "  "
+	if e.GetOptions().GetAllowAlias() {
+		p.b.WriteString(inner + "option allow_alias = true;\n")
+	}
+	if e.GetOptions().GetDeprecated() {
+		p.b.WriteString(inner + "option deprecated = true;\n")
+	}
+	var items []declItem
+	for i, v := range e.Value {
+		v, vat := v, descPath(at, enumValuePath, i)
+		items = append(items, declItem{vat, func() error {
+			stmt := v.GetName() + " = " + strconv.Itoa(int(v.GetNumber()))
+			if v.GetOptions().GetDeprecated() {
+				stmt += " [deprecated = true]"
+			}
+			p.statement(inner, vat, stmt+";")
+			return nil
+		}})
+	}
+	if len(e.ReservedRange) > 0 {
+		var ranges []string
+		for _, r := range e.ReservedRange {
+			// Enum reserved ranges are inclusive, unlike message ones.
+			ranges = append(ranges, numberRange(r.GetStart(), r.GetEnd(), math.MaxInt32))
+		}
+		rat := descPath(at, enumReservedPath)
+		items = append(items, declItem{rat, func() error {
+			p.statement(inner, rat, "reserved "+strings.Join(ranges, ", ")+";")
+			return nil
+		}})
+	}
+	if len(e.ReservedName) > 0 {
+		nat := descPath(at, enumResNamePath)
+		items = append(items, declItem{nat, func() error {
+			p.statement(inner, nat, "reserved "+quoteAll(e.ReservedName)+";")
+			return nil
+		}})
+	}
+	for _, it := range p.sorted(items) {
+		if err := it.print(); err != nil {
+			return err
+		}
+	}
+	p.b.WriteString(indent + "}\n")
+	return nil
+}
+
+// open writes the leading comments and opening line of a block at path.
+func (p *descriptorPrinter) open(indent string, at []int32, decl string) {
+	p.statement(indent, at, decl+" {")
+}
+
+// statement writes stmt at path with the comments the source had around it.
+func (p *descriptorPrinter) statement(indent string, at []int32, stmt string) {
+	loc := p.locations[pathKey(at)]
+	for _, c := range loc.GetLeadingDetachedComments() {
+		p.comment(indent, c)
+		p.b.WriteString("\n")
+	}
+	p.comment(indent, loc.GetLeadingComments())
+	trailing := strings.TrimSuffix(loc.GetTrailingComments(), "\n")
+	if trailing != "" && !strings.Contains(trailing, "\n") {
+		p.b.WriteString(indent + stmt + " //" + trailing + "\n")
+		return
+	}
+	p.b.WriteString(indent + stmt + "\n")
+	p.comment(indent, trailing)
+}
+
+// comment writes text, a comment as SourceCodeInfo stores it, as // lines.
+func (p *descriptorPrinter) comment(indent, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		p.b.WriteString(indent + "//" + line + "\n")
+	}
+}
+
+// descPath returns a copy of base extended with elems, so paths built from a
+// shared prefix never alias.
+func descPath(base []int32, elems ...int) []int32 {
+	out := append([]int32(nil), base...)
+	for _, e := range elems {
+		out = append(out, int32(e))
+	}
+	return out
+}
+
+func pathKey(path []int32) string {
+	return fmt.Sprint(path)
+}
+
+func containsIndex(list []int32, i int) bool {
+	for _, v := range list {
+		if int(v) == i {
+			return true
+		}
+	}
+	return false
+}
+
+// numberRange renders the inclusive range lo to hi of a reserved or
+// extensions statement, where max is the largest number allowed.
+func numberRange(lo, hi, max int32) string {
+	switch {
+	case hi >= max && lo != hi:
+		return strconv.Itoa(int(lo)) + " to max"
+	case lo == hi:
+		return strconv.Itoa(int(lo))
+	}
+	return strconv.Itoa(int(lo)) + " to " + strconv.Itoa(int(hi))
+}
+
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = strconv.Quote(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// defaultValue renders the proto2 default of f. Bytes defaults are stored
+// C-escaped already; string defaults are stored as is.
+func defaultValue(f *descriptorpb.FieldDescriptorProto) string {
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return strconv.Quote(f.GetDefaultValue())
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return `"` + f.GetDefaultValue() + `"`
+	}
+	return f.GetDefaultValue()
+}
+
+// jsonName is the JSON name protoc derives for a field: underscores are
+// dropped and the letter after each one is upper-cased.
+func jsonName(name string) string {
+	var b strings.Builder
+	upper := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			b.WriteByte(c - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteByte(c)
+			upper = false
+		}
+	}
+	return b.String()
+}
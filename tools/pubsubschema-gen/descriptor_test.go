@@ -0,0 +1,464 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/yaml.v3"
+)
+
+const internalOptionNumber = 50001
+
+// optionsFile declares the bool field option acme.internal.
+var optionsFile = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("acme/options.proto"),
+	Package:    proto.String("acme"),
+	Dependency: []string{"google/protobuf/descriptor.proto"},
+	Syntax:     proto.String("proto3"),
+	Extension: []*descriptorpb.FieldDescriptorProto{{
+		Name:     proto.String("internal"),
+		Number:   proto.Int32(internalOptionNumber),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(),
+		Extendee: proto.String(fieldOptionsExtendee),
+		JsonName: proto.String("internal"),
+	}},
+}
+
+// internalOption returns field options setting acme.internal to v, stored
+// the way an unregistered extension is: as unknown fields.
+func internalOption(v bool) *descriptorpb.FieldOptions {
+	opts := &descriptorpb.FieldOptions{}
+	b := protowire.AppendTag(nil, internalOptionNumber, protowire.VarintType)
+	opts.ProtoReflect().SetUnknown(protowire.AppendVarint(b, protowire.EncodeBool(v)))
+	return opts
+}
+
+func descField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+		JsonName: proto.String(jsonName(name)),
+	}
+}
+
+// testEventDescriptor is what protoc --include_source_info writes for
+//
+//	// +consumers: billing
+//	syntax = "proto3";
+//
+//	package coreapp.test.v1;
+//
+//	import "acme/options.proto";
+//
+//	message TestEvent {
+//	  // The ID.
+//	  string id = 1;
+//	  string secret = 2 [(acme.internal) = true]; // drop me
+//	  string token = 3 [(acme.internal) = false];
+//	  repeated string tags = 4;
+//	  map<string, int64> counts = 5;
+//	  oneof payload {
+//	    string text = 6;
+//	    bytes blob = 7 [(acme.internal) = true];
+//	  }
+//	  optional string note = 8;
+//	  Kind kind = 9;
+//	  enum Kind {
+//	    KIND_UNSPECIFIED = 0;
+//	  }
+//	  reserved 10, 20 to 29;
+//	}
+func testEventDescriptor() *descriptorpb.FileDescriptorProto {
+	secret := descField("secret", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	secret.Options = internalOption(true)
+	token := descField("token", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	token.Options = internalOption(false)
+	tags := descField("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	tags.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	counts := descField("counts", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	counts.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	counts.TypeName = proto.String(".coreapp.test.v1.TestEvent.CountsEntry")
+	text := descField("text", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	text.OneofIndex = proto.Int32(0)
+	blob := descField("blob", 7, descriptorpb.FieldDescriptorProto_TYPE_BYTES)
+	blob.OneofIndex, blob.Options = proto.Int32(0), internalOption(true)
+	note := descField("note", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	note.OneofIndex, note.Proto3Optional = proto.Int32(1), proto.Bool(true)
+	kind := descField("kind", 9, descriptorpb.FieldDescriptorProto_TYPE_ENUM)
+	kind.TypeName = proto.String(".coreapp.test.v1.TestEvent.Kind")
+	loc := func(line int32, leading, trailing string, path ...int32) *descriptorpb.SourceCodeInfo_Location {
+		l := &descriptorpb.SourceCodeInfo_Location{Path: path, Span: []int32{line, 0, 1}}
+		if leading != "" {
+			l.LeadingComments = proto.String(leading)
+		}
+		if trailing != "" {
+			l.TrailingComments = proto.String(trailing)
+		}
+		return l
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("coreapp.test.v1.TestEvent.pubsub.proto"),
+		Package:    proto.String("coreapp.test.v1"),
+		Dependency: []string{"acme/options.proto"},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:  proto.String("TestEvent"),
+			Field: []*descriptorpb.FieldDescriptorProto{descField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING), secret, token, tags, counts, text, blob, note, kind},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("CountsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					descField("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					descField("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			EnumType: []*descriptorpb.EnumDescriptorProto{{
+				Name:  proto.String("Kind"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("KIND_UNSPECIFIED"), Number: proto.Int32(0)}},
+			}},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("payload")}, {Name: proto.String("_note")}},
+			ReservedRange: []*descriptorpb.DescriptorProto_ReservedRange{
+				{Start: proto.Int32(10), End: proto.Int32(11)},
+				{Start: proto.Int32(20), End: proto.Int32(30)},
+			},
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+			loc(1, " +consumers: billing\n", "", fileSyntaxPath),
+			loc(3, "", "", filePackagePath),
+			loc(5, "", "", fileDependencyPath, 0),
+			loc(7, "", "", fileMessagePath, 0),
+			loc(9, " The ID.\n", "", fileMessagePath, 0, messageFieldPath, 0),
+			loc(10, "", " drop me\n", fileMessagePath, 0, messageFieldPath, 1),
+			loc(11, "", "", fileMessagePath, 0, messageFieldPath, 2),
+			loc(12, "", "", fileMessagePath, 0, messageFieldPath, 3),
+			loc(13, "", "", fileMessagePath, 0, messageFieldPath, 4),
+			loc(14, "", "", fileMessagePath, 0, messageOneofPath, 0),
+			loc(15, "", "", fileMessagePath, 0, messageFieldPath, 5),
+			loc(16, "", "", fileMessagePath, 0, messageFieldPath, 6),
+			loc(18, "", "", fileMessagePath, 0, messageFieldPath, 7),
+			loc(19, "", "", fileMessagePath, 0, messageFieldPath, 8),
+			loc(20, "", "", fileMessagePath, 0, messageEnumPath, 0),
+			loc(23, "", "", fileMessagePath, 0, messageReservedPath),
+		}},
+	}
+}
+
+// descriptorSet marshals files as a FileDescriptorSet.
+func descriptorSet(t *testing.T, files ...*descriptorpb.FileDescriptorProto) string {
+	t.Helper()
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDescriptorSetSource(t *testing.T) {
+	set := descriptorSet(t, optionsFile, testEventDescriptor())
+	// acme/options.proto only declares the option, which is never printed.
+	const unfiltered = `// +consumers: billing
+syntax = "proto3";
+
+package coreapp.test.v1;
+
+message TestEvent {
+  // The ID.
+  string id = 1;
+  string secret = 2; // drop me
+  string token = 3;
+  repeated string tags = 4;
+  map<string, int64> counts = 5;
+  oneof payload {
+    string text = 6;
+    bytes blob = 7;
+  }
+  optional string note = 8;
+  .coreapp.test.v1.TestEvent.Kind kind = 9;
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+  reserved 10, 20 to 29;
+}
+`
+	filtered := strings.NewReplacer("  string secret = 2; // drop me\n", "", "    bytes blob = 7;\n", "").Replace(unfiltered)
+	tests := []struct {
+		name    string
+		option  string
+		want    string
+		wantErr string
+	}{
+		{name: "no filter", want: unfiltered},
+		{name: "unqualified", option: "internal", want: filtered},
+		{name: "qualified", option: "acme.internal", want: filtered},
+		{name: "parenthesized", option: "(acme.internal)", want: filtered},
+		{name: "leading dot", option: "(.acme.internal)", want: filtered},
+		{name: "undeclared", option: "hidden", wantErr: "--field-filter-option: no extension of google.protobuf.FieldOptions named hidden in the descriptor set (build it with --include_imports)"},
+		{name: "partial name", option: "ternal", wantErr: "no extension of google.protobuf.FieldOptions named ternal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := descriptorSetSource([]byte(set), tt.option)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("source =\n%s\nwant\n%s", got, tt.want)
+			}
+			if _, err := parseProto(got); err != nil {
+				t.Errorf("re-emitted source does not parse: %v", err)
+			}
+		})
+	}
+}
+
+func TestDescriptorSetImports(t *testing.T) {
+	typesFile := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("acme/types.proto"),
+		Package:     proto.String("acme"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Money")}},
+		EnumType:    []*descriptorpb.EnumDescriptorProto{{Name: proto.String("Currency"), Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("CURRENCY_UNSPECIFIED"), Number: proto.Int32(0)}}}},
+	}
+	reexport := &descriptorpb.FileDescriptorProto{
+		Name:             proto.String("acme/all.proto"),
+		Dependency:       []string{"acme/types.proto"},
+		PublicDependency: []int32{0},
+		Syntax:           proto.String("proto3"),
+	}
+	event := func(imports []string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.FileDescriptorProto {
+		return &descriptorpb.FileDescriptorProto{
+			Name:        proto.String("a.v1.Event.pubsub.proto"),
+			Package:     proto.String("a.v1"),
+			Dependency:  imports,
+			Syntax:      proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Event"), Field: fields}},
+		}
+	}
+	typed := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := descField(name, number, typ)
+		f.TypeName = proto.String(typeName)
+		return f
+	}
+	money := typed("price", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".acme.Money")
+	currency := typed("currency", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".acme.Currency")
+	hidden := proto.Clone(money).(*descriptorpb.FieldDescriptorProto)
+	hidden.Options = internalOption(true)
+	tests := []struct {
+		name   string
+		files  []*descriptorpb.FileDescriptorProto
+		option string
+		want   []string
+	}{
+		{name: "message used", files: []*descriptorpb.FileDescriptorProto{typesFile, event([]string{"acme/types.proto"}, money)}, want: []string{"acme/types.proto"}},
+		{name: "enum used", files: []*descriptorpb.FileDescriptorProto{typesFile, event([]string{"acme/types.proto"}, currency)}, want: []string{"acme/types.proto"}},
+		{name: "unused", files: []*descriptorpb.FileDescriptorProto{typesFile, event([]string{"acme/types.proto"})}},
+		{name: "through a public import", files: []*descriptorpb.FileDescriptorProto{typesFile, reexport, event([]string{"acme/all.proto"}, money)}, want: []string{"acme/all.proto"}},
+		{name: "not in the set", files: []*descriptorpb.FileDescriptorProto{event([]string{"acme/types.proto"})}, want: []string{"acme/types.proto"}},
+		{
+			name:   "only used by a filtered field",
+			files:  []*descriptorpb.FileDescriptorProto{optionsFile, typesFile, event([]string{"acme/options.proto", "acme/types.proto"}, hidden, currency)},
+			option: "internal",
+			want:   []string{"acme/types.proto"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := descriptorSetSource([]byte(descriptorSet(t, tt.files...)), tt.option)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pf, err := parseProto(got)
+			if err != nil {
+				t.Fatalf("re-emitted source does not parse: %v\n%s", err, got)
+			}
+			if !reflect.DeepEqual(pf.imports, tt.want) {
+				t.Errorf("imports = %v, want %v:\n%s", pf.imports, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDescriptorSetSourceErrors(t *testing.T) {
+	other := proto.Clone(optionsFile).(*descriptorpb.FileDescriptorProto)
+	other.Package = proto.String("other")
+	notBool := proto.Clone(optionsFile).(*descriptorpb.FileDescriptorProto)
+	notBool.Extension[0].Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	editions := testEventDescriptor()
+	editions.Syntax = proto.String("editions")
+	group := testEventDescriptor()
+	group.MessageType[0].Field[1].Type = descriptorpb.FieldDescriptorProto_TYPE_GROUP.Enum()
+	tests := []struct {
+		name    string
+		set     string
+		option  string
+		wantErr string
+	}{
+		{name: "not a descriptor set", set: "syntax = \"proto3\";\n", wantErr: "parsing descriptor set"},
+		{name: "empty", set: "", wantErr: "descriptor set has no files"},
+		{name: "ambiguous", set: descriptorSet(t, optionsFile, other, testEventDescriptor()), option: "internal", wantErr: "--field-filter-option: internal is ambiguous: acme.internal, other.internal"},
+		{name: "not bool", set: descriptorSet(t, notBool, testEventDescriptor()), option: "internal", wantErr: "--field-filter-option: acme.internal is not a bool option"},
+		{name: "editions", set: descriptorSet(t, optionsFile, editions), wantErr: `coreapp.test.v1.TestEvent.pubsub.proto: syntax "editions" is not supported in descriptor sets`},
+		{name: "group", set: descriptorSet(t, optionsFile, group), wantErr: "field secret: groups are not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := descriptorSetSource([]byte(tt.set), tt.option)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFieldOptionSet(t *testing.T) {
+	twice := internalOption(true)
+	b := protowire.AppendTag(twice.ProtoReflect().GetUnknown(), internalOptionNumber, protowire.VarintType)
+	twice.ProtoReflect().SetUnknown(protowire.AppendVarint(b, 0))
+	other := &descriptorpb.FieldOptions{Deprecated: proto.Bool(true)}
+	b = protowire.AppendTag(nil, internalOptionNumber+1, protowire.BytesType)
+	other.ProtoReflect().SetUnknown(protowire.AppendBytes(b, []byte{1}))
+	tests := []struct {
+		name string
+		opts *descriptorpb.FieldOptions
+		want bool
+	}{
+		{"true", internalOption(true), true},
+		{"false", internalOption(false), false},
+		{"no options", nil, false},
+		{"last value wins", twice, false},
+		{"other options only", other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := descField("f", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+			f.Options = tt.opts
+			if got := fieldOptionSet(f, internalOptionNumber); got != tt.want {
+				t.Errorf("fieldOptionSet = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintDescriptorProto2(t *testing.T) {
+	f := descField("retries", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32)
+	f.DefaultValue = proto.String("3")
+	name := descField("display_name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	name.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	name.DefaultValue = proto.String(`say "hi"`)
+	name.JsonName = proto.String("label")
+	ids := descField("ids", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64)
+	ids.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	ids.Options = &descriptorpb.FieldOptions{Packed: proto.Bool(true), Deprecated: proto.Bool(true)}
+	fd := &descriptorpb.FileDescriptorProto{
+		Name: proto.String("legacy.pubsub.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:           proto.String("Legacy"),
+			Field:          []*descriptorpb.FieldDescriptorProto{f, name, ids},
+			ExtensionRange: []*descriptorpb.DescriptorProto_ExtensionRange{{Start: proto.Int32(100), End: proto.Int32(maxFieldNumber + 1)}},
+			ReservedName:   []string{"old"},
+		}},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name:    proto.String("State"),
+			Options: &descriptorpb.EnumOptions{AllowAlias: proto.Bool(true)},
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("ON"), Number: proto.Int32(1)},
+				{Name: proto.String("ENABLED"), Number: proto.Int32(1), Options: &descriptorpb.EnumValueOptions{Deprecated: proto.Bool(true)}},
+			},
+			ReservedRange: []*descriptorpb.EnumDescriptorProto_EnumReservedRange{{Start: proto.Int32(5), End: proto.Int32(7)}, {Start: proto.Int32(9), End: proto.Int32(9)}},
+		}},
+	}
+	want := `syntax = "proto2";
+
+message Legacy {
+  optional int32 retries = 1 [default = 3];
+  required string display_name = 2 [default = "say \"hi\"", json_name = "label"];
+  repeated int64 ids = 3 [packed = true, deprecated = true];
+  extensions 100 to max;
+  reserved "old";
+}
+
+enum State {
+  option allow_alias = true;
+  ON = 1;
+  ENABLED = 1 [deprecated = true];
+  reserved 5 to 7, 9;
+}
+`
+	got, err := printDescriptor(fd, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("source =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJSONName(t *testing.T) {
+	for name, want := range map[string]string{
+		"id":           "id",
+		"display_name": "displayName",
+		"a_b_c":        "aBC",
+		"trailing_":    "trailing",
+		"with_1digit":  "with1digit",
+		"already_Up":   "alreadyUp",
+	} {
+		if got := jsonName(name); got != want {
+			t.Errorf("jsonName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDescriptorSetInput(t *testing.T) {
+	inputs := map[string]string{
+		"coreapp.test.v1.TestEvent.pubsub.binpb": descriptorSet(t, optionsFile, testEventDescriptor()),
+	}
+	out := generate(t, inputs, "--proto-format", "descriptor-set", "--field-filter-option", "internal", "--bundle")
+	var doc struct {
+		Spec struct {
+			Definition string `yaml:"definition"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, gone := range []string{"secret", "blob", "drop me"} {
+		if strings.Contains(doc.Spec.Definition, gone) {
+			t.Errorf("definition still has %q:\n%s", gone, doc.Spec.Definition)
+		}
+	}
+	for _, kept := range []string{"  // The ID.\n  string id = 1;\n", "  string token = 3;\n", "    string text = 6;\n"} {
+		if !strings.Contains(doc.Spec.Definition, kept) {
+			t.Errorf("definition lacks %q:\n%s", kept, doc.Spec.Definition)
+		}
+	}
+	// The +consumers directive survives through the source info.
+	want := []string{testEventSchema + "-billing" + subscriptionSuffix, testEventSchema + ".schema.yaml", testEventSchema + topicSuffix}
+	if got := kustomizationResources(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+}
+
+func TestDescriptorSetDefaultGlob(t *testing.T) {
+	inputs := map[string]string{
+		"coreapp.test.v1.TestEvent.pubsub.binpb": descriptorSet(t, optionsFile, testEventDescriptor()),
+		"ignored.pubsub.proto":                   "not a descriptor set",
+	}
+	out := generate(t, inputs, "--proto-format", "descriptor-set")
+	if got, want := kustomizationResources(t, out), []string{testEventSchema + ".schema.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+}
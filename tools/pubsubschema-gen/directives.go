@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// parseDirectives collects `// +key: value` line comments from src. A key may
+// appear more than once; its values are kept in source order. Only line
+// comments that start with "+" are directives, so ordinary prose is ignored.
+func parseDirectives(src string) (map[string][]string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	directives := make(map[string][]string)
+	for _, t := range toks {
+		if t.kind != tokComment || !strings.HasPrefix(t.text, "//") {
+			continue
+		}
+		body := strings.TrimSpace(strings.TrimPrefix(t.text, "//"))
+		if !strings.HasPrefix(body, "+") {
+			continue
+		}
+		key, value, ok := strings.Cut(body[1:], ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		directives[key] = append(directives[key], strings.TrimSpace(value))
+	}
+	return directives, nil
+}
+
+// directiveList splits every value of the directive key on commas and returns
+// the sorted, de-duplicated, non-empty items.
+func (in *schemaInput) directiveList(key string) []string {
+	seen := make(map[string]bool)
+	items := []string{}
+	for _, v := range in.directives[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" && !seen[item] {
+				seen[item] = true
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+// writeConsumersReport writes the schema-to-consumers map as JSON. Schemas
+// without a +consumers directive map to an empty list.
+func writeConsumersReport(path string, p *plan) error {
+	data, err := json.MarshalIndent(p.consumers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data)+"\n")
+}
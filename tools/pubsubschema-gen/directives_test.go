@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDirectives(t *testing.T) {
+	src := `// +consumers: orders, billing
+// Plain prose: not a directive.
+// +encoding: BINARY
+/* +consumers: block comments are ignored */
+syntax = "proto3";
+message E {
+  string s = 1 [default = "// +consumers: in a string"];
+  // +consumers: billing,audit
+}
+`
+	got, err := parseDirectives(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"consumers": {"orders, billing", "billing,audit"},
+		"encoding":  {"BINARY"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDirectives = %v, want %v", got, want)
+	}
+	in := &schemaInput{directives: got}
+	if list, want := in.directiveList("consumers"), []string{"audit", "billing", "orders"}; !reflect.DeepEqual(list, want) {
+		t.Errorf("directiveList = %v, want %v", list, want)
+	}
+}
+
+func TestConsumersReport(t *testing.T) {
+	inputs := map[string]string{
+		"a.v1.Orders.pubsub.proto":  "// +consumers: orders, billing\nsyntax = \"proto3\";\nmessage Orders {}\n",
+		"a.v1.Billing.pubsub.proto": "// +consumers: ledger\n// +consumers: billing\nsyntax = \"proto3\";\nmessage Billing {}\n",
+		"a.v1.Quiet.pubsub.proto":   "syntax = \"proto3\";\nmessage Quiet {}\n",
+	}
+	report := filepath.Join(t.TempDir(), "consumers.json")
+	generate(t, inputs, "--consumers-report", report)
+	var got map[string][]string
+	if err := json.Unmarshal([]byte(readFile(t, report)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"a-v1-billing": {"billing", "ledger"},
+		"a-v1-orders":  {"billing", "orders"},
+		"a-v1-quiet":   {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("consumers report = %v, want %v", got, want)
+	}
+}
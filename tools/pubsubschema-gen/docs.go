@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// docSuffix marks generated doc stubs so pruning never touches hand-written
+// Markdown that lives in the same directory.
+const docSuffix = ".schema.md"
+
+// docStub renders the Markdown catalog stub for one schema: where it came
+// from, its top-level messages with their fields, and a description
+// placeholder for a human to fill in.
+func docStub(in *schemaInput) (string, error) {
+	pf, err := in.proto()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", in.name)
+	fmt.Fprintf(&b, "- Source: `%s`\n", in.rel)
+	if pf.pkg != "" {
+		fmt.Fprintf(&b, "- Package: `%s`\n", pf.pkg)
+	}
+	b.WriteString("\n## Description\n\n_TODO: describe this schema._\n")
+	for _, m := range pf.messages {
+		fmt.Fprintf(&b, "\n## Message `%s`\n\n", m.name)
+		if len(m.fields) == 0 {
+			b.WriteString("_No fields._\n")
+			continue
+		}
+		b.WriteString("| Field | Type | Number |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, f := range m.fields {
+			fmt.Fprintf(&b, "| `%s` | `%s` | %d |\n", f.name, f.typeString(), f.number)
+		}
+	}
+	return b.String(), nil
+}
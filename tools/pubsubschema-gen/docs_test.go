@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocStubListsParsedFields(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "fields",
+			src:  testEventProto,
+			want: []string{
+				"# " + testEventSchema + "\n",
+				"- Source: `" + testEventFile + "`\n",
+				"- Package: `coreapp.test.v1`\n",
+				"_TODO: describe this schema._",
+				"## Message `TestEvent`\n",
+				"| `id` | `string` | 1 |\n",
+				"| `name` | `string` | 2 |\n",
+			},
+		},
+		{
+			name: "labels and maps",
+			src:  "syntax = \"proto3\";\nmessage TestEvent {\n  repeated string tags = 1;\n  map<string, int64> counts = 2;\n}\n",
+			want: []string{"| `tags` | `repeated string` | 1 |\n", "| `counts` | `map<string, int64>` | 2 |\n"},
+		},
+		{
+			name: "no fields",
+			src:  "syntax = \"proto3\";\nmessage TestEvent {}\n",
+			want: []string{"## Message `TestEvent`\n\n_No fields._\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := docStub(testInput(t, testEventFile, tt.src, testOptions()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(doc, want) {
+					t.Errorf("doc stub lacks %q:\n%s", want, doc)
+				}
+			}
+		})
+	}
+}
+
+func TestEmitDocsPrunesStaleStubs(t *testing.T) {
+	in, out, docs := filepath.Join(t.TempDir(), "pubsub"), t.TempDir(), t.TempDir()
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	writeTree(t, docs, map[string]string{"gone.schema.md": "# gone\n", "README.md": "hand-written\n"})
+	if _, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--emit-docs", docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(docs, "gone.schema.md")); !os.IsNotExist(err) {
+		t.Errorf("stale doc stub was not pruned: %v", err)
+	}
+	if got := readFile(t, filepath.Join(docs, "README.md")); got != "hand-written\n" {
+		t.Errorf("README.md = %q, want it untouched", got)
+	}
+	if doc := readFile(t, filepath.Join(docs, testEventSchema+docSuffix)); !strings.Contains(doc, "| `id` | `string` | 1 |") {
+		t.Errorf("doc stub lacks the id field:\n%s", doc)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+var inputEncodings = []string{"utf-8", "utf-16", "utf-16le", "utf-16be", "latin1", "auto"}
+
+func validInputEncoding(enc string) bool {
+	for _, e := range inputEncodings {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeInput transcodes data from enc to UTF-8. "utf-8" passes bytes through
+// untouched. "utf-16" honours a BOM and otherwise assumes big-endian, as
+// RFC 2781 specifies. "auto" picks the encoding from a BOM and falls back to
+// UTF-8 when there is none.
+func decodeInput(data []byte, enc string) (string, error) {
+	switch enc {
+	case "utf-8":
+		return string(data), nil
+	case "latin1":
+		var b strings.Builder
+		b.Grow(len(data))
+		for _, c := range data {
+			b.WriteRune(rune(c))
+		}
+		return b.String(), nil
+	case "utf-16le":
+		return decodeUTF16(bytes.TrimPrefix(data, bomUTF16LE), binary.LittleEndian)
+	case "utf-16be":
+		return decodeUTF16(bytes.TrimPrefix(data, bomUTF16BE), binary.BigEndian)
+	case "utf-16":
+		switch {
+		case bytes.HasPrefix(data, bomUTF16LE):
+			return decodeUTF16(data[2:], binary.LittleEndian)
+		case bytes.HasPrefix(data, bomUTF16BE):
+			return decodeUTF16(data[2:], binary.BigEndian)
+		}
+		return decodeUTF16(data, binary.BigEndian)
+	case "auto":
+		switch {
+		case bytes.HasPrefix(data, bomUTF8):
+			return string(data[3:]), nil
+		case bytes.HasPrefix(data, bomUTF16LE):
+			return decodeUTF16(data[2:], binary.LittleEndian)
+		case bytes.HasPrefix(data, bomUTF16BE):
+			return decodeUTF16(data[2:], binary.BigEndian)
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("unsupported input encoding %q", enc)
+}
+
+func decodeUTF16(data []byte, order binary.ByteOrder) (string, error) {
+	if len(data)%2 != 0 {
+		return "", fmt.Errorf("invalid UTF-16 input: odd byte length %d", len(data))
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
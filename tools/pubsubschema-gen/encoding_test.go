@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"gopkg.in/yaml.v3"
+)
+
+// encodeUTF16 encodes s as UTF-16 in order, with a BOM when bom is set.
+func encodeUTF16(s string, order binary.ByteOrder, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	data := make([]byte, 2*len(units))
+	for i, u := range units {
+		order.PutUint16(data[2*i:], u)
+	}
+	return data
+}
+
+func TestDecodeInput(t *testing.T) {
+	const text = "// Café ✓\nmessage E {}\n"
+	tests := []struct {
+		name string
+		enc  string
+		data []byte
+	}{
+		{"utf-8", "utf-8", []byte(text)},
+		{"utf-16 le with bom", "utf-16", encodeUTF16(text, binary.LittleEndian, true)},
+		{"utf-16 be with bom", "utf-16", encodeUTF16(text, binary.BigEndian, true)},
+		{"utf-16 without bom is big-endian", "utf-16", encodeUTF16(text, binary.BigEndian, false)},
+		{"utf-16le", "utf-16le", encodeUTF16(text, binary.LittleEndian, false)},
+		{"utf-16le drops bom", "utf-16le", encodeUTF16(text, binary.LittleEndian, true)},
+		{"utf-16be", "utf-16be", encodeUTF16(text, binary.BigEndian, false)},
+		{"auto utf-16 le", "auto", encodeUTF16(text, binary.LittleEndian, true)},
+		{"auto utf-8 bom", "auto", append([]byte{0xEF, 0xBB, 0xBF}, text...)},
+		{"auto without bom", "auto", []byte(text)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeInput(tt.data, tt.enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != text {
+				t.Errorf("decodeInput = %q, want %q", got, text)
+			}
+		})
+	}
+}
+
+func TestDecodeInputLatin1(t *testing.T) {
+	got, err := decodeInput([]byte{'C', 'a', 'f', 0xE9}, "latin1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Café" {
+		t.Errorf("decodeInput = %q, want Café", got)
+	}
+}
+
+func TestDecodeInputOddUTF16(t *testing.T) {
+	if _, err := decodeInput([]byte{0xFF, 0xFE, 'a'}, "utf-16"); err == nil {
+		t.Error("odd-length UTF-16 decoded without error")
+	}
+}
+
+func TestUTF16FixtureTranscoded(t *testing.T) {
+	src := strings.Replace(testEventProto, "published by the tests", "publié par les tests", 1)
+	out := generate(t, map[string]string{testEventFile: string(encodeUTF16(src, binary.LittleEndian, true))}, "--input-encoding", "utf-16")
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupPath(t, doc, "spec", "definition"); got != src {
+		t.Errorf("spec.definition = %q, want %q", got, src)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import "strings"
+
+// filterFields removes the message fields whose options set option to true,
+// such as string secret = 3 [(internal) = true];. It works on the tokens of
+// proto source: the field's statement goes together with its leading comments
+// and a trailing comment on the same line. Parentheses around the option name
+// are optional on both sides. Descriptor-set inputs are filtered on the
+// descriptor instead; see descriptorSetSource.
+func filterFields(src, option string) (string, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+	want := strings.Trim(option, "()")
+	var cuts [][2]int
+	var blocks []string
+	start := -1
+	// brackets is the nesting of [ ] field options, whose aggregate values
+	// have braces that open no block.
+	brackets := 0
+	for i, t := range toks {
+		if t.kind == tokComment {
+			continue
+		}
+		switch t.text {
+		case "[":
+			brackets++
+		case "]":
+			brackets--
+		}
+		if brackets > 0 {
+			continue
+		}
+		if start < 0 {
+			if t.text == ";" {
+				continue
+			}
+			start = i
+		}
+		switch t.text {
+		case "{":
+			blocks = append(blocks, toks[start].text)
+			start = -1
+		case "}":
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			start = -1
+		case ";":
+			inMessage := len(blocks) > 0 && (blocks[len(blocks)-1] == "message" || blocks[len(blocks)-1] == "oneof")
+			if inMessage && fieldOptionTrue(toks[start:i], want) {
+				cuts = append(cuts, fieldRange(toks, start, i))
+			}
+			start = -1
+		}
+	}
+	return cutRanges(src, cuts), nil
+}
+
+// fieldOptionTrue reports whether the bracketed options of a field statement
+// set want to true.
+func fieldOptionTrue(stmt []token, want string) bool {
+	open := -1
+	for i, t := range stmt {
+		if t.text == "[" {
+			open = i
+			break
+		}
+	}
+	if open < 0 {
+		return false
+	}
+	var name strings.Builder
+	inValue := false
+	depth := 0
+	for _, t := range stmt[open+1:] {
+		if t.kind == tokComment {
+			continue
+		}
+		switch {
+		case t.text == "]" && depth == 0:
+			return false
+		case t.text == "{":
+			depth++
+		case t.text == "}":
+			depth--
+		case depth > 0:
+		case t.text == ",":
+			name.Reset()
+			inValue = false
+		case t.text == "=":
+			inValue = true
+		case inValue:
+			if t.text == "true" && strings.Trim(name.String(), "()") == want {
+				return true
+			}
+		default:
+			name.WriteString(t.text)
+		}
+	}
+	return false
+}
+
+// fieldRange is the byte range of the statement toks[first..last], widened to
+// the comments on the lines directly above it and a comment after it on the
+// same line.
+func fieldRange(toks []token, first, last int) [2]int {
+	start, end := toks[leadingComments(toks, first)].start, toks[last].end
+	if next := last + 1; next < len(toks) && toks[next].kind == tokComment && toks[next].line == toks[last].line {
+		end = toks[next].end
+	}
+	return [2]int{start, end}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		option string
+		body   string
+		want   string
+	}{
+		{
+			name:   "flagged",
+			option: "internal",
+			body:   "  string id = 1;\n  string secret = 2 [(internal) = true];\n",
+			want:   "  string id = 1;\n",
+		},
+		{
+			name:   "false is kept",
+			option: "internal",
+			body:   "  string secret = 2 [(internal) = false];\n",
+			want:   "  string secret = 2 [(internal) = false];\n",
+		},
+		{
+			name:   "parentheses optional",
+			option: "(internal)",
+			body:   "  string a = 1 [internal = true];\n  string b = 2 [(internal) = true];\n",
+			want:   "",
+		},
+		{
+			name:   "among other options",
+			option: "internal",
+			body:   "  string secret = 2 [deprecated = true, (internal) = true];\n",
+			want:   "",
+		},
+		{
+			name:   "other option",
+			option: "internal",
+			body:   "  string secret = 2 [(internal_only) = true];\n",
+			want:   "  string secret = 2 [(internal_only) = true];\n",
+		},
+		{
+			name:   "with comments",
+			option: "internal",
+			body:   "  string id = 1; // kept\n  // Secret.\n  // Really.\n  string secret = 2 [(internal) = true]; // gone\n  string name = 3;\n",
+			want:   "  string id = 1; // kept\n  string name = 3;\n",
+		},
+		{
+			name:   "detached comment stays",
+			option: "internal",
+			body:   "  // Section.\n\n  string secret = 2 [(internal) = true];\n",
+			want:   "  // Section.\n\n",
+		},
+		{
+			name:   "aggregate option before",
+			option: "internal",
+			body:   "  string secret = 2 [(meta) = { owner: \"a\" }, (internal) = true];\n  string name = 3;\n",
+			want:   "  string name = 3;\n",
+		},
+		{
+			name:   "aggregate option after",
+			option: "internal",
+			body:   "  string secret = 2 [(internal) = true, (meta) = { tags: [\"x\"] }];\n  string name = 3;\n",
+			want:   "  string name = 3;\n",
+		},
+		{
+			name:   "aggregate option on a kept field",
+			option: "internal",
+			body:   "  string a = 1 [(meta) = { internal: true }];\n  string b = 2 [(internal) = true];\n",
+			want:   "  string a = 1 [(meta) = { internal: true }];\n",
+		},
+		{
+			name:   "oneof",
+			option: "internal",
+			body:   "  oneof v {\n    string a = 1;\n    bytes b = 2 [(internal) = true];\n  }\n",
+			want:   "  oneof v {\n    string a = 1;\n  }\n",
+		},
+		{
+			name:   "nested message",
+			option: "internal",
+			body:   "  message Inner {\n    string x = 1 [(internal) = true];\n  }\n",
+			want:   "  message Inner {\n  }\n",
+		},
+		{
+			name:   "enum values are not fields",
+			option: "internal",
+			body:   "  enum K {\n    A = 0 [(internal) = true];\n  }\n",
+			want:   "  enum K {\n    A = 0 [(internal) = true];\n  }\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const head = "syntax = \"proto3\";\nmessage E {\n"
+			got, err := filterFields(head+tt.body+"}\n", tt.option)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := head + tt.want + "}\n"; got != want {
+				t.Errorf("filterFields =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestFieldFilterOption(t *testing.T) {
+	src := "syntax = \"proto3\";\nmessage TestEvent {\n  string id = 1;\n  string secret = 2 [(meta) = { a: 1 }, (internal) = true];\n}\n"
+	out := generate(t, map[string]string{testEventFile: src}, "--field-filter-option", "internal")
+	if data := readFile(t, filepath.Join(out, testEventSchema+".schema.yaml")); strings.Contains(data, "secret") || !strings.Contains(data, "string id = 1;") {
+		t.Errorf("schema:\n%s", data)
+	}
+}
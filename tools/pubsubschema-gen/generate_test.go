@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestConcurrencyIndependentOutput runs one fixture at --concurrency 1, 4
+// and 16 and requires byte-identical output trees, kustomization included.
+func TestConcurrencyIndependentOutput(t *testing.T) {
+	inputs := manyInputs(30, 200)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"default", nil},
+		{"grouped with topics", []string{"--kustomization-group-by", "package", "--bundle"}},
+		{"read concurrency", []string{"--read-concurrency", "2"}},
+		{"raw", []string{"--output-format", "raw", "--raw-index"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "pubsub")
+			writeTree(t, in, inputs)
+			want := ""
+			for _, level := range []string{"1", "4", "16"} {
+				out, docs := t.TempDir(), t.TempDir()
+				args := append([]string{"--pubsub-dir", in, "--output-dir", out, "--emit-docs", docs, "--concurrency", level}, tt.args...)
+				if _, stderr, err := runTool(t, args...); err != nil {
+					t.Fatalf("--concurrency %s: %v\n%s", level, err, stderr)
+				}
+				if n := len(readTree(t, out)); n < len(inputs)+1 {
+					t.Fatalf("--concurrency %s wrote %d files, want at least %d", level, n, len(inputs)+1)
+				}
+				got := treeHash(t, out) + treeHash(t, docs)
+				if want == "" {
+					want = got
+				} else if got != want {
+					t.Errorf("--concurrency %s output differs from --concurrency 1", level)
+				}
+			}
+		})
+	}
+}
@@ -2,3 +2,11 @@ module github.com/coreapp/configmanagement-POC/tools/pubsubschema-gen
 
 go 1.20
 
+require (
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	golang.org/x/crypto v0.31.0
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.28.0 // indirect
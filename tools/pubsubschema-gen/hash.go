@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// hashAlgos lists the --hash-algo values. Every hash-derived output (name
+// suffixes, provenance records, state files) goes through hashHex so the
+// choice applies consistently.
+var hashAlgos = []string{"sha256", "sha512", "blake2b"}
+
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake2b":
+		// BLAKE2b-512, matching b2sum's default digest size.
+		return blake2b.New512(nil)
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %q", algo)
+}
+
+func validHashAlgo(algo string) bool {
+	_, err := newHash(algo)
+	return err == nil
+}
+
+// hashHex returns the lowercase hex digest of data under algo. algo must have
+// been validated with validHashAlgo.
+func hashHex(algo string, data []byte) string {
+	h, err := newHash(algo)
+	if err != nil {
+		panic(err)
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
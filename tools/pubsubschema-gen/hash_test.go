@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestHashHexUsesSelectedAlgorithm(t *testing.T) {
+	data := []byte("abc")
+	sha256Sum, sha512Sum, blake2bSum := sha256.Sum256(data), sha512.Sum512(data), blake2b.Sum512(data)
+	tests := []struct {
+		algo string
+		want string
+	}{
+		{"sha256", hex.EncodeToString(sha256Sum[:])},
+		{"sha512", hex.EncodeToString(sha512Sum[:])},
+		{"blake2b", hex.EncodeToString(blake2bSum[:])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algo, func(t *testing.T) {
+			if !validHashAlgo(tt.algo) {
+				t.Fatalf("%s is not a valid --hash-algo", tt.algo)
+			}
+			if got := hashHex(tt.algo, data); got != tt.want {
+				t.Errorf("hashHex = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if validHashAlgo("md5") {
+		t.Error("md5 is accepted as --hash-algo")
+	}
+}
+
+func TestHashAlgoChangesDerivedNames(t *testing.T) {
+	seen := make(map[string]string)
+	for _, algo := range hashAlgos {
+		out := generate(t, collidingInputs, "--name-collision", collisionSuffix, "--hash-algo", algo)
+		want := "a-v1-foo-" + hashHex(algo, []byte("a_v1_Foo.pubsub.proto"))[:8] + ".schema.yaml"
+		if _, ok := readTree(t, out)[want]; !ok {
+			t.Errorf("--hash-algo %s: %s not generated, got %v", algo, want, generatedFiles(t, out))
+		}
+		if other, ok := seen[want]; ok {
+			t.Errorf("--hash-algo %s and %s derive the same name %s", algo, other, want)
+		}
+		seen[want] = algo
+	}
+}
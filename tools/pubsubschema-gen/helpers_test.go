@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testEventFile is the name protoc-gen-pubsub gives the testEventProto input.
+const testEventFile = "coreapp.test.v1.TestEvent.pubsub.proto"
+
+// testEventSchema is the schema name derived from testEventFile.
+const testEventSchema = "coreapp-test-v1-testevent"
+
+const testEventProto = `syntax = "proto3";
+
+package coreapp.test.v1;
+
+// TestEvent is published by the tests.
+message TestEvent {
+  // id identifies the event.
+  string id = 1;
+  // name is free-form.
+  string name = 2;
+}
+`
+
+// writeTree creates files under dir, keyed by slash-separated path.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the regular files under dir keyed by slash-separated
+// path. A missing dir reads as empty.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// treeHash digests the names and contents of every file under dir.
+func treeHash(t *testing.T, dir string) string {
+	t.Helper()
+	files := readTree(t, dir)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, name+"\x00"+files[name]+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readFile returns the contents of path, failing the test if it can't be
+// read.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// runTool runs the generator with args and returns what it printed to
+// stdout and stderr.
+func runTool(t *testing.T, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	outR, outW, perr := os.Pipe()
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	errR, errW, perr := os.Pipe()
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	origOut, origErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outW, errW
+	outc, errc := make(chan string), make(chan string)
+	go func() {
+		data, _ := io.ReadAll(outR)
+		outc <- string(data)
+	}()
+	go func() {
+		data, _ := io.ReadAll(errR)
+		errc <- string(data)
+	}()
+	defer func() {
+		os.Stdout, os.Stderr = origOut, origErr
+	}()
+	err = run(args)
+	outW.Close()
+	errW.Close()
+	return <-outc, <-errc, err
+}
+
+// generate writes inputs into a fresh --pubsub-dir, runs the generator into a
+// fresh --output-dir with args and returns the output directory. The run
+// must succeed.
+func generate(t *testing.T, inputs map[string]string, args ...string) string {
+	t.Helper()
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, inputs)
+	if _, stderr, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", out}, args...)...); err != nil {
+		t.Fatalf("run %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return out
+}
+
+// generateErr is generate for runs that must fail; it returns the error.
+func generateErr(t *testing.T, inputs map[string]string, args ...string) error {
+	t.Helper()
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, inputs)
+	_, _, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", out}, args...)...)
+	if err == nil {
+		t.Fatalf("run %s: succeeded, want an error", strings.Join(args, " "))
+	}
+	return err
+}
+
+// testOptions returns the options of a run without flags.
+func testOptions() options {
+	return options{
+		shape:              schemaShapes[defaultAPIVersion],
+		outputFormat:       formatConfigConnector,
+		definitionFormat:   definitionFormat{eol: "lf", mode: definitionInline},
+		nameCollision:      collisionError,
+		inputEncoding:      "utf-8",
+		protoFormat:        protoFormatRaw,
+		hashAlgo:           "sha256",
+		hashSuffixLength:   8,
+		comments:           commentsAll,
+		emptyKustomization: emptySkip,
+		ioConcurrency:      defaultIOConcurrency,
+		concurrency:        1,
+		topicEncoding:      "JSON",
+	}
+}
+
+// testInput returns a loaded input for src, as if read from name under
+// --pubsub-dir.
+func testInput(t *testing.T, name, src string, opts options) *schemaInput {
+	t.Helper()
+	in := &schemaInput{path: name, rel: name}
+	var err error
+	if in.name, err = deriveSchemaName(in, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.load([]byte(src), opts); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inlineImports returns the definition of in as segments: the input itself
+// with its non-well-known imports removed, followed by every transitively
+// imported file with its syntax, package, import and file option statements
+// removed. The inlined files are emitted sorted by import path, and the
+// well-known imports needed by them are hoisted below the input's syntax
+// statement in the same order, so the result does not depend on the order
+// imports are declared or resolved in. Inlined declarations join the input's
+// scope, so references to them must not be package-qualified.
+func inlineImports(in *schemaInput, opts options) ([]defSegment, error) {
+	r := importResolver{roots: opts.protoRoots}
+	stmts, err := topLevelStatements(in.definition)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", in.path, err)
+	}
+
+	haveWKT := make(map[string]bool)
+	var cuts [][2]int
+	var direct []string
+	syntaxEnd := -1
+	for _, st := range stmts {
+		switch {
+		case st.keyword == "syntax" || st.keyword == "edition":
+			syntaxEnd = st.end
+		case st.keyword == "import" && isWellKnownImport(st.arg):
+			haveWKT[st.arg] = true
+		case st.keyword == "import":
+			direct = append(direct, st.arg)
+			cuts = append(cuts, [2]int{st.start, st.end})
+		}
+	}
+
+	var segs []defSegment
+	var hoist []string
+	visited := make(map[string]bool)
+	var visit func(paths []string) error
+	visit = func(paths []string) error {
+		for _, imp := range paths {
+			if isWellKnownImport(imp) {
+				if !haveWKT[imp] {
+					haveWKT[imp] = true
+					hoist = append(hoist, imp)
+				}
+				continue
+			}
+			if visited[imp] {
+				continue
+			}
+			visited[imp] = true
+			file, err := r.resolve(imp)
+			if err != nil {
+				return fmt.Errorf("%s: %w", in.path, err)
+			}
+			raw, err := opts.readInput(file)
+			if err != nil {
+				return err
+			}
+			src, err := decodeInput(raw, opts.inputEncoding)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			src = normalizeNewlines(src)
+			istmts, err := topLevelStatements(src)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			var icuts [][2]int
+			var nested []string
+			for _, st := range istmts {
+				switch st.keyword {
+				case "import":
+					nested = append(nested, st.arg)
+					fallthrough
+				case "syntax", "edition", "package", "option":
+					icuts = append(icuts, [2]int{st.start, st.end})
+				}
+			}
+			// Removing the header statements leaves the blank lines that followed
+			// them; the segment separator already provides one.
+			segs = append(segs, defSegment{source: imp, text: strings.TrimLeft(cutRanges(src, icuts), "\n")})
+			if err := visit(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(direct); err != nil {
+		return nil, err
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].source < segs[j].source })
+	sort.Strings(hoist)
+
+	main := in.definition
+	if len(hoist) > 0 {
+		var lines strings.Builder
+		for _, imp := range hoist {
+			lines.WriteString("import \"" + imp + "\";\n")
+		}
+		at := 0
+		if syntaxEnd >= 0 {
+			if nl := strings.IndexByte(main[syntaxEnd:], '\n'); nl >= 0 {
+				at = syntaxEnd + nl + 1
+			} else {
+				main += "\n"
+				at = len(main)
+			}
+		}
+		// The hoisted lines go in front of the first cut, so shift the cuts.
+		for i := range cuts {
+			if cuts[i][0] >= at {
+				cuts[i][0] += lines.Len()
+				cuts[i][1] += lines.Len()
+			}
+		}
+		main = main[:at] + lines.String() + main[at:]
+	}
+	return append([]defSegment{{source: in.rel, text: cutRanges(main, cuts)}}, segs...), nil
+}
+
+// importResolver maps proto import paths to files by searching the
+// --proto-root directories in order, like protoc's -I list.
+type importResolver struct {
+	roots []string
+}
+
+// resolvedImport is an import statement together with the file it resolved to.
+type resolvedImport struct {
+	path string
+	file string
+}
+
+// isWellKnownImport reports whether path is one of the google/protobuf types
+// that protoc ships with, which need not be present under any root.
+func isWellKnownImport(path string) bool {
+	return strings.HasPrefix(path, "google/protobuf/")
+}
+
+func (r importResolver) resolve(importPath string) (string, error) {
+	var searched []string
+	for _, root := range r.roots {
+		candidate := filepath.Join(root, filepath.FromSlash(importPath))
+		if st, err := os.Stat(candidate); err == nil && st.Mode().IsRegular() {
+			return candidate, nil
+		}
+		searched = append(searched, candidate)
+	}
+	return "", fmt.Errorf("import %q not found; searched: %s", importPath, strings.Join(searched, ", "))
+}
+
+// resolveImports resolves every non-well-known import of in and stores the
+// result on it for features that need the imported sources.
+func resolveImports(in *schemaInput, r importResolver) error {
+	pf, err := in.proto()
+	if err != nil {
+		return err
+	}
+	in.imports = nil
+	for _, imp := range pf.imports {
+		if isWellKnownImport(imp) {
+			continue
+		}
+		file, err := r.resolve(imp)
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+		in.imports = append(in.imports, resolvedImport{path: imp, file: file})
+	}
+	return nil
+}
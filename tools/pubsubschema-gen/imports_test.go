@@ -0,0 +1,128 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestImportResolverSearchesRootsInOrder(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeTree(t, first, map[string]string{"a/v1/common.proto": "message A {}\n"})
+	writeTree(t, second, map[string]string{
+		"a/v1/common.proto": "message Shadowed {}\n",
+		"b/v1/other.proto":  "message B {}\n",
+	})
+	r := importResolver{roots: []string{first, second}}
+	tests := []struct {
+		imp     string
+		want    string
+		wantErr []string
+	}{
+		{imp: "a/v1/common.proto", want: filepath.Join(first, "a", "v1", "common.proto")},
+		{imp: "b/v1/other.proto", want: filepath.Join(second, "b", "v1", "other.proto")},
+		{
+			imp:     "c/v1/missing.proto",
+			wantErr: []string{filepath.Join(first, "c", "v1", "missing.proto"), filepath.Join(second, "c", "v1", "missing.proto")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.imp, func(t *testing.T) {
+			got, err := r.resolve(tt.imp)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("resolve = %s, want an error", got)
+				}
+				for _, searched := range tt.wantErr {
+					if !strings.Contains(err.Error(), searched) {
+						t.Errorf("error %q does not list %s", err, searched)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolve = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveImportsSkipsWellKnownTypes(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a/v1/common.proto": "message A {}\n"})
+	src := "syntax = \"proto3\";\nimport \"google/protobuf/timestamp.proto\";\nimport \"a/v1/common.proto\";\nmessage TestEvent {}\n"
+	in := &schemaInput{path: testEventFile, definition: src}
+	if err := resolveImports(in, importResolver{roots: []string{root}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []resolvedImport{{path: "a/v1/common.proto", file: filepath.Join(root, "a", "v1", "common.proto")}}
+	if !reflect.DeepEqual(in.imports, want) {
+		t.Errorf("imports = %+v, want %+v", in.imports, want)
+	}
+}
+
+func TestProtoRootMissingImportFails(t *testing.T) {
+	src := "syntax = \"proto3\";\nimport \"a/v1/missing.proto\";\nmessage TestEvent {}\n"
+	root := t.TempDir()
+	err := generateErr(t, map[string]string{testEventFile: src}, "--proto-root", root)
+	if !strings.Contains(err.Error(), filepath.Join(root, "a", "v1", "missing.proto")) {
+		t.Errorf("error %q does not list the searched path", err)
+	}
+}
+
+func TestInlineImportsOrderIsDeterministic(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a/v1/common.proto": "syntax = \"proto3\";\npackage a.v1;\nimport \"google/protobuf/timestamp.proto\";\nimport \"b/v1/other.proto\";\nmessage A {}\n",
+		"b/v1/other.proto":  "syntax = \"proto3\";\npackage b.v1;\nimport \"google/protobuf/duration.proto\";\nmessage B {}\n",
+		"c/v1/extra.proto":  "syntax = \"proto3\";\npackage c.v1;\nimport \"b/v1/other.proto\";\nmessage C {}\n",
+	})
+	tests := []struct {
+		name    string
+		imports []string
+	}{
+		{name: "sorted", imports: []string{"a/v1/common.proto", "b/v1/other.proto", "c/v1/extra.proto"}},
+		{name: "reversed", imports: []string{"c/v1/extra.proto", "b/v1/other.proto", "a/v1/common.proto"}},
+		{name: "transitive first", imports: []string{"c/v1/extra.proto", "a/v1/common.proto"}},
+		{name: "transitive only", imports: []string{"a/v1/common.proto", "c/v1/extra.proto"}},
+	}
+	var want string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var src strings.Builder
+			src.WriteString("syntax = \"proto3\";\npackage coreapp.test.v1;\n")
+			for _, imp := range tt.imports {
+				src.WriteString("import \"" + imp + "\";\n")
+			}
+			src.WriteString("message TestEvent {}\n")
+			out := generate(t, map[string]string{testEventFile: src.String()}, "--proto-root", root, "--inline-imports")
+			var doc struct {
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got := doc.Spec.Definition
+			if want == "" {
+				want = got
+				order := []string{"import \"google/protobuf/duration.proto\";", "import \"google/protobuf/timestamp.proto\";", "message TestEvent", "message A", "message B", "message C"}
+				for i := 1; i < len(order); i++ {
+					if strings.Index(got, order[i-1]) > strings.Index(got, order[i]) {
+						t.Errorf("%q comes after %q:\n%s", order[i-1], order[i], got)
+					}
+				}
+			}
+			if got != want {
+				t.Errorf("definition =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// loadKustomizationSchema compiles the --kustomization-schema JSON schema.
+func loadKustomizationSchema(path string) (*jsonschema.Schema, error) {
+	s, err := jsonschema.Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("--kustomization-schema: %w", err)
+	}
+	return s, nil
+}
+
+// validateKustomization checks the rendered kustomization against the
+// --kustomization-schema, if one was given.
+func validateKustomization(contents string, opts options) error {
+	if opts.kustomizationSchema == nil {
+		return nil
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal([]byte(contents), &doc); err != nil {
+		return fmt.Errorf("kustomization: %w", err)
+	}
+	// Round-trip through JSON so the validator sees plain JSON values.
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("kustomization: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("kustomization: %w", err)
+	}
+	if err := opts.kustomizationSchema.Validate(v); err != nil {
+		return fmt.Errorf("kustomization violates --kustomization-schema: %w", err)
+	}
+	return nil
+}
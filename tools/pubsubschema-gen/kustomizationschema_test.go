@@ -0,0 +1,93 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// strictKustomizationSchema only allows the fields a plain run writes and
+// resources named like generated schemas.
+const strictKustomizationSchema = `{
+  "type": "object",
+  "required": ["apiVersion", "kind", "resources"],
+  "additionalProperties": false,
+  "properties": {
+    "apiVersion": {"const": "kustomize.config.k8s.io/v1beta1"},
+    "kind": {"const": "Kustomization"},
+    "resources": {"type": "array", "items": {"type": "string", "pattern": "\\.schema\\.yaml$"}}
+  }
+}`
+
+func TestKustomizationSchema(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "strict.json")
+	writeTree(t, dir, map[string]string{"strict.json": strictKustomizationSchema})
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "conforming", args: nil},
+		{name: "grouped still conforms", args: []string{"--kustomization-group-by", "package"}},
+		{name: "extra field", args: []string{"--definition-mode", "configmap"}, wantErr: "additionalProperties 'replacements' not allowed"},
+		{name: "resource pattern", args: []string{"--emit-topics"}, wantErr: "does not match pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := map[string]string{testEventFile: testEventProto}
+			args := append([]string{"--kustomization-schema", schema}, tt.args...)
+			if tt.wantErr == "" {
+				generate(t, inputs, args...)
+				return
+			}
+			err := generateErr(t, inputs, args...)
+			if !strings.Contains(err.Error(), "kustomization.yaml: kustomization violates --kustomization-schema") || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want a violation mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateKustomization(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "strict.json")
+	writeTree(t, filepath.Dir(schema), map[string]string{"strict.json": strictKustomizationSchema})
+	compiled, err := loadKustomizationSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	conforming := renderKustomization([]string{"a.schema.yaml"}, nil, opts)
+	if err := validateKustomization("not: [valid", opts); err != nil {
+		t.Errorf("without a schema nothing is checked, got %v", err)
+	}
+	opts.kustomizationSchema = compiled
+	tests := []struct {
+		name     string
+		contents string
+		ok       bool
+	}{
+		{"conforming", conforming, true},
+		{"hand-added field", conforming + "namespace: events\n", false},
+		{"wrong kind", strings.Replace(conforming, "kind: Kustomization", "kind: Component", 1), false},
+		{"missing resources", "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\n", false},
+		{"not YAML", "resources: [", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateKustomization(tt.contents, opts); (err == nil) != tt.ok {
+				t.Errorf("validateKustomization = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoadKustomizationSchemaErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"bad.json": `{"type": 7}`})
+	for _, path := range []string{filepath.Join(dir, "missing.json"), filepath.Join(dir, "bad.json")} {
+		if _, err := loadKustomizationSchema(path); err == nil || !strings.HasPrefix(err.Error(), "--kustomization-schema: ") {
+			t.Errorf("loadKustomizationSchema(%s) = %v", path, err)
+		}
+	}
+}
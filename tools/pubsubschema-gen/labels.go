@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxLabelValueLength     = 63
+	maxLabelNameLength      = 63
+	maxLabelKeyPrefixLength = 253
+)
+
+// validateLabelKey checks key against the Kubernetes label key syntax: an
+// optional DNS subdomain prefix of at most 253 characters and a slash,
+// followed by a name of at most 63 characters that starts and ends with an
+// alphanumeric and has only alphanumerics, '-', '_' and '.' in between.
+func validateLabelKey(key string) error {
+	name := key
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if prefix == "" || len(prefix) > maxLabelKeyPrefixLength {
+			return fmt.Errorf("label key %q: prefix must be 1 to %d characters", key, maxLabelKeyPrefixLength)
+		}
+		for _, part := range strings.Split(prefix, ".") {
+			if part == "" || !isLabelAlnum(part[0]) || !isLabelAlnum(part[len(part)-1]) {
+				return fmt.Errorf("label key %q: prefix must be a lowercase DNS subdomain", key)
+			}
+			for i := 0; i < len(part); i++ {
+				if c := part[i]; !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+					return fmt.Errorf("label key %q: prefix must be a lowercase DNS subdomain", key)
+				}
+			}
+		}
+	}
+	if name == "" || len(name) > maxLabelNameLength {
+		return fmt.Errorf("label key %q: name must be 1 to %d characters", key, maxLabelNameLength)
+	}
+	if !isLabelAlnum(name[0]) || !isLabelAlnum(name[len(name)-1]) {
+		return fmt.Errorf("label key %q: name must start and end with an alphanumeric character", key)
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; !isLabelAlnum(c) && c != '-' && c != '_' && c != '.' {
+			return fmt.Errorf("label key %q: name contains invalid character %q", key, c)
+		}
+	}
+	return nil
+}
+
+// sanitizeLabelValue turns s into a valid Kubernetes label value: characters
+// outside [A-Za-z0-9._-] become dashes, the result is cut to 63 characters,
+// and it is trimmed so it starts and ends with an alphanumeric. An empty
+// result is a valid (empty) label value.
+func sanitizeLabelValue(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !isLabelAlnum(c) && c != '.' && c != '_' && c != '-' {
+			b[i] = '-'
+		}
+	}
+	v := string(b)
+	if len(v) > maxLabelValueLength {
+		v = v[:maxLabelValueLength]
+	}
+	return strings.TrimFunc(v, func(r rune) bool { return r > 0x7f || !isLabelAlnum(byte(r)) })
+}
+
+// pathLabels maps the directory segments of rel (the input path relative to
+// --pubsub-dir) to label keys by position. An empty key skips its segment, and
+// segments beyond the mapping, or keys beyond the path depth, yield nothing.
+func pathLabels(rel string, keys []string) map[string]string {
+	segs := strings.Split(rel, "/")
+	segs = segs[:len(segs)-1]
+	labels := make(map[string]string)
+	for i, key := range keys {
+		if i >= len(segs) {
+			break
+		}
+		if key != "" {
+			labels[key] = segs[i]
+		}
+	}
+	return labels
+}
+
+func isLabelAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
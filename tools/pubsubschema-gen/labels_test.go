@@ -0,0 +1,151 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"coreapp.test.v1", "coreapp.test.v1"},
+		{"a/b c", "a-b-c"},
+		{"-.lead_and_trail._", "lead_and_trail"},
+		{"", ""},
+		{strings.Repeat("a", 70), strings.Repeat("a", maxLabelValueLength)},
+		{"café", "caf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := sanitizeLabelValue(tt.in); got != tt.want {
+				t.Errorf("sanitizeLabelValue(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateLabelKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"domain", true},
+		{"app.kubernetes.io/part-of", true},
+		{"Proto_Package.v1", true},
+		{strings.Repeat("a", maxLabelNameLength), true},
+		{strings.Repeat("a", maxLabelNameLength+1), false},
+		{"example.com/" + strings.Repeat("a", maxLabelNameLength), true},
+		{strings.Repeat("a", maxLabelKeyPrefixLength+1) + "/name", false},
+		{"", false},
+		{"-domain", false},
+		{"domain.", false},
+		{"my domain", false},
+		{"/name", false},
+		{"example.com/", false},
+		{"Example.com/name", false},
+		{"example..com/name", false},
+		{"-example.com/name", false},
+		{"a/b/c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if err := validateLabelKey(tt.key); (err == nil) != tt.ok {
+				t.Errorf("validateLabelKey(%q) = %v, want ok %v", tt.key, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLabelKeyFlagsAreValidated(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"--labels-from-path", "domain,,example.com/version"}, ""},
+		{[]string{"--labels-from-path", "domain,bad key"}, `invalid --labels-from-path: label key "bad key"`},
+		{[]string{"--package-label", "proto-package"}, ""},
+		{[]string{"--package-label", "proto/package/"}, `invalid --package-label: label key "proto/package/"`},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			inputs := map[string]string{testEventFile: testEventProto}
+			if tt.wantErr == "" {
+				generate(t, inputs, tt.args...)
+				return
+			}
+			if err := generateErr(t, inputs, tt.args...); !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPathLabels(t *testing.T) {
+	tests := []struct {
+		rel  string
+		keys []string
+		want map[string]string
+	}{
+		{"billing/ledger/v1/a.pubsub.proto", []string{"domain", "subdomain", "version"}, map[string]string{"domain": "billing", "subdomain": "ledger", "version": "v1"}},
+		{"billing/ledger/v1/a.pubsub.proto", []string{"domain", "", "version"}, map[string]string{"domain": "billing", "version": "v1"}},
+		{"billing/a.pubsub.proto", []string{"domain", "subdomain"}, map[string]string{"domain": "billing"}},
+		{"a.pubsub.proto", []string{"domain"}, map[string]string{}},
+		{"billing/ledger/a.pubsub.proto", []string{"domain"}, map[string]string{"domain": "billing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rel+" "+strings.Join(tt.keys, ","), func(t *testing.T) {
+			if got := pathLabels(tt.rel, tt.keys); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pathLabels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelsFromPathNestedFixture(t *testing.T) {
+	inputs := map[string]string{
+		"billing/Ledger Svc/v1/a.v1.Entry.pubsub.proto": "syntax = \"proto3\";\nmessage Entry {}\n",
+		"billing/payments/v2/a.v1.Charge.pubsub.proto":  "syntax = \"proto3\";\nmessage Charge {}\n",
+	}
+	tests := []struct {
+		keys string
+		want map[string]map[string]string
+	}{
+		{
+			"domain, subdomain ,version",
+			map[string]map[string]string{
+				"a-v1-entry":  {"domain": "billing", "subdomain": "Ledger-Svc", "version": "v1"},
+				"a-v1-charge": {"domain": "billing", "subdomain": "payments", "version": "v2"},
+			},
+		},
+		{
+			"domain,,version,extra",
+			map[string]map[string]string{
+				"a-v1-entry":  {"domain": "billing", "version": "v1"},
+				"a-v1-charge": {"domain": "billing", "version": "v2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keys, func(t *testing.T) {
+			out := generate(t, inputs, "--glob", "*/*/*/*.pubsub.proto", "--labels-from-path", tt.keys)
+			for name, labels := range tt.want {
+				var doc struct {
+					Metadata struct {
+						Labels map[string]string `yaml:"labels"`
+					} `yaml:"metadata"`
+				}
+				if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, name+".schema.yaml"))), &doc); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(doc.Metadata.Labels, labels) {
+					t.Errorf("%s labels = %v, want %v", name, doc.Metadata.Labels, labels)
+				}
+			}
+		})
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"text/template"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 func main() {
@@ -18,25 +22,587 @@ func main() {
 	}
 }
 
+const (
+	emptyWrite = "write"
+	emptySkip  = "skip"
+	emptyError = "error"
+)
+
+// options holds the parsed command-line configuration for a generation run.
+type options struct {
+	pubsubDir string
+	outputDir string
+	// mirrorDirs are the further --output-dir values, written the same way.
+	mirrorDirs []string
+	// overlays are the --overlays envs; outputDir and mirrorDirs then point
+	// at the base directories.
+	overlays             []string
+	shape                schemaShape
+	compactKustomization bool
+	dryRun               bool
+	// reconcile lists the files --output-dir should not contain instead of
+	// generating; reconcileApply (--apply) removes them.
+	reconcile           bool
+	reconcileApply      bool
+	planTar             bool
+	definitionFormat    definitionFormat
+	forbiddenFieldTypes []string
+	// typeForMessage maps top-level message names to --type-for-message
+	// schema types.
+	typeForMessage map[string]string
+	nameCollision  string
+	rules          *ruleTracker
+	inputEncoding  string
+	protoFormat    string
+	protoYAMLKey   string
+	docsDir        string
+	protoRoots     []string
+	hashAlgo       string
+	// hashSuffixLength is the number of hash characters --name-collision=suffix
+	// appends.
+	hashSuffixLength int
+	// nameLengthWarn is the --name-length-warn threshold, 0 when unset.
+	nameLengthWarn     int
+	comments           string
+	groupBy            string
+	emptyKustomization string
+	ioConcurrency      int
+	parallelPrune      bool
+	prefixFromDir      bool
+	namePrefix         string
+	nameSuffix         string
+	// nameTemplate is the parsed --name-template, nil when unset.
+	nameTemplate *template.Template
+	nameCommand  string
+	// runCommand runs --name-command; nil uses execCommand.
+	runCommand        commandRunner
+	inlineImports     bool
+	annotateSourceMap bool
+	batchSize         int
+	stripPackage      bool
+	packageLabel      string
+	labelsFromPath    []string
+	concurrency       int
+	// readConcurrency bounds input and import reads through readSem;
+	// readFile replaces os.ReadFile for them when set.
+	readConcurrency            int
+	readSem                    semaphore
+	readFile                   func(path string) ([]byte, error)
+	maxDefinitionLines         int
+	failOnDuplicateDefinition  bool
+	requireMessageMatchesFile  bool
+	requireFieldComments       bool
+	requireFieldCommentsNested bool
+	validateFieldNumbers       bool
+	enforceProto3              bool
+	// fixProto3Syntax is --fix: add the syntax statement --enforce-proto3
+	// requires when it is missing.
+	fixProto3Syntax bool
+	consumersReport string
+	// outputFormat is formatConfigConnector, formatCrossplane or formatRaw;
+	// shape is unset for raw.
+	outputFormat string
+	rawIndex     bool
+	// definitionLinePrefix is prepended to each raw definition line.
+	definitionLinePrefix string
+	emitTopics           bool
+	// bundle implies emitTopics.
+	bundle                    bool
+	topicEncoding             string
+	provenanceFile            string
+	bufIndex                  string
+	bufModule                 string
+	changeReport              string
+	trimLeadingBlankLines     bool
+	normalizeWhitespace       bool
+	fieldFilterOption         string
+	fixBraceFormatting        bool
+	definitionFooter          string
+	stateFile                 string
+	warningsAsAnnotations     bool
+	definitionFilenameComment bool
+	// stateConfig fingerprints the flags for --state-file.
+	stateConfig string
+	// kustomizationSchema is compiled from --kustomization-schema.
+	kustomizationSchema *jsonschema.Schema
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func run(argv []string) error {
+	if len(argv) > 0 && argv[0] == "name-template" {
+		return runNameTemplate(argv[1:], os.Stdout)
+	}
 	fs := flag.NewFlagSet("pubsubschema-gen", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
-	pubsubDir := fs.String("pubsub-dir", "gen/proto/infra/pubsub", "Directory containing `*.pubsub.proto` files.")
+	pubsubDir := fs.String("pubsub-dir", "gen/proto/infra/pubsub", "Directory containing `*.pubsub.proto` files. Defaults to $"+pubsubDirEnv+" when that is set.")
 	globPattern := fs.String("glob", "*.pubsub.proto", "Glob pattern within --pubsub-dir to match pubsub proto files.")
-	outputDir := fs.String("output-dir", "", "Directory to write generated schema YAMLs into.")
+	overlays := fs.String("overlays", "", "Comma-separated envs: write the schemas to <output-dir>/"+overlayBase+" and a kustomization overlay per env in <output-dir>/<env> with that namespace and name prefix. With --emit-topics each overlay also gets a "+overlayConfig+" so schemaRef and topicRef names follow the prefix.")
+	var outputDirs stringsFlag
+	fs.Var(&outputDirs, "output-dir", "Directory to write generated schema YAMLs into. Repeatable: every directory gets the full set and is pruned on its own.")
+	apiVersion := fs.String("api-version", defaultAPIVersion, "Config Connector PubSubSchema CRD version to render (e.g. v1beta1, v1).")
+	compactKustomization := fs.Bool("compact-kustomization", false, "Write the kustomization resources list in YAML flow style.")
+	dryRun := fs.Bool("dry-run", false, "Print the planned removals and writes without touching --output-dir.")
+	reconcileMode := fs.Bool("reconcile", false, "Instead of generating, list the YAML files in --output-dir that no current input produces, including ones without a generated suffix.")
+	reconcileApply := fs.Bool("apply", false, "With --reconcile, remove the listed files.")
+	planTar := fs.Bool("plan-tar", false, "With --dry-run, write the planned output tree to stdout as a tar stream.")
+	definitionEOL := fs.String("definition-eol", "lf", "Line endings for the embedded definition: lf or crlf (crlf is emitted as a quoted scalar).")
+	nameCollision := fs.String("name-collision", collisionError, "How to handle inputs that derive the same schema name: error, suffix or skip.")
+	rulesReport := fs.String("rules-report", "", "Write a JSON report of validation rules and their passed, failed and resolved counts (a resolved name collision was handled by --name-collision) to this path.")
+	protoFormat := fs.String("proto-format", protoFormatRaw, "Input format: raw .proto files, yaml-embedded documents carrying the proto under --proto-yaml-key, or descriptor-set files holding a binary FileDescriptorSet (protoc --include_imports --include_source_info --descriptor_set_out) whose last file is re-emitted as the definition.")
+	protoYAMLKey := fs.String("proto-yaml-key", "definition", "Dotted key path of the proto definition in yaml-embedded inputs.")
+	inputEncoding := fs.String("input-encoding", "utf-8", "Encoding of input protos, transcoded to UTF-8: "+strings.Join(inputEncodings, ", ")+".")
+	emitDocs := fs.String("emit-docs", "", "Also write a Markdown stub per schema into this directory.")
+	nameLengthWarn := fs.Int("name-length-warn", 0, "Warn about schema names longer than this many characters, without failing (0 disables).")
+	hashSuffixLength := fs.Int("hash-suffix-length", 8, "Hex characters of the path hash appended by --name-collision=suffix.")
+	hashAlgo := fs.String("hash-algo", "sha256", "Hash algorithm for all hash-derived outputs: "+strings.Join(hashAlgos, ", ")+".")
+	comments := fs.String("comments", commentsAll, "Comments to keep in the embedded definition: all, top (leading file comment only) or none.")
+	groupBy := fs.String("kustomization-group-by", "", "Group kustomization resources under comment headers. Supported: package.")
+	emptyKustomization := fs.String("empty-kustomization", emptySkip, "When no schemas are generated: write an empty kustomization, skip (leave output-dir untouched; --reconcile still runs) or error.")
+	blockIndent := fs.String("block-indent", "", "Indentation of the definition literal block (spaces only). Defaults to one level below the definition key (four spaces for v1beta1).")
+	ioConcurrency := fs.Int("io-concurrency", defaultIOConcurrency, "Maximum number of concurrent filesystem operations.")
+	parallelPrune := fs.Bool("parallel-prune", false, "Remove stale generated files concurrently (bounded by --io-concurrency).")
+	nameTemplate := fs.String("name-template", "", "Go template for the filename part of schema names, with .Base, .Parts, .Package, .Message, .Dir, .Rel and the lower, upper, join and replace functions. Try it with the name-template subcommand.")
+	nameCommand := fs.String("name-command", "", "Executable that reads an input's path and default name as JSON on stdin and prints its schema name; the result is still sanitized and checked for collisions.")
+	explainNames := fs.Bool("explain-names", false, "Print each input's schema name derivation step by step and exit without generating.")
+	prefixFromDir := fs.Bool("prefix-from-dir", false, "Prefix schema names with the input's parent directory name (relative to --pubsub-dir). Use with a --glob such as */*.pubsub.proto.")
+	namePrefix := fs.String("name-prefix", "", "Prefix added to every schema name before sanitization (e.g. staging-).")
+	nameSuffix := fs.String("name-suffix", "", "Suffix added to every schema name before sanitization.")
+	outputFormat := fs.String("output-format", formatConfigConnector, "Resource flavour to render: "+formatConfigConnector+", "+formatCrossplane+", or "+formatRaw+" (bare <name>.schema.proto definitions, no kustomization).")
+	bundle := fs.Bool("bundle", false, "Emit schemas, topics and a PubSubSubscription per +consumers entry, attached to the file's topic or the one named by a +topic directive, and fail before writing if a subscription's topic is neither generated nor a hand-authored PubSubTopic in --output-dir. Implies --emit-topics.")
+	emitTopics := fs.Bool("emit-topics", false, "Also write a <name>.topic.yaml PubSubTopic per schema, referencing it (--output-format=config-connector).")
+	topicEncoding := fs.String("topic-encoding", "JSON", "Default schemaSettings.encoding of emitted topics (JSON or BINARY); a +encoding directive overrides it per file.")
+	definitionLinePrefix := fs.String("definition-line-prefix", "", "With --output-format=raw, prepend this string to every line of the written definitions.")
+	rawIndex := fs.Bool("raw-index", false, "With --output-format=raw, write "+rawIndexName+" mapping schema names to files.")
+	crossplaneAPIVersion := fs.String("crossplane-api-version", defaultCrossplaneAPIVersion, "apiVersion of the Crossplane schema managed resource (--output-format=crossplane).")
+	crossplaneKind := fs.String("crossplane-kind", defaultCrossplaneKind, "kind of the Crossplane schema managed resource (--output-format=crossplane).")
+	inlineImports := fs.Bool("inline-imports", false, "Inline imported files (resolved via --proto-root) into the definition.")
+	annotateSourceMap := fs.Bool("annotate-source-map", false, "Annotate each schema with the source file of each definition line range.")
+	batchSize := fs.Int("batch-size", 0, "Load, render and write inputs this many at a time to bound memory (0 = all at once).")
+	stripPackage := fs.Bool("strip-package", false, "Remove the top-level package declaration from the embedded definition.")
+	labelsFromPath := fs.String("labels-from-path", "", "Comma-separated label keys for the input's directory segments under --pubsub-dir, by position (e.g. domain,subdomain,version). Leave an entry empty to skip a segment.")
+	packageLabel := fs.String("package-label", "", "Record the proto package (including one removed by --strip-package) as a label with this key.")
+	readConcurrency := fs.Int("read-concurrency", 0, "Maximum number of input and imported files read at once, independent of --concurrency (0 = no separate limit). Writes start once every input is rendered and are bounded by --io-concurrency.")
+	concurrency := fs.Int("concurrency", 1, "Number of inputs to load and render in parallel. Output is identical at every level.")
+	requireFieldComments := fs.Bool("require-field-comments", false, "Fail if a field of a top-level message has no comment directly before it.")
+	requireFieldCommentsNested := fs.Bool("require-field-comments-nested", false, "With --require-field-comments, check the fields of nested messages too.")
+	enforceProto3 := fs.Bool("enforce-proto3", false, "Fail on definitions that do not declare proto3 syntax.")
+	fixProto3 := fs.Bool("fix", false, "With --enforce-proto3, add a proto3 syntax statement to embedded definitions that have none; the input files are not changed. Explicit proto2 is still an error.")
+	validateFieldNumbers := fs.Bool("validate-field-numbers", false, "Fail on top-level message fields with duplicate, non-positive or reserved (19000-19999) field numbers.")
+	requireMessageMatchesFile := fs.Bool("require-message-name-matches-file", false, "Fail unless a top-level message is named after the file (the part of the base name after the last dot).")
+	failOnDuplicateDefinition := fs.Bool("fail-on-duplicate-definition", false, "Fail if two inputs produce byte-identical definitions after normalization.")
+	maxDefinitionLines := fs.Int("max-definition-lines", 0, "Fail if a normalized definition has more lines than this (0 = no limit).")
+	changeReport := fs.String("change-report", "", "Write a JSON summary per schema of how its definition differs from the one in --output-dir: new, unchanged, additive, breaking (removed or renumbered fields and values, changed types) or removed.")
+	bufIndex := fs.String("buf-index", "", "Write a JSON index of the generated schemas by proto package and version suffix, for Buf schema registry tooling. Inputs without a package are grouped by the package in their file name, or else by their schema name.")
+	bufModule := fs.String("buf-module", "", "Module recorded in the --buf-index. Defaults to the module of the nearest buf.yaml above --pubsub-dir: its name, or else its path.")
+	provenanceFile := fs.String("provenance-file", "", "Write a JSON record of each input's path and --hash-algo digest, the tool version and the run time (pinned by SOURCE_DATE_EPOCH).")
+	consumersReport := fs.String("consumers-report", "", "Write a JSON map of schema name to the services declared by +consumers directives.")
+	trimLeadingBlankLines := fs.Bool("trim-leading-blank-lines", false, "Remove blank lines before the first line of the embedded definition.")
+	kustomizationSchema := fs.String("kustomization-schema", "", "JSON schema file the generated kustomization must satisfy before it is written.")
+	definitionFilenameComment := fs.Bool("definition-filename-comment", false, "Start every embedded definition with a // source: comment naming the input relative to --pubsub-dir.")
+	warningsAsAnnotations := fs.Bool("warnings-as-annotations", false, "Record non-fatal issues (proto2 syntax, missing package, ...) in a "+annotationPrefix+"warnings annotation on each affected schema.")
+	stateFile := fs.String("state-file", "", "Incremental mode: record input digests here and, on the next run, only regenerate inputs that changed. Removed inputs are pruned as usual.")
+	definitionFooter := fs.String("definition-footer", "", "Text appended to the end of every embedded definition, e.g. a closing comment. The result must still parse.")
+	normalizeWhitespace := fs.Bool("normalize-whitespace", false, "Canonicalize whitespace between tokens: single spaces, no trailing whitespace, two-space indentation per brace level. Strings and comments are untouched.")
+	fieldFilterOption := fs.String("field-filter-option", "", "Drop message fields whose boolean option of this name is true, e.g. internal for [(internal) = true], from the embedded definition. With --proto-format=descriptor-set the option is looked up among the set's FieldOptions extensions and fields are dropped from the descriptor.")
+	fixBraceFormatting := fs.Bool("fix-brace-formatting", false, "Put each closing brace of a message, enum, oneof or service on its own line, indented like its opening line, without blank lines before it. Unbalanced braces are an error.")
+	definitionMode := fs.String("definition-mode", definitionInline, "Where the definition lives: inline in the schema, or configmap (a local-config ConfigMap copied in by kustomize replacements).")
+	var forbidFieldTypes stringsFlag
+	fs.Var(&forbidFieldTypes, "forbid-field-type", "Fail if any message field uses this type (e.g. google.protobuf.Any). Repeatable.")
+	var typeForMessage stringsFlag
+	fs.Var(&typeForMessage, "type-for-message", "Schema type for inputs with this top-level message, as name=TYPE (AVRO or PROTOCOL_BUFFER; the name may be package-qualified). Repeatable.")
+	var protoRoots stringsFlag
+	fs.Var(&protoRoots, "proto-root", "Directory to resolve proto imports against, searched in order. Repeatable.")
 
 	if err := fs.Parse(argv); err != nil {
 		return err
 	}
-	if *outputDir == "" {
+	if len(outputDirs) == 0 && !*explainNames {
 		return usage(fs, "missing required flag: --output-dir")
 	}
+	for i, dir := range outputDirs {
+		for _, other := range outputDirs[:i] {
+			if filepath.Clean(dir) == filepath.Clean(other) {
+				return usage(fs, fmt.Sprintf("--output-dir %s given twice", dir))
+			}
+		}
+	}
+	var envs []string
+	if *overlays != "" {
+		for _, env := range strings.Split(*overlays, ",") {
+			env = strings.TrimSpace(env)
+			if err := validateOverlayName(env); err != nil {
+				return usage(fs, "--overlays: "+err.Error())
+			}
+			envs = append(envs, env)
+		}
+		for i := range outputDirs {
+			outputDirs[i] = filepath.Join(outputDirs[i], overlayBase)
+		}
+	}
+	outputDir, mirrorDirs := "", []string(nil)
+	if len(outputDirs) > 0 {
+		outputDir, mirrorDirs = outputDirs[0], outputDirs[1:]
+	}
+	if len(outputDirs) > 1 {
+		switch {
+		case *batchSize > 0:
+			return usage(fs, "--batch-size supports a single --output-dir")
+		case *planTar:
+			return usage(fs, "--plan-tar supports a single --output-dir")
+		}
+	}
+	if *definitionEOL != "lf" && *definitionEOL != "crlf" {
+		return usage(fs, fmt.Sprintf("invalid --definition-eol %q: want lf or crlf", *definitionEOL))
+	}
+	messageTypes, err := parseTypeForMessage(typeForMessage)
+	if err != nil {
+		return usage(fs, "--type-for-message "+err.Error())
+	}
+	var parsedNameTemplate *template.Template
+	if *nameTemplate != "" {
+		var err error
+		if parsedNameTemplate, err = parseNameTemplate(*nameTemplate); err != nil {
+			return usage(fs, fmt.Sprintf("invalid --name-template: %v", err))
+		}
+	}
+	switch *nameCollision {
+	case collisionError, collisionSuffix, collisionSkip:
+	default:
+		return usage(fs, fmt.Sprintf("invalid --name-collision %q: want error, suffix or skip", *nameCollision))
+	}
+	for _, report := range []struct{ flag, path string }{
+		{"rules-report", *rulesReport},
+		{"consumers-report", *consumersReport},
+		{"provenance-file", *provenanceFile},
+		{"buf-index", *bufIndex},
+		{"change-report", *changeReport},
+		{"state-file", *stateFile},
+	} {
+		if suffix := prunedSuffix(report.path, *reconcileMode && *reconcileApply); suffix != "" {
+			return usage(fs, fmt.Sprintf("--%s must not end in %s: it would look like a generated file and be pruned", report.flag, suffix))
+		}
+	}
+	var pathLabelKeys []string
+	if *labelsFromPath != "" {
+		pathLabelKeys = strings.Split(*labelsFromPath, ",")
+		for i := range pathLabelKeys {
+			pathLabelKeys[i] = strings.TrimSpace(pathLabelKeys[i])
+			if pathLabelKeys[i] == "" {
+				continue
+			}
+			if err := validateLabelKey(pathLabelKeys[i]); err != nil {
+				return usage(fs, "invalid --labels-from-path: "+err.Error())
+			}
+		}
+	}
+	if *packageLabel != "" {
+		if err := validateLabelKey(*packageLabel); err != nil {
+			return usage(fs, "invalid --package-label: "+err.Error())
+		}
+	}
+	switch *protoFormat {
+	case protoFormatRaw:
+	case protoFormatYAMLEmbedded:
+		if *protoYAMLKey == "" {
+			return usage(fs, "--proto-yaml-key must not be empty")
+		}
+		if !flagSet(fs, "glob") {
+			*globPattern = "*.pubsub.yaml"
+		}
+	case protoFormatDescriptorSet:
+		if !flagSet(fs, "glob") {
+			*globPattern = "*.pubsub.binpb"
+		}
+	default:
+		return usage(fs, fmt.Sprintf("invalid --proto-format %q: want raw, yaml-embedded or descriptor-set", *protoFormat))
+	}
+	if !validInputEncoding(*inputEncoding) {
+		return usage(fs, fmt.Sprintf("invalid --input-encoding %q", *inputEncoding))
+	}
+	if !validHashAlgo(*hashAlgo) {
+		return usage(fs, fmt.Sprintf("invalid --hash-algo %q", *hashAlgo))
+	}
+	if *nameLengthWarn < 0 || *nameLengthWarn > maxSchemaNameLength {
+		return usage(fs, fmt.Sprintf("invalid --name-length-warn %d: want 0 to %d", *nameLengthWarn, maxSchemaNameLength))
+	}
+	if err := validateHashSuffixLength(*hashSuffixLength, *hashAlgo); err != nil {
+		return usage(fs, fmt.Sprintf("invalid --hash-suffix-length %d: %v", *hashSuffixLength, err))
+	}
+	if *hashSuffixLength < minSafeHashSuffixLength && *nameCollision == collisionSuffix {
+		fmt.Fprintf(os.Stderr, "warning: --hash-suffix-length %d allows only %d distinct suffixes; colliding names may get the same suffix\n", *hashSuffixLength, 1<<(4*uint(*hashSuffixLength)))
+	}
+	switch *comments {
+	case commentsAll, commentsTop, commentsNone:
+	default:
+		return usage(fs, fmt.Sprintf("invalid --comments %q: want all, top or none", *comments))
+	}
+	if *groupBy != "" && *groupBy != "package" {
+		return usage(fs, fmt.Sprintf("invalid --kustomization-group-by %q: want package", *groupBy))
+	}
+	if *groupBy != "" && *compactKustomization {
+		return usage(fs, "--kustomization-group-by cannot be combined with --compact-kustomization")
+	}
+	switch *emptyKustomization {
+	case emptyWrite, emptySkip, emptyError:
+	default:
+		return usage(fs, fmt.Sprintf("invalid --empty-kustomization %q: want write, skip or error", *emptyKustomization))
+	}
+	if *ioConcurrency < 1 {
+		return usage(fs, "--io-concurrency must be at least 1")
+	}
+	if *inlineImports && len(protoRoots) == 0 {
+		return usage(fs, "--inline-imports requires at least one --proto-root")
+	}
+	if *maxDefinitionLines < 0 {
+		return usage(fs, "--max-definition-lines must not be negative")
+	}
+	if *fixProto3 && !*enforceProto3 {
+		return usage(fs, "--fix requires --enforce-proto3")
+	}
+	if *requireFieldCommentsNested && !*requireFieldComments {
+		return usage(fs, "--require-field-comments-nested requires --require-field-comments")
+	}
+	if *requireFieldComments && *comments != commentsAll {
+		// Checks run on the published definition, which would have no comments.
+		return usage(fs, "--require-field-comments needs --comments=all")
+	}
+	if *readConcurrency < 0 {
+		return usage(fs, "--read-concurrency must not be negative")
+	}
+	if *concurrency < 1 {
+		return usage(fs, "--concurrency must be at least 1")
+	}
+	if *batchSize < 0 {
+		return usage(fs, "--batch-size must not be negative")
+	}
+	if *definitionMode != definitionInline && *definitionMode != definitionConfigMap {
+		return usage(fs, fmt.Sprintf("invalid --definition-mode %q: want inline or configmap", *definitionMode))
+	}
+	if *planTar && !*dryRun {
+		return usage(fs, "--plan-tar requires --dry-run")
+	}
+	if *reconcileApply && !*reconcileMode {
+		return usage(fs, "--apply requires --reconcile")
+	}
+	if *reconcileMode {
+		switch {
+		case *dryRun, *batchSize > 0, *stateFile != "":
+			// --reconcile without --apply is already read-only.
+			return usage(fs, "--reconcile cannot be combined with --dry-run, --batch-size or --state-file")
+		}
+	}
+
+	var shape schemaShape
+	switch *outputFormat {
+	case formatConfigConnector:
+		var ok bool
+		if shape, ok = lookupSchemaShape(*apiVersion); !ok {
+			fmt.Fprintf(os.Stderr, "warning: unknown --api-version %q, falling back to %s\n", *apiVersion, defaultAPIVersion)
+		}
+	case formatCrossplane:
+		shape = crossplaneShape(*crossplaneAPIVersion, *crossplaneKind)
+	case formatRaw:
+		if *definitionMode != definitionInline {
+			return usage(fs, "--definition-mode=configmap needs a manifest output format")
+		}
+		if *definitionEOL != "lf" {
+			// Files are always written with LF endings.
+			return usage(fs, "--definition-eol=crlf needs a manifest output format")
+		}
+	default:
+		return usage(fs, fmt.Sprintf("invalid --output-format %q: want %s, %s or %s", *outputFormat, formatConfigConnector, formatCrossplane, formatRaw))
+	}
+	if *bundle {
+		if *batchSize > 0 {
+			return usage(fs, "--bundle checks references across all inputs before writing and cannot be combined with --batch-size")
+		}
+		*emitTopics = true
+	}
+	if *emitTopics && *outputFormat != formatConfigConnector {
+		return usage(fs, "--emit-topics requires --output-format=config-connector")
+	}
+	if !validTopicEncoding(*topicEncoding) {
+		return usage(fs, fmt.Sprintf("invalid --topic-encoding %q: want JSON or BINARY", *topicEncoding))
+	}
+	if *overlays != "" {
+		switch {
+		case *outputFormat == formatRaw:
+			return usage(fs, "--overlays needs a kustomization and cannot be used with --output-format=raw")
+		case *planTar:
+			return usage(fs, "--plan-tar does not support --overlays")
+		}
+	}
+	if *bufModule != "" && *bufIndex == "" {
+		return usage(fs, "--buf-module requires --buf-index")
+	}
+	if *rawIndex && *outputFormat != formatRaw {
+		return usage(fs, "--raw-index requires --output-format=raw")
+	}
+	if *definitionLinePrefix != "" && *outputFormat != formatRaw {
+		// Prefixed lines would no longer be a valid YAML block scalar.
+		return usage(fs, "--definition-line-prefix requires --output-format=raw")
+	}
+
+	if *blockIndent != "" && *outputFormat != formatRaw {
+		if err := shape.validateBlockIndent(*blockIndent); err != nil {
+			return usage(fs, err.Error())
+		}
+	}
+
+	if dir := os.Getenv(pubsubDirEnv); dir != "" && !flagSet(fs, "pubsub-dir") {
+		if err := checkReadableDir(dir); err != nil {
+			return fmt.Errorf("$%s: %w", pubsubDirEnv, err)
+		}
+		*pubsubDir = dir
+	}
+	if *bufIndex != "" && *bufModule == "" {
+		module, err := findBufModule(*pubsubDir)
+		if err != nil {
+			return fmt.Errorf("--buf-index: %w", err)
+		}
+		*bufModule = module
+	}
+	inputDirs := append([]string{*pubsubDir}, protoRoots...)
+	for _, out := range outputDirs {
+		for _, in := range inputDirs {
+			overlap, err := dirsOverlap(out, in)
+			if err != nil {
+				return err
+			}
+			if overlap {
+				return usage(fs, fmt.Sprintf("--output-dir %s overlaps input directory %s", out, in))
+			}
+		}
+	}
 
 	files, err := resolveInputs(*pubsubDir, *globPattern)
 	if err != nil {
 		return err
 	}
-	return generateAll(files, *outputDir)
+	opts := options{
+		pubsubDir:                  *pubsubDir,
+		outputDir:                  outputDir,
+		mirrorDirs:                 mirrorDirs,
+		overlays:                   envs,
+		shape:                      shape,
+		outputFormat:               *outputFormat,
+		rawIndex:                   *rawIndex,
+		definitionLinePrefix:       *definitionLinePrefix,
+		emitTopics:                 *emitTopics,
+		bundle:                     *bundle,
+		topicEncoding:              *topicEncoding,
+		compactKustomization:       *compactKustomization,
+		dryRun:                     *dryRun,
+		reconcile:                  *reconcileMode,
+		reconcileApply:             *reconcileApply,
+		planTar:                    *planTar,
+		definitionFormat:           definitionFormat{eol: *definitionEOL, indent: *blockIndent, mode: *definitionMode},
+		forbiddenFieldTypes:        forbidFieldTypes,
+		typeForMessage:             messageTypes,
+		nameCollision:              *nameCollision,
+		inputEncoding:              *inputEncoding,
+		protoFormat:                *protoFormat,
+		protoYAMLKey:               *protoYAMLKey,
+		docsDir:                    *emitDocs,
+		protoRoots:                 protoRoots,
+		hashAlgo:                   *hashAlgo,
+		hashSuffixLength:           *hashSuffixLength,
+		nameLengthWarn:             *nameLengthWarn,
+		comments:                   *comments,
+		groupBy:                    *groupBy,
+		emptyKustomization:         *emptyKustomization,
+		ioConcurrency:              *ioConcurrency,
+		parallelPrune:              *parallelPrune,
+		prefixFromDir:              *prefixFromDir,
+		namePrefix:                 *namePrefix,
+		nameSuffix:                 *nameSuffix,
+		nameCommand:                *nameCommand,
+		nameTemplate:               parsedNameTemplate,
+		inlineImports:              *inlineImports,
+		annotateSourceMap:          *annotateSourceMap,
+		batchSize:                  *batchSize,
+		stripPackage:               *stripPackage,
+		packageLabel:               *packageLabel,
+		labelsFromPath:             pathLabelKeys,
+		concurrency:                *concurrency,
+		readConcurrency:            *readConcurrency,
+		maxDefinitionLines:         *maxDefinitionLines,
+		failOnDuplicateDefinition:  *failOnDuplicateDefinition,
+		requireMessageMatchesFile:  *requireMessageMatchesFile,
+		requireFieldComments:       *requireFieldComments,
+		requireFieldCommentsNested: *requireFieldCommentsNested,
+		validateFieldNumbers:       *validateFieldNumbers,
+		enforceProto3:              *enforceProto3,
+		fixProto3Syntax:            *fixProto3,
+		consumersReport:            *consumersReport,
+		provenanceFile:             *provenanceFile,
+		bufIndex:                   *bufIndex,
+		bufModule:                  *bufModule,
+		changeReport:               *changeReport,
+		trimLeadingBlankLines:      *trimLeadingBlankLines,
+		normalizeWhitespace:        *normalizeWhitespace,
+		fieldFilterOption:          *fieldFilterOption,
+		fixBraceFormatting:         *fixBraceFormatting,
+		definitionFooter:           *definitionFooter,
+		stateFile:                  *stateFile,
+		warningsAsAnnotations:      *warningsAsAnnotations,
+		definitionFilenameComment:  *definitionFilenameComment,
+	}
+	if *explainNames {
+		return printNameDerivations(os.Stdout, files, opts)
+	}
+	if opts.readConcurrency > 0 {
+		opts.readSem = newSemaphore(opts.readConcurrency)
+	}
+	if *stateFile != "" {
+		switch {
+		case *bundle, *planTar, *batchSize > 0, len(mirrorDirs) > 0, *consumersReport != "", *bufIndex != "", *changeReport != "", *failOnDuplicateDefinition:
+			return usage(fs, "--state-file skips unchanged inputs and cannot be combined with --bundle, --plan-tar, --batch-size, several --output-dir, --consumers-report, --buf-index, --change-report or --fail-on-duplicate-definition")
+		}
+		flags := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "state-file" && f.Name != "dry-run" {
+				flags[f.Name] = f.Value.String()
+			}
+		})
+		opts.stateConfig = stateConfig(flags, opts.hashAlgo)
+	}
+	if *kustomizationSchema != "" {
+		if opts.kustomizationSchema, err = loadKustomizationSchema(*kustomizationSchema); err != nil {
+			return err
+		}
+	}
+	if *rulesReport != "" {
+		opts.rules = newRuleTracker()
+	}
+	err = generateAll(files, opts)
+	if *rulesReport != "" {
+		// The report is written even when a rule failed; that is when it matters.
+		if werr := writeRulesReport(*rulesReport, opts.rules, opts); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func usage(fs *flag.FlagSet, extra string) error {
@@ -46,12 +612,57 @@ func usage(fs *flag.FlagSet, extra string) error {
 		b.WriteString("\n\n")
 	}
 	b.WriteString("Usage:\n")
-	b.WriteString("  pubsubschema-gen [--pubsub-dir DIR] [--glob GLOB] --output-dir DIR\n\n")
+	b.WriteString("  pubsubschema-gen [--pubsub-dir DIR] [--glob GLOB] --output-dir DIR\n")
+	b.WriteString("  pubsubschema-gen name-template [--pubsub-dir DIR] [--glob GLOB] TEMPLATE [PATH...]\n\n")
 	b.WriteString("Flags:\n")
 	fs.PrintDefaults()
 	return errors.New(b.String())
 }
 
+// pubsubDirEnv supplies --pubsub-dir when the flag is not given, for
+// containers that mount the protos at a runtime-known path.
+const pubsubDirEnv = "PUBSUBSCHEMA_GEN_PUBSUB_DIR"
+
+// checkReadableDir fails unless dir is a directory whose entries can be
+// listed.
+// dirsOverlap reports whether a and b are the same directory or one
+// contains the other. Pruning an output directory that overlaps an input
+// directory could delete inputs.
+func dirsOverlap(a, b string) (bool, error) {
+	a, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	if b, err = filepath.Abs(b); err != nil {
+		return false, err
+	}
+	within := func(dir, root string) bool {
+		rel, err := filepath.Rel(root, dir)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	return within(a, b) || within(b, a), nil
+}
+
+func checkReadableDir(dir string) error {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
 func resolveInputs(pubsubDir, globPattern string) ([]string, error) {
 	pattern := filepath.Join(pubsubDir, globPattern)
 	matches, err := filepath.Glob(pattern)
@@ -72,35 +683,94 @@ func resolveInputs(pubsubDir, globPattern string) ([]string, error) {
 	return files, nil
 }
 
-func generateAll(pubsubFiles []string, outputDir string) error {
-	if len(pubsubFiles) == 0 {
-		return errors.New("no pubsub proto files found")
+func generateAll(pubsubFiles []string, opts options) error {
+	if opts.batchSize > 0 && !opts.dryRun && len(pubsubFiles) > 0 {
+		inputs, err := planInputs(pubsubFiles, opts)
+		if err != nil {
+			return err
+		}
+		p, err := applyBatched(inputs, opts)
+		if err != nil {
+			return err
+		}
+		return writeReports(p, opts)
 	}
 
-	// Remove stale generated schema files so kustomize doesn't keep applying old schemas.
-	if err := removeGeneratedSchemas(outputDir); err != nil {
+	p, err := buildPlan(pubsubFiles, opts)
+	if err != nil {
 		return err
 	}
-
-	var generated []string
-	for _, p := range pubsubFiles {
-		proto, err := os.ReadFile(p)
+	// Mirrors are taken before anything is written, since writing releases
+	// the rendered contents.
+	plans := []*plan{p}
+	for _, dir := range opts.mirrorDirs {
+		m, err := p.mirror(dir, opts)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, m)
+	}
+	if len(p.schemas) == 0 && len(p.kept) == 0 {
+		switch opts.emptyKustomization {
+		case emptyError:
+			return errors.New("no pubsub proto files found")
+		case emptySkip:
+			if !opts.reconcile {
+				fmt.Fprintf(os.Stderr, "No pubsub proto files found; leaving %s untouched\n", strings.Join(append([]string{opts.outputDir}, opts.mirrorDirs...), ", "))
+				return nil
+			}
+		}
+		// emptyWrite falls through: stale schemas are pruned and the
+		// kustomization is written with an empty resources list. --reconcile
+		// writes nothing either way, so it runs under emptySkip too, and
+		// every manifest left is unreconciled.
+	}
+	for _, pl := range plans {
+		switch {
+		case opts.dryRun && opts.planTar:
+			err = writePlanTar(os.Stdout, pl, opts)
+		case opts.dryRun:
+			err = printPlan(os.Stdout, pl, opts)
+		case opts.reconcile:
+			err = reconcile(pl, opts)
+		default:
+			err = applyPlan(pl, opts)
+		}
 		if err != nil {
 			return err
 		}
-		name := deriveSchemaNameFromFilename(p)
-		out := filepath.Join(outputDir, name+".schema.yaml")
-		manifest := schemaManifest(name, normalizeNewlines(string(proto)))
-		if err := writeFile(out, manifest); err != nil {
+	}
+	if opts.stateFile != "" && !opts.dryRun {
+		if err := saveState(opts.stateFile, p.nextState(opts)); err != nil {
 			return err
 		}
-		fmt.Printf("Wrote %s -> %s\n", name, out)
-		generated = append(generated, filepath.Base(out))
 	}
+	return writeReports(p, opts)
+}
 
-	sort.Strings(generated)
-	if err := writeKustomization(outputDir, generated); err != nil {
-		return err
+// writeReports writes the optional per-run reports derived from the plan.
+// They are written in dry-run mode too. Pruning only considers generated
+// suffixes, so a report may also live in --output-dir.
+func writeReports(p *plan, opts options) error {
+	if opts.consumersReport != "" {
+		if err := writeConsumersReport(opts.consumersReport, p); err != nil {
+			return err
+		}
+	}
+	if opts.provenanceFile != "" {
+		if err := writeProvenanceFile(opts.provenanceFile, p, opts); err != nil {
+			return err
+		}
+	}
+	if opts.bufIndex != "" {
+		if err := writeBufIndex(opts.bufIndex, p, opts); err != nil {
+			return err
+		}
+	}
+	if opts.changeReport != "" {
+		if err := writeChangeReport(opts.changeReport, p, opts); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -126,16 +796,117 @@ func indentForYAMLLiteralBlock(s string, indent string) string {
 	return strings.Join(lines, "\n")
 }
 
-func schemaManifest(schemaName, protoDefinition string) string {
-	return "" +
-		"apiVersion: pubsub.cnrm.cloud.google.com/v1beta1\n" +
-		"kind: PubSubSchema\n" +
-		"metadata:\n" +
-		"  name: " + schemaName + "\n" +
-		"spec:\n" +
-		"  type: PROTOCOL_BUFFER\n" +
-		"  definition: |\n" +
-		indentForYAMLLiteralBlock(protoDefinition, "    ")
+// annotationPrefix namespaces the annotations this tool adds to resources.
+const annotationPrefix = "configmanagement-poc/"
+
+func schemaManifest(opts options, in *schemaInput) string {
+	annotations := make(map[string]string)
+	if opts.shape.nameAnnotation != "" {
+		annotations[opts.shape.nameAnnotation] = in.name
+	}
+	if opts.annotateSourceMap {
+		annotations[annotationPrefix+"source-map"] = in.sourceMap
+	}
+	if len(in.warnings) > 0 {
+		annotations[annotationPrefix+"warnings"] = strings.Join(in.warnings, "; ")
+	}
+
+	var b strings.Builder
+	b.WriteString("apiVersion: " + opts.shape.apiVersion + "\n")
+	b.WriteString("kind: " + opts.shape.kind + "\n")
+	b.WriteString("metadata:\n")
+	b.WriteString("  name: " + in.name + "\n")
+	writeYAMLMap(&b, "  ", "labels", in.labels)
+	writeYAMLMap(&b, "  ", "annotations", annotations)
+	b.WriteString("spec:\n")
+	b.WriteString(opts.shape.renderSpec(in.schemaType, in.definition, opts.definitionFormat))
+	if opts.definitionFormat.mode == definitionConfigMap {
+		return generatedMarker + "\n" + definitionConfigMapManifest(opts, in) + "---\n" + b.String()
+	}
+	return generatedMarker + "\n" + b.String()
+}
+
+// definitionConfigMapName names the ConfigMap holding a schema's definition
+// in --definition-mode=configmap.
+func definitionConfigMapName(schemaName string) string {
+	const suffix = "-definition"
+	return truncateSchemaName(schemaName, maxSchemaNameLength-len(suffix)) + suffix
+}
+
+// definitionConfigMapManifest renders the ConfigMap carrying the definition.
+// It is marked local-config so kustomize uses it for replacements but leaves
+// it out of the build output.
+func definitionConfigMapManifest(opts options, in *schemaInput) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\n")
+	b.WriteString("kind: ConfigMap\n")
+	b.WriteString("metadata:\n")
+	b.WriteString("  name: " + definitionConfigMapName(in.name) + "\n")
+	b.WriteString("  annotations:\n")
+	b.WriteString("    config.kubernetes.io/local-config: \"true\"\n")
+	b.WriteString("data:\n")
+	writeDefinitionField(&b, "  "+definitionConfigMapKey, in.definition, opts.definitionFormat, "    ")
+	return strings.TrimRight(b.String(), " ") + "\n"
+}
+
+const definitionConfigMapKey = "definition"
+
+// writeDefinitionReplacements appends the kustomize replacements that copy
+// each ConfigMap's definition into its schema in --definition-mode=configmap.
+// Only schema resources carry a ConfigMap; topics and subscriptions are
+// skipped.
+func writeDefinitionReplacements(b *strings.Builder, resources []string, opts options) {
+	var schemas []string
+	for _, r := range resources {
+		if strings.HasSuffix(r, opts.outputSuffix()) {
+			schemas = append(schemas, strings.TrimSuffix(r, opts.outputSuffix()))
+		}
+	}
+	if len(schemas) == 0 {
+		return
+	}
+	b.WriteString("\nreplacements:\n")
+	for _, name := range schemas {
+		b.WriteString("  - source:\n")
+		b.WriteString("      kind: ConfigMap\n")
+		b.WriteString("      name: " + definitionConfigMapName(name) + "\n")
+		b.WriteString("      fieldPath: data." + definitionConfigMapKey + "\n")
+		b.WriteString("    targets:\n")
+		b.WriteString("      - select:\n")
+		b.WriteString("          kind: " + opts.shape.kind + "\n")
+		b.WriteString("          name: " + name + "\n")
+		b.WriteString("        fieldPaths:\n")
+		b.WriteString("          - " + opts.shape.definitionFieldPath() + "\n")
+		b.WriteString("        options:\n")
+		b.WriteString("          create: true\n")
+	}
+}
+
+// writeYAMLMap writes key: followed by m's entries sorted by key. Nothing is
+// written for an empty map.
+func writeYAMLMap(b *strings.Builder, indent, key string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteString(indent + key + ":\n")
+	for _, k := range keys {
+		b.WriteString(indent + "  " + k + ": " + yamlValue(m[k]) + "\n")
+	}
+}
+
+// yamlValue leaves values made only of lowercase letters, digits and dashes
+// (such as schema names) bare and double-quotes everything else, which is
+// always safe.
+func yamlValue(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789-") == "" && s[0] >= 'a' && s[0] <= 'z' {
+		return s
+	}
+	return yamlDoubleQuote(s)
 }
 
 func writeFile(path string, contents string) error {
@@ -147,46 +918,158 @@ func writeFile(path string, contents string) error {
 	return os.WriteFile(path, []byte(contents), fs.FileMode(0o644))
 }
 
-func removeGeneratedSchemas(outputDir string) error {
-	entries, err := os.ReadDir(outputDir)
+// prunedSuffix returns the suffix that would get a file at path pruned from
+// an output directory, or "" if there is none. Every generated suffix counts
+// whatever the current flags, since a later run with other flags prunes it
+// too; reconcileApply adds any YAML file.
+func prunedSuffix(path string, reconcileApply bool) string {
+	suffixes := []string{".schema.yaml", rawSuffix, topicSuffix, subscriptionSuffix, docSuffix}
+	if reconcileApply {
+		suffixes = append(suffixes, ".yaml", ".yml")
+	}
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(path, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// listGeneratedFiles returns the basenames of files in dir ending in suffix
+// (e.g. `.schema.yaml`). A missing directory is treated as empty.
+func listGeneratedFiles(dir, suffix string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
+	var names []string
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
 		name := e.Name()
-		if strings.HasSuffix(name, ".schema.yaml") {
-			if err := os.Remove(filepath.Join(outputDir, name)); err != nil {
-				return err
-			}
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, name)
 		}
 	}
-	return nil
+	sort.Strings(names)
+	return names, nil
+}
+
+// removeGeneratedFiles deletes names from dir. A failed deletion does not
+// stop the others; all failures are reported together in names order. With
+// --parallel-prune the deletions run concurrently under the io-concurrency
+// limit, so the removed files and the error are the same either way.
+func removeGeneratedFiles(dir string, names []string, opts options) error {
+	if opts.parallelPrune {
+		return forEachParallel(len(names), newSemaphore(opts.ioConcurrency), func(i int) error {
+			return os.Remove(filepath.Join(dir, names[i]))
+		})
+	}
+	var errs []error
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeKustomization writes the kustomization listing written, the basenames
+// of the files written this run. Each must exist in outputDir so the
+// kustomization never points at a file that isn't there. groups maps a
+// resource to its section label and is nil unless --kustomization-group-by is
+// set.
+func writeKustomization(outputDir string, written []string, groups map[string]string, opts options) error {
+	resources := append([]string(nil), written...)
+	sort.Strings(resources)
+	for _, name := range resources {
+		st, err := os.Stat(filepath.Join(outputDir, name))
+		if err != nil {
+			return fmt.Errorf("kustomization resource: %w", err)
+		}
+		if !st.Mode().IsRegular() {
+			return fmt.Errorf("kustomization resource %s: not a regular file", filepath.Join(outputDir, name))
+		}
+	}
+	contents := renderKustomization(resources, groups, opts)
+	if err := validateKustomization(contents, opts); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(outputDir, "kustomization.yaml"), err)
+	}
+	return writeFile(filepath.Join(outputDir, "kustomization.yaml"), contents)
 }
 
-func writeKustomization(outputDir string, resources []string) error {
+func renderKustomization(resources []string, groups map[string]string, opts options) string {
 	var b strings.Builder
 	b.WriteString("apiVersion: kustomize.config.k8s.io/v1beta1\n")
 	b.WriteString("kind: Kustomization\n\n")
-	b.WriteString("resources:\n")
-	for _, r := range resources {
-		b.WriteString("  - ")
-		b.WriteString(r)
-		b.WriteString("\n")
+	switch {
+	case len(resources) == 0:
+		b.WriteString("resources: []\n")
+	case opts.compactKustomization:
+		// Flow style: resources: [a.schema.yaml, b.schema.yaml]. Generated names are
+		// lowercase, dash- and dot-separated so they never need quoting.
+		sorted := append([]string(nil), resources...)
+		sort.Strings(sorted)
+		b.WriteString("resources: [")
+		b.WriteString(strings.Join(sorted, ", "))
+		b.WriteString("]\n")
+	case groups != nil:
+		// One comment header per group, groups and members both sorted, with a
+		// blank line between groups.
+		byGroup := make(map[string][]string)
+		for _, r := range resources {
+			byGroup[groups[r]] = append(byGroup[groups[r]], r)
+		}
+		labels := make([]string, 0, len(byGroup))
+		for l := range byGroup {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		b.WriteString("resources:\n")
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString("  # " + l + "\n")
+			members := byGroup[l]
+			sort.Strings(members)
+			for _, r := range members {
+				b.WriteString("  - " + r + "\n")
+			}
+		}
+	default:
+		b.WriteString("resources:\n")
+		for _, r := range resources {
+			b.WriteString("  - ")
+			b.WriteString(r)
+			b.WriteString("\n")
+		}
 	}
-	return writeFile(filepath.Join(outputDir, "kustomization.yaml"), b.String())
+	if opts.definitionFormat.mode == definitionConfigMap {
+		writeDefinitionReplacements(&b, resources, opts)
+	}
+	return b.String()
 }
 
-func deriveSchemaNameFromFilename(filename string) string {
-	// Example: coreapp.test.v1.TestEvent.pubsub.proto -> coreapp-test-v1-testevent
+var inputSuffixes = []string{".pubsub.proto", ".pubsub.yaml", ".pubsub.yml", ".pubsub.binpb"}
+
+// schemaBaseName is the unsanitized name part of a pubsub proto filename.
+func schemaBaseName(filename string) string {
 	base := filepath.Base(filename)
-	base = strings.TrimSuffix(base, ".pubsub.proto")
-	safe := strings.ToLower(base)
+	for _, suffix := range inputSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return strings.TrimSuffix(base, suffix)
+		}
+	}
+	return base
+}
+
+func sanitizeSchemaName(s string) string {
+	safe := strings.ToLower(s)
 	safe = strings.ReplaceAll(safe, ".", "-")
 	safe = strings.ReplaceAll(safe, "_", "-")
 	return safe
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCompactKustomizationParsesLikeBlock(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []string
+	}{
+		{"empty", nil},
+		{"one", []string{"a-v1-foo.schema.yaml"}},
+		{"several", []string{"a-v1-foo.schema.yaml", "b-v1-bar.schema.yaml", "c-v1-baz.schema.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			block := renderKustomization(tt.resources, nil, opts)
+			opts.compactKustomization = true
+			flow := renderKustomization(tt.resources, nil, opts)
+			var fromBlock, fromFlow map[string]interface{}
+			if err := yaml.Unmarshal([]byte(block), &fromBlock); err != nil {
+				t.Fatalf("block style: %v\n%s", err, block)
+			}
+			if err := yaml.Unmarshal([]byte(flow), &fromFlow); err != nil {
+				t.Fatalf("flow style: %v\n%s", err, flow)
+			}
+			if !reflect.DeepEqual(fromBlock, fromFlow) {
+				t.Errorf("flow style parses as %v, block style as %v", fromFlow, fromBlock)
+			}
+		})
+	}
+}
+
+func TestCompactKustomizationIsSortedFlowList(t *testing.T) {
+	opts := testOptions()
+	opts.compactKustomization = true
+	got := renderKustomization([]string{"b.schema.yaml", "a.schema.yaml"}, nil, opts)
+	want := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\n\nresources: [a.schema.yaml, b.schema.yaml]\n"
+	if got != want {
+		t.Errorf("renderKustomization = %q, want %q", got, want)
+	}
+}
+
+func TestKustomizationGroupByPackage(t *testing.T) {
+	inputs := map[string]string{
+		"b.v1.Zed.pubsub.proto":   "syntax = \"proto3\";\npackage b.v1;\nmessage Zed {}\n",
+		"b.v1.Alpha.pubsub.proto": "syntax = \"proto3\";\npackage b.v1;\nmessage Alpha {}\n",
+		// No package statement: the package comes from the filename.
+		"a.v2.Event.pubsub.proto": "syntax = \"proto3\";\nmessage Event {}\n",
+	}
+	out := generate(t, inputs, "--kustomization-group-by", "package")
+	want := `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+  # a.v2
+  - a-v2-event.schema.yaml
+
+  # b.v1
+  - b-v1-alpha.schema.yaml
+  - b-v1-zed.schema.yaml
+`
+	got := readFile(t, filepath.Join(out, "kustomization.yaml"))
+	if got != want {
+		t.Errorf("kustomization =\n%s\nwant\n%s", got, want)
+	}
+	var doc struct {
+		Resources []string `yaml:"resources"`
+	}
+	if err := yaml.Unmarshal([]byte(got), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a-v2-event.schema.yaml", "b-v1-alpha.schema.yaml", "b-v1-zed.schema.yaml"}; !reflect.DeepEqual(doc.Resources, want) {
+		t.Errorf("resources parse as %v, want %v", doc.Resources, want)
+	}
+}
+
+func TestEmptyKustomization(t *testing.T) {
+	existing := map[string]string{
+		"kustomization.yaml":   "resources:\n  - old.schema.yaml\n",
+		"old.schema.yaml":      generatedMarker + "\nkind: PubSubSchema\n",
+		"hand-written.yaml":    "kind: ConfigMap\n",
+		"notes/old.schema.yml": "unrelated\n",
+	}
+	emptyKustomization := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\n\nresources: []\n"
+	tests := []struct {
+		mode    string
+		wantErr bool
+		want    map[string]string
+	}{
+		{mode: emptySkip, want: existing},
+		{mode: emptyError, wantErr: true, want: existing},
+		{
+			mode: emptyWrite,
+			want: map[string]string{
+				"kustomization.yaml":   emptyKustomization,
+				"hand-written.yaml":    "kind: ConfigMap\n",
+				"notes/old.schema.yml": "unrelated\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			in, out := t.TempDir(), t.TempDir()
+			writeTree(t, out, existing)
+			_, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--empty-kustomization", tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := readTree(t, out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("output dir = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// staleSet writes n stale generated schemas into dir and returns their
+// names.
+func staleSet(t testing.TB, dir string, n int) []string {
+	t.Helper()
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("stale-%05d.schema.yaml", i)
+		if err := os.WriteFile(filepath.Join(dir, names[i]), []byte(generatedMarker+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return names
+}
+
+func TestParallelPruneMatchesSequential(t *testing.T) {
+	const stale = 2000
+	in := filepath.Join(t.TempDir(), "pubsub")
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	var trees []map[string]string
+	for _, args := range [][]string{nil, {"--parallel-prune"}, {"--parallel-prune", "--io-concurrency", "1"}} {
+		out := t.TempDir()
+		staleSet(t, out, stale)
+		writeTree(t, out, map[string]string{"keep.yaml": "kind: ConfigMap\n"})
+		if _, _, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", out}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		trees = append(trees, readTree(t, out))
+	}
+	for _, name := range []string{testEventSchema + ".schema.yaml", "keep.yaml", "kustomization.yaml"} {
+		if _, ok := trees[0][name]; !ok {
+			t.Errorf("sequential prune removed %s", name)
+		}
+	}
+	if len(trees[0]) != 3 {
+		t.Errorf("sequential prune left %d files, want 3", len(trees[0]))
+	}
+	for i, tree := range trees[1:] {
+		if !reflect.DeepEqual(tree, trees[0]) {
+			t.Errorf("parallel prune run %d left a different tree than the sequential one", i+1)
+		}
+	}
+}
+
+func TestRemoveGeneratedFilesFailures(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		t.Run(fmt.Sprintf("parallel=%v", parallel), func(t *testing.T) {
+			dir := t.TempDir()
+			names := staleSet(t, dir, 100)
+			// Deleting these fails; the rest must still be removed.
+			names = append([]string{"missing-a.schema.yaml"}, names...)
+			names = append(names, "missing-b.schema.yaml")
+			opts := testOptions()
+			opts.parallelPrune = parallel
+			err := removeGeneratedFiles(dir, names, opts)
+			if err == nil {
+				t.Fatal("removeGeneratedFiles succeeded with missing files")
+			}
+			want := fmt.Sprintf("remove %s: no such file or directory\nremove %s: no such file or directory",
+				filepath.Join(dir, "missing-a.schema.yaml"), filepath.Join(dir, "missing-b.schema.yaml"))
+			if err.Error() != want {
+				t.Errorf("error = %q, want %q", err, want)
+			}
+			if left := readTree(t, dir); len(left) != 0 {
+				t.Errorf("%d files left after a failed deletion", len(left))
+			}
+		})
+	}
+}
+
+func BenchmarkRemoveGeneratedFiles(b *testing.B) {
+	const stale = 1000
+	for _, parallel := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel=%v", parallel), func(b *testing.B) {
+			opts := testOptions()
+			opts.parallelPrune = parallel
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dir := b.TempDir()
+				names := staleSet(b, dir, stale)
+				b.StartTimer()
+				if err := removeGeneratedFiles(dir, names, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// decodeDocuments splits a multi-document YAML file into its documents.
+func decodeDocuments(t *testing.T, data string) []map[string]interface{} {
+	t.Helper()
+	var docs []map[string]interface{}
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	for {
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			return docs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func TestConfigMapDefinitionMode(t *testing.T) {
+	inputs := map[string]string{
+		testEventFile:             testEventProto,
+		"a.v1.Other.pubsub.proto": "syntax = \"proto3\";\nmessage Other {}\n",
+	}
+	inline := generate(t, inputs)
+	out := generate(t, inputs, "--definition-mode", "configmap")
+
+	var kustomization struct {
+		Resources    []string `yaml:"resources"`
+		Replacements []struct {
+			Source struct {
+				Kind      string `yaml:"kind"`
+				Name      string `yaml:"name"`
+				FieldPath string `yaml:"fieldPath"`
+			} `yaml:"source"`
+			Targets []struct {
+				Select struct {
+					Kind, Name string
+				} `yaml:"select"`
+				FieldPaths []string `yaml:"fieldPaths"`
+			} `yaml:"targets"`
+		} `yaml:"replacements"`
+	}
+	if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, "kustomization.yaml"))), &kustomization); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := kustomization.Resources, kustomizationResources(t, inline); !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want the inline run's %v", got, want)
+	}
+	if len(kustomization.Replacements) != len(kustomization.Resources) {
+		t.Fatalf("%d replacements for %d resources", len(kustomization.Replacements), len(kustomization.Resources))
+	}
+
+	// Applying the replacements by hand must give back the inline schemas.
+	for i, r := range kustomization.Resources {
+		docs := decodeDocuments(t, readFile(t, filepath.Join(out, r)))
+		if len(docs) != 2 {
+			t.Fatalf("%s has %d documents, want a ConfigMap and a schema", r, len(docs))
+		}
+		configMap, schema := docs[0], docs[1]
+		name := lookupPath(t, schema, "metadata", "name").(string)
+		if got, want := lookupPath(t, configMap, "metadata", "name"), definitionConfigMapName(name); got != want {
+			t.Errorf("%s: ConfigMap name = %v, want %s", r, got, want)
+		}
+		if got := lookupPath(t, configMap, "metadata", "annotations", "config.kubernetes.io/local-config"); got != "true" {
+			t.Errorf("%s: ConfigMap is not local-config", r)
+		}
+		if _, ok := lookupPath(t, schema, "spec").(map[string]interface{})["definition"]; ok {
+			t.Errorf("%s: schema carries the definition inline", r)
+		}
+		rep := kustomization.Replacements[i]
+		if rep.Source.Kind != "ConfigMap" || rep.Source.Name != definitionConfigMapName(name) || rep.Source.FieldPath != "data.definition" {
+			t.Errorf("%s: replacement source = %+v", r, rep.Source)
+		}
+		if len(rep.Targets) != 1 || rep.Targets[0].Select.Kind != "PubSubSchema" || rep.Targets[0].Select.Name != name ||
+			!reflect.DeepEqual(rep.Targets[0].FieldPaths, []string{"spec.definition"}) {
+			t.Fatalf("%s: replacement targets = %+v", r, rep.Targets)
+		}
+		schema["spec"].(map[string]interface{})["definition"] = lookupPath(t, configMap, "data", "definition")
+
+		var want map[string]interface{}
+		if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(inline, r))), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(schema, want) {
+			t.Errorf("%s after replacement = %v, want %v", r, schema, want)
+		}
+	}
+}
+
+func TestConfigMapDefinitionModePrunes(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+	writeTree(t, in, map[string]string{testEventFile: testEventProto, "a.v1.Gone.pubsub.proto": "syntax = \"proto3\";\nmessage Gone {}\n"})
+	args := []string{"--pubsub-dir", in, "--output-dir", out, "--definition-mode", "configmap"}
+	if _, _, err := runTool(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(in, "a.v1.Gone.pubsub.proto")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := runTool(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := generatedFiles(t, out), []string{testEventSchema + ".schema.yaml", "kustomization.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("generated files = %v, want %v", got, want)
+	}
+	if data := readFile(t, filepath.Join(out, "kustomization.yaml")); strings.Contains(data, "a-v1-gone") {
+		t.Errorf("kustomization still references the removed schema:\n%s", data)
+	}
+}
+
+func TestWriteKustomizationListsOnlyWrittenFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		onDisk  map[string]string
+		written []string
+		wantErr string
+	}{
+		{
+			name:    "all written",
+			onDisk:  map[string]string{"b.schema.yaml": "b\n", "a.schema.yaml": "a\n"},
+			written: []string{"b.schema.yaml", "a.schema.yaml"},
+		},
+		{
+			name:    "failed write",
+			onDisk:  map[string]string{"a.schema.yaml": "a\n"},
+			written: []string{"a.schema.yaml", "b.schema.yaml"},
+			wantErr: "b.schema.yaml: no such file or directory",
+		},
+		{
+			name:    "directory",
+			onDisk:  map[string]string{"a.schema.yaml": "a\n", "b.schema.yaml/x": "x\n"},
+			written: []string{"a.schema.yaml", "b.schema.yaml"},
+			wantErr: "b.schema.yaml: not a regular file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, tt.onDisk)
+			err := writeKustomization(dir, tt.written, nil, testOptions())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				if _, ok := readTree(t, dir)["kustomization.yaml"]; ok {
+					t.Error("kustomization written despite the missing resource")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kustomizationResources(t, dir), []string{"a.schema.yaml", "b.schema.yaml"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("resources = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteIndexRejectsUnwrittenPlannedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.schema.yaml": "a\n"})
+	p := &plan{outputDir: dir, schemas: []plannedFile{{name: "a.schema.yaml"}, {name: "b.schema.yaml"}}, written: []string{"a.schema.yaml"}}
+	err := p.writeIndex(testOptions())
+	if want := filepath.Join(dir, "b.schema.yaml") + " was planned but not written"; err == nil || err.Error() != want {
+		t.Errorf("writeIndex = %v, want %q", err, want)
+	}
+	if _, ok := readTree(t, dir)["kustomization.yaml"]; ok {
+		t.Error("kustomization written despite the failed write")
+	}
+}
+
+func TestPubsubDirEnv(t *testing.T) {
+	envDir, flagDir := filepath.Join(t.TempDir(), "env"), filepath.Join(t.TempDir(), "flag")
+	writeTree(t, envDir, map[string]string{"a.v1.FromEnv.pubsub.proto": "syntax = \"proto3\";\nmessage FromEnv {}\n"})
+	writeTree(t, flagDir, map[string]string{"a.v1.FromFlag.pubsub.proto": "syntax = \"proto3\";\nmessage FromFlag {}\n"})
+	notADir := filepath.Join(t.TempDir(), "file")
+	writeTree(t, filepath.Dir(notADir), map[string]string{"file": ""})
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "env used without the flag", env: envDir, want: []string{"a-v1-fromenv.schema.yaml"}},
+		{name: "flag wins over env", env: envDir, args: []string{"--pubsub-dir", flagDir}, want: []string{"a-v1-fromflag.schema.yaml"}},
+		{name: "invalid env ignored with the flag", env: filepath.Join(envDir, "missing"), args: []string{"--pubsub-dir", flagDir}, want: []string{"a-v1-fromflag.schema.yaml"}},
+		{name: "missing env dir", env: filepath.Join(envDir, "missing"), wantErr: "$" + pubsubDirEnv + ": stat " + filepath.Join(envDir, "missing")},
+		{name: "env not a directory", env: notADir, wantErr: "$" + pubsubDirEnv + ": " + notADir + " is not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(pubsubDirEnv, tt.env)
+			out := t.TempDir()
+			_, _, err := runTool(t, append([]string{"--output-dir", out}, tt.args...)...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := kustomizationResources(t, out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resources = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipleOutputDirs(t *testing.T) {
+	inputs := map[string]string{testEventFile: testEventProto, "a.v1.Other.pubsub.proto": "syntax = \"proto3\";\nmessage Other {}\n"}
+	in := filepath.Join(t.TempDir(), "pubsub")
+	writeTree(t, in, inputs)
+	first, second := t.TempDir(), t.TempDir()
+	// Each directory has its own stale and hand-written files.
+	writeTree(t, first, map[string]string{"stale-a.schema.yaml": generatedMarker + "\n", "first.yaml": "kind: ConfigMap\n"})
+	writeTree(t, second, map[string]string{"stale-b.schema.yaml": generatedMarker + "\n", "stale-b.topic.yaml": generatedMarker + "\n"})
+	tests := []struct {
+		args []string
+		// extra are the files besides the generated set left in each
+		// directory; topics are only pruned with --emit-topics.
+		extraFirst, extraSecond map[string]string
+	}{
+		{nil, map[string]string{"first.yaml": "kind: ConfigMap\n"}, map[string]string{"stale-b.topic.yaml": generatedMarker + "\n"}},
+		{[]string{"--emit-topics"}, map[string]string{"first.yaml": "kind: ConfigMap\n"}, nil},
+	}
+	for _, tt := range tests {
+		if _, _, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", first, "--output-dir", second}, tt.args...)...); err != nil {
+			t.Fatal(err)
+		}
+		for dir, extra := range map[string]map[string]string{first: tt.extraFirst, second: tt.extraSecond} {
+			want := readTree(t, generate(t, inputs, tt.args...))
+			for name, contents := range extra {
+				want[name] = contents
+			}
+			if got := readTree(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: %s = %v, want the single-directory output plus %v", tt.args, dir, generatedFiles(t, dir), extra)
+			}
+		}
+	}
+}
+
+func TestOutputDirOverlappingInputsIsRejected(t *testing.T) {
+	root := t.TempDir()
+	in, protos := filepath.Join(root, "pubsub"), filepath.Join(root, "protos")
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	writeTree(t, protos, map[string]string{"a/v1/a.proto": "syntax = \"proto3\";\n"})
+	tests := []struct {
+		name    string
+		out     string
+		args    []string
+		wantErr string
+	}{
+		{name: "sibling", out: filepath.Join(root, "out")},
+		{name: "prefix sibling", out: in + "-out"},
+		{name: "pubsub dir", out: in, wantErr: "overlaps input directory " + in},
+		{name: "inside pubsub dir", out: filepath.Join(in, "out"), wantErr: "overlaps input directory " + in},
+		{name: "contains pubsub dir", out: root, wantErr: "overlaps input directory " + in},
+		{name: "relative spelling", out: in + "/./", wantErr: "overlaps input directory " + in},
+		{name: "inside proto root", out: filepath.Join(protos, "out"), args: []string{"--proto-root", protos}, wantErr: "overlaps input directory " + protos},
+		{name: "mirror dir", out: filepath.Join(root, "out"), args: []string{"--output-dir", filepath.Join(in, "mirror")}, wantErr: "overlaps input directory " + in},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", tt.out}, tt.args...)...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+			if got := readTree(t, in); len(got) != 1 || got[testEventFile] != testEventProto {
+				t.Errorf("inputs changed: %v", generatedFiles(t, in))
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// nameTemplateData is what a --name-template is executed against. Everything
+// comes from the input's path, so templates can be tried on paths that do
+// not exist yet.
+type nameTemplateData struct {
+	Path string
+	Rel  string
+	// Base is the filename without its input suffix, e.g.
+	// coreapp.config.v1.ConfigEvent; Parts is Base split on dots, Package
+	// all parts but the last and Message the last.
+	Base    string
+	Parts   []string
+	Package string
+	Message string
+	// Dir is the input's parent directory relative to --pubsub-dir, or empty
+	// for files directly in it.
+	Dir string
+}
+
+var nameTemplateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"join":    strings.Join,
+	"replace": strings.ReplaceAll,
+}
+
+func parseNameTemplate(text string) (*template.Template, error) {
+	return template.New("name").Funcs(nameTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderNameTemplate executes t for in. The result replaces the filename base
+// in schema name derivation and is sanitized like it.
+func renderNameTemplate(t *template.Template, in *schemaInput) (string, error) {
+	base := schemaBaseName(in.path)
+	parts := strings.Split(base, ".")
+	data := nameTemplateData{
+		Path:    in.path,
+		Rel:     in.rel,
+		Base:    base,
+		Parts:   parts,
+		Package: strings.Join(parts[:len(parts)-1], "."),
+		Message: parts[len(parts)-1],
+	}
+	if dir := path.Dir(in.rel); dir != "." {
+		data.Dir = dir
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%s: --name-template: %w", in.path, err)
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" {
+		return "", fmt.Errorf("%s: --name-template rendered an empty name", in.path)
+	}
+	return name, nil
+}
+
+// runNameTemplate implements the name-template subcommand: it renders a
+// template for sample paths and reports the resulting names, the invalid
+// ones and collisions, without reading or writing any files.
+func runNameTemplate(argv []string, w io.Writer) error {
+	fs := flag.NewFlagSet("pubsubschema-gen name-template", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	pubsubDir := fs.String("pubsub-dir", ".", "Directory the sample paths are relative to, as for a full run.")
+	globPattern := fs.String("glob", "", "Also take the samples from this glob within --pubsub-dir.")
+	if err := fs.Parse(argv); err != nil {
+		return err
+	}
+	templateUsage := func(msg string) error {
+		return fmt.Errorf("%s\n\nUsage:\n  pubsubschema-gen name-template [--pubsub-dir DIR] [--glob GLOB] TEMPLATE [PATH...]", msg)
+	}
+	if fs.NArg() == 0 {
+		return templateUsage("missing template")
+	}
+	t, err := parseNameTemplate(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	samples := fs.Args()[1:]
+	if *globPattern != "" {
+		matches, err := filepath.Glob(filepath.Join(*pubsubDir, *globPattern))
+		if err != nil {
+			return fmt.Errorf("--glob: %w", err)
+		}
+		samples = append(samples, matches...)
+	}
+	if len(samples) == 0 {
+		return templateUsage("no sample paths given")
+	}
+	opts := options{pubsubDir: *pubsubDir, nameTemplate: t, nameCollision: collisionError}
+	owner := make(map[string]string)
+	problems := 0
+	for _, s := range samples {
+		in := &schemaInput{path: s, rel: relativeInputPath(*pubsubDir, s)}
+		name, err := deriveSchemaName(in, opts)
+		if err != nil {
+			fmt.Fprintf(w, "%s: invalid: %s\n", in.rel, strings.TrimPrefix(err.Error(), s+": "))
+			problems++
+			continue
+		}
+		if first, ok := owner[name]; ok {
+			fmt.Fprintf(w, "%s -> %s (collides with %s)\n", in.rel, name, first)
+			problems++
+			continue
+		}
+		owner[name] = in.rel
+		fmt.Fprintf(w, "%s -> %s\n", in.rel, name)
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d of %d samples are invalid or collide", problems, len(samples))
+	}
+	return nil
+}
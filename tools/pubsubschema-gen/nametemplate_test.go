@@ -0,0 +1,143 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderNameTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		rel     string
+		want    string
+		wantErr string
+	}{
+		{tmpl: "{{.Base}}", rel: "coreapp.config.v1.ConfigEvent.pubsub.proto", want: "coreapp.config.v1.ConfigEvent"},
+		{tmpl: "{{.Message}}", rel: "coreapp.config.v1.ConfigEvent.pubsub.proto", want: "ConfigEvent"},
+		{tmpl: "{{.Package}}", rel: "coreapp.config.v1.ConfigEvent.pubsub.proto", want: "coreapp.config.v1"},
+		{tmpl: "{{index .Parts 1}}-{{.Message}}", rel: "coreapp.config.v1.ConfigEvent.pubsub.proto", want: "config-ConfigEvent"},
+		{tmpl: "{{.Dir}}/{{.Message}}", rel: "team/a.v1.E.pubsub.proto", want: "team/E"},
+		{tmpl: "[{{.Dir}}]{{.Message}}", rel: "a.v1.E.pubsub.proto", want: "[]E"},
+		{tmpl: "{{.Rel}}", rel: "team/a.v1.E.pubsub.proto", want: "team/a.v1.E.pubsub.proto"},
+		{tmpl: "{{upper .Message}}{{lower .Package}}", rel: "A.V1.E.pubsub.proto", want: "Ea.v1"},
+		{tmpl: `{{join .Parts "_"}}`, rel: "a.v1.E.pubsub.yaml", want: "a_v1_E"},
+		{tmpl: `{{replace .Package "." ""}}`, rel: "a.v1.E.pubsub.proto", want: "av1"},
+		{tmpl: "  {{.Message}}\n", rel: "a.v1.E.pubsub.proto", want: "E"},
+		{tmpl: "{{.Package}}", rel: "Event.pubsub.proto", wantErr: "a.pubsub/Event.pubsub.proto: --name-template rendered an empty name"},
+		{tmpl: "{{index .Parts 9}}", rel: "a.v1.E.pubsub.proto", wantErr: "a.pubsub/a.v1.E.pubsub.proto: --name-template: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl+" "+tt.rel, func(t *testing.T) {
+			tmpl, err := parseNameTemplate(tt.tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := renderNameTemplate(tmpl, &schemaInput{path: "a.pubsub/" + tt.rel, rel: tt.rel})
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("renderNameTemplate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameTemplateSubcommand(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.v1.Orders.pubsub.proto": "",
+		"b.v2.Orders.pubsub.proto": "",
+		"c.v1.Refund.pubsub.proto": "",
+	})
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			args: []string{"{{.Message}}-{{index .Parts 0}}", "a.v1.Orders.pubsub.proto", "team/c.v1.Refund.pubsub.proto"},
+			want: "a.v1.Orders.pubsub.proto -> orders-a\nteam/c.v1.Refund.pubsub.proto -> refund-c\n",
+		},
+		{
+			name: "glob",
+			args: []string{"--pubsub-dir", dir, "--glob", "*.pubsub.proto", "{{.Package}}-{{.Message}}"},
+			want: "a.v1.Orders.pubsub.proto -> a-v1-orders\nb.v2.Orders.pubsub.proto -> b-v2-orders\nc.v1.Refund.pubsub.proto -> c-v1-refund\n",
+		},
+		{
+			name:    "collision",
+			args:    []string{"--pubsub-dir", dir, "--glob", "*.pubsub.proto", "{{.Message}}"},
+			want:    "a.v1.Orders.pubsub.proto -> orders\nb.v2.Orders.pubsub.proto -> orders (collides with a.v1.Orders.pubsub.proto)\nc.v1.Refund.pubsub.proto -> refund\n",
+			wantErr: "1 of 3 samples are invalid or collide",
+		},
+		{
+			name:    "invalid name",
+			args:    []string{"{{.Message}}", "a.v1.Orders.pubsub.proto", "a.v1.Go.pubsub.proto"},
+			want:    "a.v1.Orders.pubsub.proto -> orders\na.v1.Go.pubsub.proto: invalid: schema name \"go\" must be 3 to 253 characters\n",
+			wantErr: "1 of 2 samples are invalid or collide",
+		},
+		{
+			name:    "reserved prefix",
+			args:    []string{"goog-{{.Message}}", "a.v1.Orders.pubsub.proto"},
+			want:    "a.v1.Orders.pubsub.proto: invalid: schema name \"goog-orders\" must not start with \"goog\"\n",
+			wantErr: "1 of 1 samples are invalid or collide",
+		},
+		{
+			name:    "execution error",
+			args:    []string{"{{index .Parts 5}}", "a.v1.Orders.pubsub.proto"},
+			wantErr: "1 of 1 samples are invalid or collide",
+		},
+		{name: "invalid template", args: []string{"{{.Message", "a.v1.Orders.pubsub.proto"}, wantErr: "invalid template: "},
+		{name: "unknown field", args: []string{"{{.Nope}}", "a.v1.Orders.pubsub.proto"}, wantErr: "1 of 1 samples are invalid or collide"},
+		{name: "missing template", wantErr: "missing template\n\nUsage:\n  pubsubschema-gen name-template"},
+		{name: "no samples", args: []string{"{{.Message}}"}, wantErr: "no sample paths given"},
+		{name: "empty glob", args: []string{"--pubsub-dir", dir, "--glob", "*.none", "{{.Message}}"}, wantErr: "no sample paths given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := runNameTemplate(tt.args, &out)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to start with %q", err, tt.wantErr)
+			}
+			if tt.want != "" && out.String() != tt.want {
+				t.Errorf("output =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNameTemplateSubcommandRun(t *testing.T) {
+	stdout, _, err := runTool(t, "name-template", "{{.Message}}", "a.v1.Orders.pubsub.proto")
+	if err != nil || stdout != "a.v1.Orders.pubsub.proto -> orders\n" {
+		t.Errorf("name-template = %q, %v", stdout, err)
+	}
+}
+
+func TestNameTemplateFlag(t *testing.T) {
+	out := generate(t, map[string]string{testEventFile: testEventProto}, "--name-template", "{{.Message}}-{{index .Parts 0}}")
+	if got, want := kustomizationResources(t, out), []string{"testevent-coreapp.schema.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+	err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--name-template", "{{.Message")
+	if !strings.Contains(err.Error(), "invalid --name-template: ") {
+		t.Errorf("error = %v", err)
+	}
+	err = generateErr(t, map[string]string{testEventFile: testEventProto}, "--name-template", "{{index .Parts 9}}")
+	if !strings.Contains(err.Error(), filepath.Join("pubsub", testEventFile)+": --name-template: ") {
+		t.Errorf("error = %v", err)
+	}
+}
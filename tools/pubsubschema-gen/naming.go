@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// maxSchemaNameLength is the Kubernetes object name limit; Pub/Sub allows 255.
+const maxSchemaNameLength = 253
+
+// minSchemaNameLength is the Pub/Sub schema ID minimum.
+const minSchemaNameLength = 3
+
+// deriveSchemaName computes the schema name for in. The filename base is
+// optionally prefixed with the parent directory (files directly in
+// --pubsub-dir get none), wrapped in --name-prefix/--name-suffix, and only
+// then sanitized, truncated and validated, so the affixes are held to the
+// same rules as the rest of the name. Generated topic and subscription names
+// build on the result.
+func deriveSchemaName(in *schemaInput, opts options) (string, error) {
+	steps, err := schemaNameSteps(in, opts)
+	if err != nil {
+		return "", err
+	}
+	name := steps[len(steps)-1].value
+	if err := validateSchemaName(name); err != nil {
+		return "", fmt.Errorf("%s: %w", in.path, err)
+	}
+	return name, nil
+}
+
+// nameStep is one stage of schema name derivation, for --explain-names.
+type nameStep struct {
+	stage, value string
+}
+
+// schemaNameSteps runs the deriveSchemaName pipeline and returns the name
+// after every stage; the last value is the name before validation. A
+// --name-template replaces the filename base, and with --name-command the
+// command's output replaces the affixed name.
+func schemaNameSteps(in *schemaInput, opts options) ([]nameStep, error) {
+	// Example: coreapp.test.v1.TestEvent.pubsub.proto -> coreapp-test-v1-testevent
+	base := schemaBaseName(in.path)
+	steps := []nameStep{{"base", base}}
+	if opts.nameTemplate != nil {
+		var err error
+		if base, err = renderNameTemplate(opts.nameTemplate, in); err != nil {
+			return nil, err
+		}
+		steps = append(steps, nameStep{"template", base})
+	}
+	if opts.prefixFromDir {
+		if dir := path.Dir(in.rel); dir != "." {
+			base = path.Base(dir) + "-" + base
+		}
+		steps = append(steps, nameStep{"dir prefix", base})
+	}
+	affixed := opts.namePrefix + base + opts.nameSuffix
+	steps = append(steps, nameStep{"prefix/suffix", affixed})
+	if opts.nameCommand != "" {
+		var err error
+		if affixed, err = runNameCommand(in, affixed, opts); err != nil {
+			return nil, err
+		}
+		steps = append(steps, nameStep{"name command", affixed})
+	}
+	sanitized := sanitizeSchemaName(affixed)
+	return append(steps,
+		nameStep{"sanitized", sanitized},
+		nameStep{"truncated", truncateSchemaName(sanitized, maxSchemaNameLength)},
+	), nil
+}
+
+// nameCommandInput is the JSON a --name-command receives on stdin.
+type nameCommandInput struct {
+	Path string `json:"path"`
+	Rel  string `json:"rel"`
+	Base string `json:"base"`
+	// Default is the name the built-in derivation would use, before
+	// sanitization.
+	Default string `json:"default"`
+}
+
+// commandRunner runs an executable with stdin and returns its stdout. It is
+// swapped out where the real process isn't wanted.
+type commandRunner func(command string, stdin []byte) ([]byte, error)
+
+func execCommand(command string, stdin []byte) ([]byte, error) {
+	cmd := exec.Command(command)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// runNameCommand asks --name-command for in's name. A non-zero exit or empty
+// output aborts the run.
+func runNameCommand(in *schemaInput, defaultName string, opts options) (string, error) {
+	stdin, err := json.Marshal(nameCommandInput{Path: in.path, Rel: in.rel, Base: schemaBaseName(in.path), Default: defaultName})
+	if err != nil {
+		return "", err
+	}
+	run := opts.runCommand
+	if run == nil {
+		run = execCommand
+	}
+	out, err := run(opts.nameCommand, stdin)
+	if err != nil {
+		return "", fmt.Errorf("%s: --name-command %s: %w", in.path, opts.nameCommand, err)
+	}
+	name := strings.TrimSpace(string(out))
+	if name == "" {
+		return "", fmt.Errorf("%s: --name-command %s printed no name", in.path, opts.nameCommand)
+	}
+	return name, nil
+}
+
+// printNameDerivations prints the name derivation of every input, then the name it
+// ends up with once collisions are resolved.
+func printNameDerivations(w io.Writer, pubsubFiles []string, opts options) error {
+	var inputs []*schemaInput
+	for _, f := range pubsubFiles {
+		in := &schemaInput{path: f, rel: relativeInputPath(opts.pubsubDir, f)}
+		fmt.Fprintln(w, in.rel)
+		steps, err := schemaNameSteps(in, opts)
+		if err != nil {
+			return err
+		}
+		for _, st := range steps {
+			fmt.Fprintf(w, "  %-14s %s\n", st.stage+":", st.value)
+			in.name = st.value
+		}
+		if err := validateSchemaName(in.name); err != nil {
+			fmt.Fprintf(w, "  %-14s %v\n", "invalid:", err)
+			continue
+		}
+		inputs = append(inputs, in)
+	}
+	opts.rules = nil
+	kept, err := resolveNameCollisions(inputs, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	for _, in := range kept {
+		fmt.Fprintf(w, "%s -> %s\n", in.rel, in.name)
+	}
+	return nil
+}
+
+// truncateSchemaName cuts name to at most max bytes without leaving a
+// trailing dash.
+func truncateSchemaName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	return strings.TrimRight(name[:max], "-")
+}
+
+// validateSchemaName enforces the Pub/Sub schema ID rules intersected with
+// what sanitization can produce: a leading letter, lowercase letters, digits
+// and dashes, no trailing dash, minSchemaNameLength to maxSchemaNameLength
+// characters, and no reserved "goog" prefix.
+func validateSchemaName(name string) error {
+	if len(name) < minSchemaNameLength || len(name) > maxSchemaNameLength {
+		return fmt.Errorf("schema name %q must be %d to %d characters", name, minSchemaNameLength, maxSchemaNameLength)
+	}
+	if strings.HasPrefix(name, "goog") {
+		return fmt.Errorf("schema name %q must not start with \"goog\"", name)
+	}
+	if name[0] < 'a' || name[0] > 'z' {
+		return fmt.Errorf("schema name %q must start with a letter", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+			return fmt.Errorf("schema name %q contains invalid character %q", name, c)
+		}
+	}
+	if strings.HasSuffix(name, "-") {
+		return fmt.Errorf("schema name %q must not end with a dash", name)
+	}
+	return nil
+}
+
+const (
+	collisionError  = "error"
+	collisionSuffix = "suffix"
+	collisionSkip   = "skip"
+)
+
+// minSafeHashSuffixLength is the shortest --hash-suffix-length used without a
+// warning: below 16^6 distinct suffixes, two colliding inputs getting the same
+// suffix stops being negligible in large trees.
+const minSafeHashSuffixLength = 6
+
+// validateHashSuffixLength checks n against the --hash-algo digest and the
+// name budget, which must leave room for the dash and a minimal name.
+func validateHashSuffixLength(n int, algo string) error {
+	digest := len(hashHex(algo, nil))
+	switch {
+	case n < 1:
+		return fmt.Errorf("must be at least 1")
+	case n > digest:
+		return fmt.Errorf("%s digests have only %d hex characters", algo, digest)
+	case n+1+minSchemaNameLength > maxSchemaNameLength:
+		return fmt.Errorf("leaves no room for the name within %d characters", maxSchemaNameLength)
+	}
+	return nil
+}
+
+// warnLongNames warns about every final schema name longer than limit, a
+// soft signal well before truncation at maxSchemaNameLength. Zero disables
+// it.
+func warnLongNames(inputs []*schemaInput, limit int) {
+	if limit <= 0 {
+		return
+	}
+	for _, in := range inputs {
+		if len(in.name) > limit {
+			fmt.Fprintf(os.Stderr, "warning: %s: schema name %q is %d characters, over --name-length-warn=%d\n", in.path, in.name, len(in.name), limit)
+		}
+	}
+}
+
+// resolveNameCollisions applies the --name-collision strategy to inputs that
+// derived the same schema name. Inputs arrive sorted by path, so the first one
+// always keeps the plain name and the outcome is deterministic.
+func resolveNameCollisions(inputs []*schemaInput, opts options) ([]*schemaInput, error) {
+	rules := opts.rules
+	owner := make(map[string]*schemaInput, len(inputs))
+	var kept []*schemaInput
+	for _, in := range inputs {
+		first, clash := owner[in.name]
+		if !clash {
+			rules.record(ruleNameCollision, nil)
+			owner[in.name] = in
+			kept = append(kept, in)
+			continue
+		}
+		switch opts.nameCollision {
+		case collisionSkip:
+			rules.resolve(ruleNameCollision)
+			fmt.Fprintf(os.Stderr, "warning: skipping %s: schema name %q is already used by %s\n", in.path, in.name, first.path)
+			continue
+		case collisionSuffix:
+			// The suffix hashes the input's relative path so it stays stable as
+			// unrelated files are added or removed.
+			n := opts.hashSuffixLength
+			in.name = truncateSchemaName(in.name, maxSchemaNameLength-n-1) + "-" + hashHex(opts.hashAlgo, []byte(in.rel))[:n]
+			if other, ok := owner[in.name]; ok {
+				return nil, rules.record(ruleNameCollision, fmt.Errorf("schema name %q derived from both %s and %s", in.name, other.path, in.path))
+			}
+			rules.resolve(ruleNameCollision)
+			owner[in.name] = in
+			kept = append(kept, in)
+		default:
+			return nil, rules.record(ruleNameCollision, fmt.Errorf("schema name %q derived from both %s and %s", in.name, first.path, in.path))
+		}
+	}
+	return kept, nil
+}
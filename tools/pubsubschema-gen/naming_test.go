@@ -0,0 +1,540 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// collidingInputs derive the same schema name, a-v1-foo.
+var collidingInputs = map[string]string{
+	"a.v1.Foo.pubsub.proto": "syntax = \"proto3\";\nmessage Foo {\n  string id = 1;\n}\n",
+	"a_v1_Foo.pubsub.proto": "syntax = \"proto3\";\nmessage Foo {\n  string key = 1;\n}\n",
+}
+
+// kustomizationResources returns the resources listed by the kustomization
+// in dir.
+func kustomizationResources(t *testing.T, dir string) []string {
+	t.Helper()
+	var resources []string
+	for _, line := range strings.Split(readFile(t, filepath.Join(dir, "kustomization.yaml")), "\n") {
+		if strings.HasPrefix(line, "  - ") {
+			resources = append(resources, strings.TrimPrefix(line, "  - "))
+		}
+	}
+	return resources
+}
+
+// generatedFiles returns the sorted names of the files in dir.
+func generatedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	for name := range readTree(t, dir) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestNameCollisionStrategies(t *testing.T) {
+	suffixed := "a-v1-foo-" + hashHex("sha256", []byte("a_v1_Foo.pubsub.proto"))[:8] + ".schema.yaml"
+	tests := []struct {
+		strategy string
+		wantErr  bool
+		want     []string
+	}{
+		{strategy: collisionError, wantErr: true},
+		{strategy: collisionSkip, want: []string{"a-v1-foo.schema.yaml"}},
+		{strategy: collisionSuffix, want: []string{suffixed, "a-v1-foo.schema.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			if tt.wantErr {
+				err := generateErr(t, collidingInputs, "--name-collision", tt.strategy)
+				if !strings.Contains(err.Error(), `schema name "a-v1-foo" derived from both`) {
+					t.Errorf("error = %v", err)
+				}
+				return
+			}
+			out := generate(t, collidingInputs, "--name-collision", tt.strategy)
+			if got := kustomizationResources(t, out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kustomization resources = %v, want %v", got, tt.want)
+			}
+			if got, want := generatedFiles(t, out), append(append([]string(nil), tt.want...), "kustomization.yaml"); !reflect.DeepEqual(got, want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNameCollisionSuffixIsStable(t *testing.T) {
+	// Adding an unrelated input must not change the suffix of existing ones.
+	inputs := map[string]string{"Aa.v1.Early.pubsub.proto": "syntax = \"proto3\";\nmessage Early {}\n"}
+	for k, v := range collidingInputs {
+		inputs[k] = v
+	}
+	first := generatedFiles(t, generate(t, collidingInputs, "--name-collision", collisionSuffix))
+	second := generatedFiles(t, generate(t, inputs, "--name-collision", collisionSuffix))
+	for _, name := range first {
+		found := false
+		for _, other := range second {
+			found = found || other == name
+		}
+		if !found {
+			t.Errorf("%s is no longer generated after adding an input: %v", name, second)
+		}
+	}
+}
+
+func TestValidateHashSuffixLength(t *testing.T) {
+	tests := []struct {
+		n       int
+		algo    string
+		wantErr string
+	}{
+		{n: 1, algo: "sha256"},
+		{n: 8, algo: "sha256"},
+		{n: 64, algo: "sha256"},
+		{n: 65, algo: "sha256", wantErr: "sha256 digests have only 64 hex characters"},
+		{n: 128, algo: "sha512"},
+		{n: 128, algo: "blake2b"},
+		{n: 129, algo: "blake2b", wantErr: "blake2b digests have only 128 hex characters"},
+		{n: 0, algo: "sha256", wantErr: "must be at least 1"},
+		{n: -1, algo: "sha256", wantErr: "must be at least 1"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.algo, tt.n), func(t *testing.T) {
+			err := validateHashSuffixLength(tt.n, tt.algo)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHashSuffixLength(t *testing.T) {
+	sum := hashHex("sha256", []byte("a_v1_Foo.pubsub.proto"))
+	tests := []struct {
+		length   string
+		args     []string
+		wantName string
+		wantWarn bool
+		wantErr  string
+	}{
+		{length: "8", wantName: "a-v1-foo-" + sum[:8]},
+		{length: "6", wantName: "a-v1-foo-" + sum[:6]},
+		{length: "64", wantName: "a-v1-foo-" + sum},
+		{length: "5", wantName: "a-v1-foo-" + sum[:5], wantWarn: true},
+		{length: "1", wantName: "a-v1-foo-" + sum[:1], wantWarn: true},
+		{length: "128", args: []string{"--hash-algo", "sha512"}, wantName: "a-v1-foo-" + hashHex("sha512", []byte("a_v1_Foo.pubsub.proto"))},
+		{length: "0", wantErr: "invalid --hash-suffix-length 0: must be at least 1"},
+		{length: "65", wantErr: "invalid --hash-suffix-length 65: sha256 digests have only 64 hex characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.length, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			writeTree(t, in, collidingInputs)
+			args := append([]string{"--pubsub-dir", in, "--output-dir", out, "--name-collision", collisionSuffix, "--hash-suffix-length", tt.length}, tt.args...)
+			_, stderr, err := runTool(t, args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			want := []string{tt.wantName + ".schema.yaml", "a-v1-foo.schema.yaml"}
+			if got := kustomizationResources(t, out); !reflect.DeepEqual(got, want) {
+				t.Errorf("resources = %v, want %v", got, want)
+			}
+			if warned := strings.Contains(stderr, "warning: --hash-suffix-length "+tt.length+" allows only"); warned != tt.wantWarn {
+				t.Errorf("collision warning = %v, want %v; stderr:\n%s", warned, tt.wantWarn, stderr)
+			}
+		})
+	}
+}
+
+func TestHashSuffixLengthWarnsOnlyForSuffixes(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	_, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--hash-suffix-length", "2")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	if strings.Contains(stderr, "warning") {
+		t.Errorf("warned without --name-collision=suffix:\n%s", stderr)
+	}
+}
+
+func TestHashSuffixKeepsNameWithinLimit(t *testing.T) {
+	// A name at the length limit is truncated to leave room for the suffix.
+	long := strings.Repeat("a", maxSchemaNameLength)
+	sum := hashHex("sha256", []byte("b.pubsub.proto"))
+	for _, n := range []int{1, 8, 64} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			opts := testOptions()
+			opts.nameCollision, opts.hashSuffixLength = collisionSuffix, n
+			kept, err := resolveNameCollisions([]*schemaInput{
+				{path: "a.pubsub.proto", rel: "a.pubsub.proto", name: long},
+				{path: "b.pubsub.proto", rel: "b.pubsub.proto", name: long},
+			}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := long[:maxSchemaNameLength-n-1] + "-" + sum[:n]
+			if got := kept[1].name; got != want {
+				t.Errorf("suffixed name = %q, want %q", got, want)
+			}
+			if err := validateSchemaName(kept[1].name); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPrefixFromDir(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"a.v1.Foo.pubsub.proto", "a-v1-foo"},
+		{"billing/a.v1.Foo.pubsub.proto", "billing-a-v1-foo"},
+		{"Order_Service/a.v1.Foo.pubsub.proto", "order-service-a-v1-foo"},
+		// Only the immediate parent counts.
+		{"team/ledger/a.v1.Foo.pubsub.proto", "ledger-a-v1-foo"},
+	}
+	opts := testOptions()
+	opts.prefixFromDir = true
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			in := &schemaInput{path: filepath.Join("pubsub", filepath.FromSlash(tt.rel)), rel: tt.rel}
+			got, err := deriveSchemaName(in, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("deriveSchemaName = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixFromDirSeparatesServices(t *testing.T) {
+	src := "syntax = \"proto3\";\nmessage Foo {}\n"
+	out := generate(t, map[string]string{"billing/a.v1.Foo.pubsub.proto": src, "ledger/a.v1.Foo.pubsub.proto": src}, "--prefix-from-dir", "--glob", "*/*.pubsub.proto")
+	if got, want := kustomizationResources(t, out), []string{"billing-a-v1-foo.schema.yaml", "ledger-a-v1-foo.schema.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+}
+
+func TestNamePrefixAndSuffix(t *testing.T) {
+	long := strings.Repeat("x", 250)
+	tests := []struct {
+		name           string
+		prefix, suffix string
+		want           string
+		wantErr        string
+	}{
+		{name: "prefix", prefix: "staging-", want: "staging-" + testEventSchema},
+		{name: "suffix", suffix: "-v2", want: testEventSchema + "-v2"},
+		{name: "both", prefix: "staging-", suffix: "-v2", want: "staging-" + testEventSchema + "-v2"},
+		{name: "sanitized", prefix: "Staging_", suffix: ".Blue", want: "staging-" + testEventSchema + "-blue"},
+		{name: "truncated as one unit", prefix: long, want: truncateSchemaName(long+testEventSchema, maxSchemaNameLength)},
+		{name: "validated", prefix: "9-", wantErr: "must start with a letter"},
+		{name: "reserved prefix", prefix: "goog-", wantErr: `must not start with "goog"`},
+		{name: "invalid character", suffix: "+x", wantErr: "invalid character '+'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.namePrefix, opts.nameSuffix = tt.prefix, tt.suffix
+			got, err := deriveSchemaName(&schemaInput{path: testEventFile, rel: testEventFile}, opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("deriveSchemaName = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamePrefixFlowsIntoTopicsAndSubscriptions(t *testing.T) {
+	src := "// +consumers: audit\n" + testEventProto
+	out := generate(t, map[string]string{testEventFile: src}, "--name-prefix", "staging-", "--bundle")
+	name := "staging-" + testEventSchema
+	want := []string{"kustomization.yaml", name + "-audit.subscription.yaml", name + ".schema.yaml", name + ".topic.yaml"}
+	if got := generatedFiles(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestValidateSchemaName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"abc", true},
+		{"a-1", true},
+		{"ab", false},
+		{strings.Repeat("a", maxSchemaNameLength), true},
+		{strings.Repeat("a", maxSchemaNameLength+1), false},
+		{"google-x", false},
+		{"1abc", false},
+		{"abc-", false},
+		{"aBc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSchemaName(tt.name); (err == nil) != tt.ok {
+				t.Errorf("validateSchemaName = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestExplainNames(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, map[string]string{
+		"Billing_Svc/coreapp.Billing_v1.Invoice.pubsub.proto": "syntax = \"proto3\";\n",
+		// Differs only in case, so it collides after sanitization.
+		"Billing_Svc/coreapp.Billing_v1.invoice.pubsub.proto": "not read",
+	})
+	pad := strings.Repeat("x", 220)
+	stdout, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--glob", "*/*.pubsub.proto", "--explain-names",
+		"--name-template", "{{.Message}}_{{.Package}}", "--prefix-from-dir", "--name-prefix", "Team__"+pad+"_", "--name-suffix", "_V2", "--name-collision", "suffix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := func(message string) string {
+		return "Billing_Svc/coreapp.Billing_v1." + message + ".pubsub.proto\n" +
+			"  base:          coreapp.Billing_v1." + message + "\n" +
+			"  template:      " + message + "_coreapp.Billing_v1\n" +
+			"  dir prefix:    Billing_Svc-" + message + "_coreapp.Billing_v1\n" +
+			"  prefix/suffix: Team__" + pad + "_Billing_Svc-" + message + "_coreapp.Billing_v1_V2\n" +
+			"  sanitized:     team--" + pad + "-billing-svc-invoice-coreapp-billing-v1-v2\n" +
+			"  truncated:     team--" + pad + "-billing-svc-invoice-coreap\n"
+	}
+	want := steps("Invoice") + steps("invoice") + "\n" +
+		"Billing_Svc/coreapp.Billing_v1.Invoice.pubsub.proto -> team--" + pad + "-billing-svc-invoice-coreap\n" +
+		"Billing_Svc/coreapp.Billing_v1.invoice.pubsub.proto -> team--" + pad + "-billing-svc-invoi-" +
+		hashHex("sha256", []byte("Billing_Svc/coreapp.Billing_v1.invoice.pubsub.proto"))[:8] + "\n"
+	if stdout != want {
+		t.Errorf("--explain-names printed\n%s\nwant\n%s", stdout, want)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("--explain-names created the output directory: %v", err)
+	}
+}
+
+func TestExplainNamesReportsInvalidNames(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "pubsub")
+	writeTree(t, in, map[string]string{"goog.v1.Event.pubsub.proto": "", "a.v1.Event.pubsub.proto": ""})
+	stdout, _, err := runTool(t, "--pubsub-dir", in, "--explain-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `a.v1.Event.pubsub.proto
+  base:          a.v1.Event
+  prefix/suffix: a.v1.Event
+  sanitized:     a-v1-event
+  truncated:     a-v1-event
+goog.v1.Event.pubsub.proto
+  base:          goog.v1.Event
+  prefix/suffix: goog.v1.Event
+  sanitized:     goog-v1-event
+  truncated:     goog-v1-event
+  invalid:       schema name "goog-v1-event" must not start with "goog"
+
+a.v1.Event.pubsub.proto -> a-v1-event
+`
+	if stdout != want {
+		t.Errorf("--explain-names printed\n%s\nwant\n%s", stdout, want)
+	}
+}
+
+// fakeNameCommand is a commandRunner answering --name-command from names,
+// keyed by the input's rel path, and recording every stdin it receives.
+type fakeNameCommand struct {
+	names  map[string]string
+	stdins []nameCommandInput
+}
+
+func (f *fakeNameCommand) run(command string, stdin []byte) ([]byte, error) {
+	if command != "namer" {
+		return nil, fmt.Errorf("ran %q, want namer", command)
+	}
+	var in nameCommandInput
+	if err := json.Unmarshal(stdin, &in); err != nil {
+		return nil, err
+	}
+	f.stdins = append(f.stdins, in)
+	name, ok := f.names[in.Rel]
+	if !ok {
+		return nil, errors.New("exit status 3")
+	}
+	return []byte(name + "\n"), nil
+}
+
+func TestNameCommand(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pubsub")
+	writeTree(t, dir, map[string]string{
+		"a.v1.Orders.pubsub.proto":     "syntax = \"proto3\";\nmessage Orders {}\n",
+		"sub/b.v1.Refund.pubsub.proto": "syntax = \"proto3\";\nmessage Refund {}\n",
+	})
+	files := []string{filepath.Join(dir, "a.v1.Orders.pubsub.proto"), filepath.Join(dir, "sub", "b.v1.Refund.pubsub.proto")}
+	tests := []struct {
+		name    string
+		names   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "custom names are sanitized",
+			names: map[string]string{"a.v1.Orders.pubsub.proto": "Orders_Custom", "sub/b.v1.Refund.pubsub.proto": "  refunds.v1  "},
+			want:  []string{"orders-custom", "refunds-v1"},
+		},
+		{
+			name:    "collision after sanitization",
+			names:   map[string]string{"a.v1.Orders.pubsub.proto": "Same_Name", "sub/b.v1.Refund.pubsub.proto": "same.name"},
+			wantErr: `schema name "same-name" derived from both`,
+		},
+		{
+			name:    "failing command",
+			names:   map[string]string{"a.v1.Orders.pubsub.proto": "orders"},
+			wantErr: filepath.Join(dir, "sub", "b.v1.Refund.pubsub.proto") + ": --name-command namer: exit status 3",
+		},
+		{
+			name:    "empty output",
+			names:   map[string]string{"a.v1.Orders.pubsub.proto": " ", "sub/b.v1.Refund.pubsub.proto": "refunds"},
+			wantErr: filepath.Join(dir, "a.v1.Orders.pubsub.proto") + ": --name-command namer printed no name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeNameCommand{names: tt.names}
+			opts := testOptions()
+			opts.pubsubDir = dir
+			opts.nameCommand = "namer"
+			opts.namePrefix = "ignored-"
+			opts.runCommand = fake.run
+			inputs, err := planInputs(files, opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("planInputs error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, in := range inputs {
+				got = append(got, in.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+			wantStdin := []nameCommandInput{
+				{Path: files[0], Rel: "a.v1.Orders.pubsub.proto", Base: "a.v1.Orders", Default: "ignored-a.v1.Orders"},
+				{Path: files[1], Rel: "sub/b.v1.Refund.pubsub.proto", Base: "b.v1.Refund", Default: "ignored-b.v1.Refund"},
+			}
+			if !reflect.DeepEqual(fake.stdins, wantStdin) {
+				t.Errorf("command stdin = %+v, want %+v", fake.stdins, wantStdin)
+			}
+		})
+	}
+}
+
+func TestNameCommandGeneratesCustomNames(t *testing.T) {
+	dir, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+	writeTree(t, dir, map[string]string{testEventFile: testEventProto})
+	fake := &fakeNameCommand{names: map[string]string{testEventFile: "Events_Primary"}}
+	opts := testOptions()
+	opts.pubsubDir, opts.outputDir = dir, out
+	opts.nameCommand = "namer"
+	opts.runCommand = fake.run
+	if err := generateAll([]string{filepath.Join(dir, testEventFile)}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := kustomizationResources(t, out), []string{"events-primary.schema.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+	if data := readFile(t, filepath.Join(out, "events-primary.schema.yaml")); !strings.Contains(data, "  name: events-primary\n") {
+		t.Errorf("schema is not named by the command:\n%s", data)
+	}
+}
+
+func TestNameLengthWarn(t *testing.T) {
+	// testEventSchema is 25 characters.
+	tests := []struct {
+		limit    string
+		wantWarn bool
+		wantErr  string
+	}{
+		{limit: "0"},
+		{limit: "25"},
+		{limit: "253"},
+		{limit: "24", wantWarn: true},
+		{limit: "1", wantWarn: true},
+		{limit: "-1", wantErr: "invalid --name-length-warn -1: want 0 to 253"},
+		{limit: "254", wantErr: "invalid --name-length-warn 254: want 0 to 253"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.limit, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			writeTree(t, in, map[string]string{testEventFile: testEventProto})
+			_, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--name-length-warn", tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			if got, want := kustomizationResources(t, out), []string{testEventSchema + ".schema.yaml"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("resources = %v, want %v", got, want)
+			}
+			warning := "warning: " + filepath.Join(in, testEventFile) + `: schema name "` + testEventSchema + `" is 25 characters, over --name-length-warn=` + tt.limit + "\n"
+			if warned := strings.Contains(stderr, warning); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v; stderr:\n%s", warned, tt.wantWarn, stderr)
+			}
+		})
+	}
+}
+
+func TestWarnLongNamesAfterCollisions(t *testing.T) {
+	// The warning checks final names, so a collision suffix can push a name
+	// over the threshold.
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, collidingInputs)
+	_, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--name-collision", collisionSuffix, "--name-length-warn", "10")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	if n := strings.Count(stderr, "over --name-length-warn=10"); n != 1 {
+		t.Errorf("%d warnings, want 1; stderr:\n%s", n, stderr)
+	}
+	if !strings.Contains(stderr, `schema name "a-v1-foo-`) {
+		t.Errorf("warning does not name the suffixed schema; stderr:\n%s", stderr)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// overlayBase is the directory under each --output-dir that holds the
+// schemas when --overlays is set.
+const overlayBase = "base"
+
+// overlayConfig is the kustomize configuration written next to each overlay
+// when topics are emitted. kustomize's built-in name references do not cover
+// Config Connector kinds, so without it namePrefix would rename schemas and
+// topics but not the schemaRef and topicRef fields pointing at them.
+const overlayConfig = "kustomizeconfig.yaml"
+
+// overlayNameReferences is the contents of overlayConfig.
+const overlayNameReferences = `nameReference:
+  - kind: PubSubSchema
+    group: pubsub.cnrm.cloud.google.com
+    fieldSpecs:
+      - kind: PubSubTopic
+        group: pubsub.cnrm.cloud.google.com
+        path: spec/schemaSettings/schemaRef/name
+  - kind: PubSubTopic
+    group: pubsub.cnrm.cloud.google.com
+    fieldSpecs:
+      - kind: PubSubSubscription
+        group: pubsub.cnrm.cloud.google.com
+        path: spec/topicRef/name
+`
+
+// validateOverlayName requires an env name usable as a directory, namespace
+// and name prefix: a DNS label other than the base directory.
+func validateOverlayName(env string) error {
+	if env == overlayBase {
+		return fmt.Errorf("overlay %q clashes with the %s directory", env, overlayBase)
+	}
+	if len(env) == 0 || len(env) > 63 || !isLabelAlnum(env[0]) || !isLabelAlnum(env[len(env)-1]) {
+		return fmt.Errorf("overlay %q must be 1 to 63 characters starting and ending with a letter or digit", env)
+	}
+	for i := 0; i < len(env); i++ {
+		c := env[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+			return fmt.Errorf("overlay %q may only contain lowercase letters, digits and dashes", env)
+		}
+	}
+	return nil
+}
+
+// renderOverlay is the kustomization of one env overlay: the base with the
+// env's namespace and name prefix, and the overlayConfig name references when
+// refs is set.
+func renderOverlay(env string, refs bool) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: kustomize.config.k8s.io/v1beta1\n")
+	b.WriteString("kind: Kustomization\n\n")
+	b.WriteString("namespace: " + env + "\n")
+	b.WriteString("namePrefix: " + env + "-\n\n")
+	b.WriteString("resources:\n")
+	b.WriteString("  - ../" + overlayBase + "\n")
+	if refs {
+		b.WriteString("\nconfigurations:\n")
+		b.WriteString("  - " + overlayConfig + "\n")
+	}
+	return b.String()
+}
+
+// overlayPath is the kustomization of env next to p's base directory.
+func (p *plan) overlayPath(env string) string {
+	return filepath.Join(filepath.Dir(p.outputDir), env, "kustomization.yaml")
+}
+
+func (p *plan) writeOverlays(opts options) error {
+	for _, env := range opts.overlays {
+		out := p.overlayPath(env)
+		if err := writeFile(out, renderOverlay(env, opts.emitTopics)); err != nil {
+			return err
+		}
+		if opts.emitTopics {
+			if err := writeFile(filepath.Join(filepath.Dir(out), overlayConfig), overlayNameReferences); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Wrote overlay %s -> %s\n", env, out)
+	}
+	return nil
+}
+
+func (p *plan) printOverlays(w io.Writer, opts options) {
+	for _, env := range opts.overlays {
+		fmt.Fprintf(w, "Would write overlay %s -> %s\n", env, p.overlayPath(env))
+		if opts.emitTopics {
+			fmt.Fprintf(w, "Would write %s\n", filepath.Join(filepath.Dir(p.overlayPath(env)), overlayConfig))
+		}
+	}
+}
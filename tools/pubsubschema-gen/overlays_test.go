@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateOverlayName(t *testing.T) {
+	tests := []struct {
+		env     string
+		wantErr string
+	}{
+		{"dev", ""},
+		{"prod-eu1", ""},
+		{"0", ""},
+		{"base", "clashes with the base directory"},
+		{"", "must be 1 to 63 characters"},
+		{strings.Repeat("a", 64), "must be 1 to 63 characters"},
+		{"-dev", "starting and ending with a letter or digit"},
+		{"dev-", "starting and ending with a letter or digit"},
+		{"Dev", "only contain lowercase letters, digits and dashes"},
+		{"dev_1", "only contain lowercase letters, digits and dashes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			err := validateOverlayName(tt.env)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOverlays(t *testing.T) {
+	inputs := map[string]string{
+		"a.v1.Orders.pubsub.proto": "// +consumers: billing\nsyntax = \"proto3\";\nmessage Orders {}\n",
+	}
+	tests := []struct {
+		name string
+		args []string
+		// refs is whether the overlays carry the name reference configuration.
+		refs bool
+	}{
+		{"schemas only", nil, false},
+		{"topics", []string{"--emit-topics"}, true},
+		{"bundle", []string{"--bundle"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, inputs, append([]string{"--overlays", "dev,prod"}, tt.args...)...)
+			if got := kustomizationResources(t, filepath.Join(out, overlayBase)); len(got) == 0 {
+				t.Fatalf("base has no resources")
+			}
+			for _, env := range []string{"dev", "prod"} {
+				var k struct {
+					Namespace      string   `yaml:"namespace"`
+					NamePrefix     string   `yaml:"namePrefix"`
+					Resources      []string `yaml:"resources"`
+					Configurations []string `yaml:"configurations"`
+				}
+				if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, env, "kustomization.yaml"))), &k); err != nil {
+					t.Fatal(err)
+				}
+				if k.Namespace != env || k.NamePrefix != env+"-" || len(k.Resources) != 1 || k.Resources[0] != "../"+overlayBase {
+					t.Errorf("%s overlay = %+v", env, k)
+				}
+				_, err := os.Stat(filepath.Join(out, env, overlayConfig))
+				if tt.refs != (err == nil) || tt.refs != (len(k.Configurations) == 1 && k.Configurations[0] == overlayConfig) {
+					t.Errorf("%s overlay configurations = %v, %s stat error %v; want references %v", env, k.Configurations, overlayConfig, err, tt.refs)
+				}
+			}
+		})
+	}
+}
+
+// TestOverlayNameReferences checks that every field the overlay
+// configuration renames resolves, in the generated manifests, to the name of
+// a generated resource of the referenced kind, so each reference follows
+// that resource's name prefix.
+func TestOverlayNameReferences(t *testing.T) {
+	inputs := map[string]string{
+		"a.v1.Orders.pubsub.proto": "// +consumers: billing\nsyntax = \"proto3\";\nmessage Orders {}\n",
+	}
+	out := generate(t, inputs, "--overlays", "dev", "--bundle")
+	var config struct {
+		NameReference []struct {
+			Kind       string `yaml:"kind"`
+			Group      string `yaml:"group"`
+			FieldSpecs []struct {
+				Kind  string `yaml:"kind"`
+				Group string `yaml:"group"`
+				Path  string `yaml:"path"`
+			} `yaml:"fieldSpecs"`
+		} `yaml:"nameReference"`
+	}
+	if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, "dev", overlayConfig))), &config); err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]map[string]bool{}
+	var docs []map[string]any
+	for _, name := range kustomizationResources(t, filepath.Join(out, overlayBase)) {
+		var doc map[string]any
+		if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, overlayBase, name))), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if group, _, _ := strings.Cut(doc["apiVersion"].(string), "/"); group != "pubsub.cnrm.cloud.google.com" {
+			t.Fatalf("%s: unexpected apiVersion %v", name, doc["apiVersion"])
+		}
+		kind := doc["kind"].(string)
+		if names[kind] == nil {
+			names[kind] = map[string]bool{}
+		}
+		names[kind][doc["metadata"].(map[string]any)["name"].(string)] = true
+		docs = append(docs, doc)
+	}
+	covered := map[string]bool{}
+	for _, ref := range config.NameReference {
+		for _, spec := range ref.FieldSpecs {
+			if ref.Group != "pubsub.cnrm.cloud.google.com" || spec.Group != ref.Group {
+				t.Errorf("%s in %s: groups %q and %q", spec.Path, spec.Kind, ref.Group, spec.Group)
+			}
+			for _, doc := range docs {
+				if doc["kind"] != spec.Kind {
+					continue
+				}
+				var v any = doc
+				for _, key := range strings.Split(spec.Path, "/") {
+					m, _ := v.(map[string]any)
+					v = m[key]
+				}
+				name, _ := v.(string)
+				if !names[ref.Kind][name] {
+					t.Errorf("%s %s is %q, not a generated %s", spec.Kind, spec.Path, name, ref.Kind)
+				}
+				covered[spec.Kind] = true
+			}
+		}
+	}
+	for _, kind := range []string{"PubSubTopic", "PubSubSubscription"} {
+		if !covered[kind] {
+			t.Errorf("no name reference is checked in a generated %s", kind)
+		}
+	}
+}
+
+func TestOverlaysDryRun(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	stdout, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--overlays", "dev", "--emit-topics", "--dry-run")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	for _, want := range []string{
+		"Would write overlay dev -> " + filepath.Join(out, "dev", "kustomization.yaml"),
+		"Would write " + filepath.Join(out, "dev", overlayConfig),
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("dry run output lacks %q:\n%s", want, stdout)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s: %v", out, err)
+	}
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// plannedFile is one generated file, named relative to the output directory.
+type plannedFile struct {
+	name       string
+	schemaName string
+	contents   string
+	// group is the kustomization section label, set with --kustomization-group-by.
+	group string
+	// ref names the resource a topic or subscription points at: its schema
+	// or topic respectively.
+	ref string
+}
+
+// schemaInput is one pubsub proto file together with its derived schema name
+// and normalized definition. The parsed form is computed on first use since
+// only some checks need it.
+type schemaInput struct {
+	path string
+	// rel is path relative to --pubsub-dir, slash-separated.
+	rel        string
+	name       string
+	definition string
+	// imports is filled in by resolveImports when --proto-root is set.
+	imports []resolvedImport
+	// sourceMap maps definition line ranges back to their source files.
+	sourceMap string
+	// strippedPackage is the package removed by --strip-package.
+	strippedPackage string
+	labels          map[string]string
+	// directives are the `// +key: value` comments of the source file.
+	directives map[string][]string
+	// warnings is set with --warnings-as-annotations.
+	warnings []string
+	// schemaType is the rendered schema type, see schemaTypeFor.
+	schemaType string
+	// digest is the --hash-algo digest of the raw file, set when
+	// --provenance-file is in use.
+	digest string
+
+	parsed   *protoFile
+	parseErr error
+}
+
+// setLabel sets a metadata label on the generated schema, sanitizing value.
+func (in *schemaInput) setLabel(key, value string) {
+	if in.labels == nil {
+		in.labels = make(map[string]string)
+	}
+	in.labels[key] = sanitizeLabelValue(value)
+}
+
+func (in *schemaInput) proto() (*protoFile, error) {
+	if in.parsed == nil && in.parseErr == nil {
+		in.parsed, in.parseErr = parseProto(in.definition)
+		if in.parseErr != nil {
+			in.parseErr = fmt.Errorf("parse %s: %w", in.path, in.parseErr)
+		}
+	}
+	return in.parsed, in.parseErr
+}
+
+// plan is the full set of changes a run would make to the output directory.
+// Building it has no side effects, so dry-run and the real run share one path.
+type plan struct {
+	outputDir string
+	schemas   []plannedFile
+	// topics is filled with --emit-topics, one per schema.
+	topics []plannedFile
+	// subscriptions is filled with --bundle, one per schema consumer;
+	// subscriptionsFlushed counts those already written.
+	subscriptions        []plannedFile
+	subscriptionsFlushed int
+	stale                []string
+	// consumers maps schema names to the services from their +consumers
+	// directives.
+	consumers map[string][]string
+	// definitions maps definition digests to the first input producing them;
+	// nil unless --fail-on-duplicate-definition is set.
+	definitions map[string]string
+	// written lists the schema files flush wrote, which is all the index may
+	// reference.
+	written []string
+	// kept holds the --state-file entries of unchanged inputs, which are not
+	// regenerated; regenerated lists the other inputs.
+	kept        map[string]stateInput
+	regenerated []*schemaInput
+	// provenance is collected when --provenance-file is set.
+	provenance []provenanceInput
+	// bufEntries is collected when --buf-index is set.
+	bufEntries []bufIndexEntry
+	// changes is collected when --change-report is set, before anything is
+	// written.
+	changes []schemaChange
+
+	// docsDir is empty unless --emit-docs is set.
+	docsDir   string
+	docs      []plannedFile
+	staleDocs []string
+}
+
+func buildPlan(pubsubFiles []string, opts options) (*plan, error) {
+	inputs, err := planInputs(pubsubFiles, opts)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newPlan(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.stateFile != "" {
+		st, err := loadState(opts.stateFile)
+		if err != nil {
+			return nil, err
+		}
+		all := inputs
+		if inputs, p.kept, err = unchangedInputs(all, st, opts); err != nil {
+			return nil, err
+		}
+		p.regenerated = inputs
+		for _, in := range all {
+			if _, ok := p.kept[in.rel]; ok && opts.provenanceFile != "" {
+				p.provenance = append(p.provenance, provenanceInput{Path: in.rel, Schema: in.name, Digest: in.digest})
+			}
+		}
+	}
+	if err := p.renderAll(inputs, opts); err != nil {
+		return nil, err
+	}
+	if opts.bundle {
+		if err := p.planBundle(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// planInputs creates the inputs and settles their schema names. Names only
+// depend on paths, so no file is read yet.
+func planInputs(pubsubFiles []string, opts options) ([]*schemaInput, error) {
+	var inputs []*schemaInput
+	for _, f := range pubsubFiles {
+		in := &schemaInput{path: f, rel: relativeInputPath(opts.pubsubDir, f)}
+		var err error
+		if in.name, err = deriveSchemaName(in, opts); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, in)
+	}
+	inputs, err := resolveNameCollisions(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	warnLongNames(inputs, opts.nameLengthWarn)
+	return inputs, nil
+}
+
+// newPlan starts an empty plan for inputs. Existing generated files that no
+// input will rewrite are stale and get removed so kustomize doesn't keep
+// applying old schemas.
+func newPlan(inputs []*schemaInput, opts options) (*plan, error) {
+	p := &plan{outputDir: opts.outputDir, docsDir: opts.docsDir, consumers: make(map[string][]string)}
+	names := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		names = append(names, in.name)
+	}
+	if opts.failOnDuplicateDefinition {
+		p.definitions = make(map[string]string)
+	}
+	var err error
+	if p.stale, err = staleFiles(p.outputDir, opts.outputSuffix(), names); err != nil {
+		return nil, err
+	}
+	if opts.emitTopics {
+		staleTopics, err := staleFiles(p.outputDir, topicSuffix, names)
+		if err != nil {
+			return nil, err
+		}
+		p.stale = append(p.stale, staleTopics...)
+	}
+	if p.docsDir != "" {
+		if p.staleDocs, err = staleFiles(p.docsDir, docSuffix, names); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// load transcodes (or, for descriptor sets, re-emits), normalizes, transforms
+// and validates raw, the contents of in's file.
+func (in *schemaInput) load(raw []byte, opts options) error {
+	if opts.provenanceFile != "" || opts.stateFile != "" {
+		in.digest = hashHex(opts.hashAlgo, raw)
+	}
+	var proto string
+	var err error
+	if opts.protoFormat == protoFormatDescriptorSet {
+		proto, err = descriptorSetSource(raw, opts.fieldFilterOption)
+	} else {
+		proto, err = decodeInput(raw, opts.inputEncoding)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", in.path, err)
+	}
+	if opts.protoFormat == protoFormatYAMLEmbedded {
+		if proto, err = extractEmbeddedProto(proto, opts.protoYAMLKey); err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+	}
+	in.definition = normalizeNewlines(proto)
+	// Directives are read from the source before transforms can strip the
+	// comments that carry them.
+	if in.directives, err = parseDirectives(in.definition); err != nil {
+		return fmt.Errorf("%s: %w", in.path, err)
+	}
+	if err := transformDefinition(in, opts); err != nil {
+		return err
+	}
+	return validateInput(in, opts)
+}
+
+// rendered holds the files generated for one input.
+type rendered struct {
+	schema        plannedFile
+	topic         *plannedFile
+	subscriptions []plannedFile
+	doc           *plannedFile
+	consumers     []string
+}
+
+// renderInput loads in and renders its generated files. It only touches in,
+// so inputs can be rendered concurrently.
+func renderInput(in *schemaInput, raw []byte, opts options) (rendered, error) {
+	var r rendered
+	if err := in.load(raw, opts); err != nil {
+		return r, err
+	}
+	for key, value := range pathLabels(in.rel, opts.labelsFromPath) {
+		in.setLabel(key, value)
+	}
+	if opts.packageLabel != "" {
+		pkg, err := inputPackage(in)
+		if err != nil {
+			return r, err
+		}
+		in.setLabel(opts.packageLabel, pkg)
+	}
+	if opts.warningsAsAnnotations {
+		var err error
+		if in.warnings, err = schemaWarnings(in, opts); err != nil {
+			return r, err
+		}
+	}
+	var err error
+	if in.schemaType, err = schemaTypeFor(in, opts); err != nil {
+		return r, err
+	}
+	r.schema = plannedFile{name: in.name + opts.outputSuffix(), schemaName: in.name}
+	if opts.outputFormat == formatRaw {
+		r.schema.contents = rawGeneratedMarker + "\n" + rawDefinition(opts, in)
+	} else {
+		r.schema.contents = schemaManifest(opts, in)
+	}
+	if opts.groupBy == "package" {
+		pkg, err := inputPackage(in)
+		if err != nil {
+			return r, err
+		}
+		r.schema.group = pkg
+	}
+	if opts.emitTopics {
+		enc, err := topicEncoding(in, opts)
+		if err != nil {
+			return r, err
+		}
+		r.topic = &plannedFile{name: in.name + topicSuffix, schemaName: in.name, contents: topicManifest(in, enc), group: r.schema.group, ref: in.name}
+	}
+	r.consumers = in.directiveList("consumers")
+	if opts.bundle {
+		topic, err := subscriptionTopic(in)
+		if err != nil {
+			return r, err
+		}
+		for _, consumer := range r.consumers {
+			sub, err := subscriptionFile(in, consumer, topic)
+			if err != nil {
+				return r, err
+			}
+			sub.group = r.schema.group
+			r.subscriptions = append(r.subscriptions, sub)
+		}
+	}
+	if opts.docsDir != "" {
+		doc, err := docStub(in)
+		if err != nil {
+			return r, err
+		}
+		r.doc = &plannedFile{name: in.name + docSuffix, schemaName: in.name, contents: doc}
+	}
+	return r, nil
+}
+
+// renderAll renders inputs and appends their files to the plan in input
+// order. With --concurrency or --read-concurrency above 1 the inputs are read
+// and rendered in parallel into index-addressed slots, so the plan (and
+// everything written from it) is identical at every concurrency level; on
+// failure the first error in input order is returned. Writing is the third
+// stage: flush runs it under --io-concurrency only after every input rendered,
+// so a failing input never leaves partial output behind.
+func (p *plan) renderAll(inputs []*schemaInput, opts options) error {
+	results := make([]rendered, len(inputs))
+	if opts.concurrency <= 1 && opts.readConcurrency <= 1 {
+		for i, in := range inputs {
+			raw, err := opts.readInput(in.path)
+			if err != nil {
+				return err
+			}
+			if results[i], err = renderInput(in, raw, opts); err != nil {
+				return err
+			}
+		}
+	} else {
+		// A two-stage pipeline: reads are bounded by --read-concurrency (via
+		// readInput) and renders by --concurrency, so up to both limits'
+		// worth of inputs are in flight and reads can run ahead of renders.
+		renderSem := newSemaphore(opts.concurrency)
+		errs := make([]error, len(inputs))
+		forEachParallel(len(inputs), newSemaphore(opts.concurrency+opts.readConcurrency), func(i int) error {
+			raw, err := opts.readInput(inputs[i].path)
+			if err != nil {
+				errs[i] = err
+				return nil
+			}
+			renderSem.acquire()
+			defer renderSem.release()
+			results[i], errs[i] = renderInput(inputs[i], raw, opts)
+			return nil
+		})
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if p.definitions != nil {
+		for _, in := range inputs {
+			if err := opts.rules.record(ruleUniqueDefinitions, p.checkDuplicateDefinition(in, opts)); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.provenanceFile != "" {
+		for _, in := range inputs {
+			p.provenance = append(p.provenance, provenanceInput{Path: in.rel, Schema: in.name, Digest: in.digest})
+		}
+	}
+	if opts.bufIndex != "" {
+		for i, in := range inputs {
+			pkg, err := bufIndexPackage(in)
+			if err != nil {
+				return err
+			}
+			p.bufEntries = append(p.bufEntries, bufIndexEntry{pkg: pkg, schema: in.name, source: in.rel, output: results[i].schema.name})
+		}
+	}
+	if opts.changeReport != "" {
+		for i, in := range inputs {
+			c, err := schemaChangeFor(in, results[i].schema.name, opts)
+			if err != nil {
+				return err
+			}
+			p.changes = append(p.changes, c)
+		}
+	}
+	for _, r := range results {
+		p.schemas = append(p.schemas, r.schema)
+		p.consumers[r.schema.schemaName] = r.consumers
+		if r.topic != nil {
+			p.topics = append(p.topics, *r.topic)
+		}
+		p.subscriptions = append(p.subscriptions, r.subscriptions...)
+		if r.doc != nil {
+			p.docs = append(p.docs, *r.doc)
+		}
+	}
+	return nil
+}
+
+// staleFiles lists files in dir ending in suffix whose name, minus the
+// suffix, is not in names.
+func staleFiles(dir, suffix string, names []string) ([]string, error) {
+	existing, err := listGeneratedFiles(dir, suffix)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(names))
+	for _, n := range names {
+		keep[n+suffix] = true
+	}
+	var stale []string
+	for _, name := range existing {
+		if !keep[name] {
+			stale = append(stale, name)
+		}
+	}
+	return stale, nil
+}
+
+func relativeInputPath(pubsubDir, path string) string {
+	rel, err := filepath.Rel(pubsubDir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// usesPackage reports whether a flag reads the proto package of each input:
+// --package-label, --kustomization-group-by=package or --buf-index.
+func (opts options) usesPackage() bool {
+	return opts.packageLabel != "" || opts.groupBy == "package" || opts.bufIndex != ""
+}
+
+// inputPackage returns the proto package of in. protoc-gen-pubsub drops the
+// package declaration but names files after the full message name, so when
+// the definition has none the package is taken from the filename
+// (coreapp.config.v1.ConfigEvent.pubsub.proto -> coreapp.config.v1).
+func inputPackage(in *schemaInput) (string, error) {
+	pf, err := in.proto()
+	if err != nil {
+		return "", err
+	}
+	if pf.pkg != "" {
+		return pf.pkg, nil
+	}
+	if in.strippedPackage != "" {
+		return in.strippedPackage, nil
+	}
+	if pkg := filenamePackage(in.path); pkg != "" {
+		return pkg, nil
+	}
+	return "(no package)", nil
+}
+
+// filenamePackage is the package a file named after the full message name
+// implies, or "" when the name has no package part.
+func filenamePackage(path string) string {
+	base := schemaBaseName(path)
+	if i := strings.LastIndex(base, "."); i > 0 {
+		return base[:i]
+	}
+	return ""
+}
+
+// groups returns the resource-to-section map for the kustomization, or nil
+// when resources aren't grouped.
+func (p *plan) groups(opts options) map[string]string {
+	if opts.groupBy == "" {
+		return nil
+	}
+	g := make(map[string]string, len(p.schemas)+len(p.topics))
+	for _, s := range p.manifests() {
+		g[s.name] = s.group
+	}
+	for _, f := range p.keptFiles() {
+		g[f.name] = f.group
+	}
+	return g
+}
+
+// manifests returns the files written to the output directory: schemas
+// followed by topics and subscriptions.
+func (p *plan) manifests() []plannedFile {
+	if len(p.topics) == 0 && len(p.subscriptions) == 0 {
+		return p.schemas
+	}
+	files := append([]plannedFile(nil), p.schemas...)
+	files = append(files, p.topics...)
+	return append(files, p.subscriptions...)
+}
+
+// resources returns the sorted manifest basenames for the kustomization,
+// including the files of inputs kept by --state-file.
+func (p *plan) resources() []string {
+	files := append(p.manifests(), p.keptFiles()...)
+	names := make([]string, 0, len(files))
+	for _, s := range files {
+		names = append(names, s.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func applyPlan(p *plan, opts options) error {
+	// Fail on a --kustomization-schema violation before touching the output.
+	if _, err := p.renderIndex(opts); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(p.outputDir, "kustomization.yaml"), err)
+	}
+	if err := p.prune(opts); err != nil {
+		return err
+	}
+	if err := p.flush(0, opts); err != nil {
+		return err
+	}
+	if err := p.writeIndex(opts); err != nil {
+		return err
+	}
+	return p.writeOverlays(opts)
+}
+
+// applyBatched is applyPlan for --batch-size: inputs are loaded, rendered and
+// written batchSize at a time, and only the names of written files are kept,
+// bounding memory by the batch rather than the input set. An input that fails
+// aborts the run after earlier batches were written; the kustomization is
+// only written once every batch succeeded.
+func applyBatched(inputs []*schemaInput, opts options) (*plan, error) {
+	p, err := newPlan(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Fail on a --kustomization-schema violation before touching the output,
+	// like applyPlan. Nothing is rendered yet, but the resource names are
+	// known, and the group headers left out here are only comments.
+	if opts.outputFormat != formatRaw {
+		if err := validateKustomization(renderKustomization(plannedResources(inputs, opts), nil, opts), opts); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(p.outputDir, "kustomization.yaml"), err)
+		}
+	}
+	if err := p.prune(opts); err != nil {
+		return nil, err
+	}
+	for start := 0; start < len(inputs); start += opts.batchSize {
+		end := start + opts.batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		flushed := len(p.schemas)
+		if err := p.renderAll(inputs[start:end], opts); err != nil {
+			return nil, err
+		}
+		for _, in := range inputs[start:end] {
+			// Drop the loaded source; only the rendered files are needed now.
+			in.definition, in.parsed = "", nil
+		}
+		if err := p.flush(flushed, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.writeIndex(opts); err != nil {
+		return nil, err
+	}
+	return p, p.writeOverlays(opts)
+}
+
+// plannedResources is resources for inputs that are not rendered yet: the
+// schema and topic files their names will produce.
+func plannedResources(inputs []*schemaInput, opts options) []string {
+	names := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		names = append(names, in.name+opts.outputSuffix())
+		if opts.emitTopics {
+			names = append(names, in.name+topicSuffix)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mirror returns a copy of the rendered plan targeting dir, with stale files
+// worked out for dir itself. Docs, which have their own directory, stay with
+// the original plan.
+func (p *plan) mirror(dir string, opts options) (*plan, error) {
+	m := &plan{
+		outputDir:     dir,
+		schemas:       append([]plannedFile(nil), p.schemas...),
+		topics:        append([]plannedFile(nil), p.topics...),
+		subscriptions: append([]plannedFile(nil), p.subscriptions...),
+		consumers:     p.consumers,
+	}
+	stale := func(suffix string, files []plannedFile) error {
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, strings.TrimSuffix(f.name, suffix))
+		}
+		s, err := staleFiles(dir, suffix, names)
+		m.stale = append(m.stale, s...)
+		return err
+	}
+	if err := stale(opts.outputSuffix(), m.schemas); err != nil {
+		return nil, err
+	}
+	if opts.emitTopics {
+		if err := stale(topicSuffix, m.topics); err != nil {
+			return nil, err
+		}
+	}
+	if opts.bundle {
+		if err := stale(subscriptionSuffix, m.subscriptions); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (p *plan) prune(opts options) error {
+	if err := removeGeneratedFiles(p.outputDir, p.stale, opts); err != nil {
+		return err
+	}
+	if p.docsDir != "" {
+		return removeGeneratedFiles(p.docsDir, p.staleDocs, opts)
+	}
+	return nil
+}
+
+// flush writes the schemas, topics and docs from index from onwards, plus
+// the subscriptions not written yet, and releases their contents. Writes run
+// concurrently under --io-concurrency; the files are reported in plan order
+// once all of them succeeded.
+func (p *plan) flush(from int, opts options) error {
+	type pending struct {
+		file    *plannedFile
+		dir     string
+		message string
+		index   bool
+	}
+	var writes []pending
+	for i := from; i < len(p.schemas); i++ {
+		writes = append(writes, pending{&p.schemas[i], p.outputDir, "Wrote " + p.schemas[i].schemaName, true})
+	}
+	for i := from; i < len(p.topics); i++ {
+		writes = append(writes, pending{&p.topics[i], p.outputDir, "Wrote topic " + p.topics[i].schemaName, true})
+	}
+	for ; p.subscriptionsFlushed < len(p.subscriptions); p.subscriptionsFlushed++ {
+		sub := &p.subscriptions[p.subscriptionsFlushed]
+		writes = append(writes, pending{sub, p.outputDir, "Wrote subscription " + strings.TrimSuffix(sub.name, subscriptionSuffix), true})
+	}
+	for i := from; i < len(p.docs); i++ {
+		writes = append(writes, pending{&p.docs[i], p.docsDir, "Wrote docs for " + p.docs[i].schemaName, false})
+	}
+	err := forEachParallel(len(writes), newSemaphore(opts.ioConcurrency), func(i int) error {
+		return writeFile(filepath.Join(writes[i].dir, writes[i].file.name), writes[i].file.contents)
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range writes {
+		fmt.Printf("%s -> %s\n", w.message, filepath.Join(w.dir, w.file.name))
+		if w.index {
+			p.written = append(p.written, w.file.name)
+		}
+		w.file.contents = ""
+	}
+	return nil
+}
+
+func printPlan(w io.Writer, p *plan, opts options) error {
+	if err := p.printRemovals(w, opts); err != nil {
+		return err
+	}
+	for _, f := range p.keptFiles() {
+		fmt.Fprintf(w, "Unchanged %s\n", filepath.Join(p.outputDir, f.name))
+	}
+	for _, s := range p.schemas {
+		fmt.Fprintf(w, "Would write %s -> %s\n", s.schemaName, filepath.Join(p.outputDir, s.name))
+	}
+	for _, t := range p.topics {
+		fmt.Fprintf(w, "Would write topic %s -> %s\n", t.schemaName, filepath.Join(p.outputDir, t.name))
+	}
+	for _, sub := range p.subscriptions {
+		fmt.Fprintf(w, "Would write subscription %s -> %s\n", strings.TrimSuffix(sub.name, subscriptionSuffix), filepath.Join(p.outputDir, sub.name))
+	}
+	if index := opts.indexName(); index != "" {
+		fmt.Fprintf(w, "Would write %s\n", filepath.Join(p.outputDir, index))
+	}
+	p.printOverlays(w, opts)
+	for _, name := range p.staleDocs {
+		fmt.Fprintf(w, "Would remove %s\n", filepath.Join(p.docsDir, name))
+	}
+	for _, d := range p.docs {
+		fmt.Fprintf(w, "Would write docs for %s -> %s\n", d.schemaName, filepath.Join(p.docsDir, d.name))
+	}
+	return nil
+}
+
+// writePlanTar streams the planned output tree as a tar archive. Entries are
+// sorted by name and carry a fixed mtime so identical plans produce identical
+// archives.
+func writePlanTar(w io.Writer, p *plan, opts options) error {
+	files := append([]plannedFile(nil), p.manifests()...)
+	if index := opts.indexName(); index != "" {
+		contents, err := p.renderIndex(opts)
+		if err != nil {
+			return err
+		}
+		files = append(files, plannedFile{name: index, contents: contents})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0o644,
+			Size:    int64(len(f.contents)),
+			ModTime: time.Unix(0, 0).UTC(),
+			Format:  tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, f.contents); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// untar reads a tar stream into a map of entry name to contents, failing
+// unless the entries are sorted by name.
+func untar(t *testing.T, stream string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	tr := tar.NewReader(strings.NewReader(stream))
+	prev := ""
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name < prev {
+			t.Errorf("tar entry %s after %s", hdr.Name, prev)
+		}
+		prev = hdr.Name
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestPlanTarMatchesRealRun(t *testing.T) {
+	inputs := map[string]string{
+		testEventFile: testEventProto,
+		"coreapp.other.v1.OtherEvent.pubsub.proto": "syntax = \"proto3\";\nmessage OtherEvent {\n  string id = 1;\n}\n",
+	}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"default", nil},
+		{"compact", []string{"--compact-kustomization"}},
+		{"raw with index", []string{"--output-format", "raw", "--raw-index"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "pubsub")
+			writeTree(t, in, inputs)
+			planned := filepath.Join(t.TempDir(), "planned")
+			args := append([]string{"--pubsub-dir", in, "--output-dir", planned, "--dry-run", "--plan-tar"}, tt.args...)
+			stream, _, err := runTool(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, _, err := runTool(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stream != again {
+				t.Error("two --plan-tar runs produced different archives")
+			}
+			if got := readTree(t, planned); len(got) != 0 {
+				t.Errorf("--dry-run wrote %v", got)
+			}
+
+			real := filepath.Join(t.TempDir(), "real")
+			if _, _, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", real}, tt.args...)...); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := untar(t, stream), readTree(t, real); !reflect.DeepEqual(got, want) {
+				t.Errorf("tar entries:\n%v\nreal run:\n%v", got, want)
+			}
+		})
+	}
+}
+
+// manyInputs returns n inputs in two packages, each padded with a comment of
+// padding bytes.
+func manyInputs(n, padding int) map[string]string {
+	inputs := make(map[string]string, n)
+	comment := "// " + strings.Repeat("x", padding) + "\n"
+	for i := 0; i < n; i++ {
+		pkg := []string{"a.v1", "b.v1"}[i%2]
+		msg := fmt.Sprintf("Event%03d", i)
+		inputs[pkg+"."+msg+".pubsub.proto"] = fmt.Sprintf("syntax = \"proto3\";\npackage %s;\n%s// +consumers: audit\nmessage %s {\n  string id = 1;\n}\n", pkg, comment, msg)
+	}
+	return inputs
+}
+
+func TestBatchedOutputEqualsUnbatched(t *testing.T) {
+	inputs := manyInputs(25, 10)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"default", nil},
+		{"grouped", []string{"--kustomization-group-by", "package"}},
+		{"topics", []string{"--emit-topics"}},
+		{"raw", []string{"--output-format", "raw", "--raw-index"}},
+		{"configmap", []string{"--definition-mode", "configmap"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := treeHash(t, generate(t, inputs, tt.args...))
+			for _, size := range []string{"1", "4", "25", "100"} {
+				if got := treeHash(t, generate(t, inputs, append([]string{"--batch-size", size}, tt.args...)...)); got != want {
+					t.Errorf("--batch-size %s output differs from the unbatched run", size)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchedKustomizationSchemaFailsBeforeWriting(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "kustomization.schema.json")
+	writeTree(t, filepath.Dir(schema), map[string]string{filepath.Base(schema): `{"properties": {"resources": {"maxItems": 2}}}`})
+	for _, batch := range []string{"0", "1"} {
+		t.Run("batch-size="+batch, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+			writeTree(t, in, manyInputs(3, 0))
+			existing := map[string]string{"stale.schema.yaml": generatedMarker + "\n"}
+			writeTree(t, out, existing)
+			_, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--kustomization-schema", schema, "--batch-size", batch)
+			if err == nil || !strings.Contains(err.Error(), "violates --kustomization-schema") {
+				t.Fatalf("error = %v, want a --kustomization-schema violation", err)
+			}
+			if got := readTree(t, out); !reflect.DeepEqual(got, existing) {
+				t.Errorf("output dir changed before the violation was reported: %v", generatedFiles(t, out))
+			}
+		})
+	}
+}
+
+// BenchmarkBatchSize reports the peak heap of a run over large inputs, which
+// --batch-size bounds by the batch instead of the input set.
+func BenchmarkBatchSize(b *testing.B) {
+	in := filepath.Join(b.TempDir(), "pubsub")
+	for name, contents := range manyInputs(200, 64<<10) {
+		if err := os.MkdirAll(in, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(in, name), []byte(contents), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	for _, size := range []string{"0", "10"} {
+		b.Run("batch-size="+size, func(b *testing.B) {
+			stdout := os.Stdout
+			os.Stdout = devNull
+			defer func() { os.Stdout = stdout }()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				out := b.TempDir()
+				runtime.GC()
+				stop := make(chan struct{})
+				done := make(chan uint64)
+				go func() {
+					var max uint64
+					var ms runtime.MemStats
+					for {
+						runtime.ReadMemStats(&ms)
+						if ms.HeapAlloc > max {
+							max = ms.HeapAlloc
+						}
+						select {
+						case <-stop:
+							done <- max
+							return
+						case <-time.After(time.Millisecond):
+						}
+					}
+				}()
+				err := run([]string{"--pubsub-dir", in, "--output-dir", out, "--batch-size", size})
+				close(stop)
+				if m := <-done; m > peak {
+					peak = m
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file holds a deliberately small proto tokenizer and parser. It
+// understands enough of the language to inspect the generated pubsub protos
+// (syntax, package, imports, messages, fields, enums) and skips everything it
+// doesn't need. It is not a validator; protoc remains the source of truth.
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokSymbol
+	tokComment
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+	// start and end are byte offsets of the token in the source.
+	start, end int
+	// commented is set on non-comment tokens documented by leading comments.
+	commented bool
+}
+
+// endLine is the line t ends on; block comments can span several lines.
+func (t token) endLine() int {
+	return t.line + strings.Count(t.text, "\n")
+}
+
+// leadingComments returns the index of the first comment documenting
+// toks[i], or i if there is none. Documenting comments are alone on their
+// lines and end on the line directly above the next one, so a trailing comment
+// on the previous statement's line or a comment after a blank line does not
+// count.
+func leadingComments(toks []token, i int) int {
+	first, line := i, toks[i].line
+	for j := i - 1; j >= 0 && toks[j].kind == tokComment; j-- {
+		c := toks[j]
+		if c.endLine() != line-1 || (j > 0 && toks[j-1].endLine() == c.line) {
+			break
+		}
+		first, line = j, c.line
+	}
+	return first
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// tokenize splits src into tokens, including comments. Unterminated strings
+// and block comments are reported as errors.
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			j := strings.IndexByte(src[i:], '\n')
+			if j < 0 {
+				j = len(src) - i
+			}
+			toks = append(toks, token{kind: tokComment, text: src[i : i+j], line: line, start: i, end: i + j})
+			i += j
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			j := strings.Index(src[i+2:], "*/")
+			if j < 0 {
+				return nil, fmt.Errorf("line %d: unterminated block comment", line)
+			}
+			end := i + 2 + j + 2
+			toks = append(toks, token{kind: tokComment, text: src[i:end], line: line, start: i, end: end})
+			line += strings.Count(src[i:end], "\n")
+			i = end
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string literal", line)
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string literal", line)
+			}
+			toks = append(toks, token{kind: tokString, text: src[i : j+1], line: line, start: i, end: j + 1})
+			i = j + 1
+		case isIdentStart(c) || (c == '.' && i+1 < len(src) && isIdentStart(src[i+1])):
+			j := i + 1
+			for j < len(src) && (isIdentChar(src[j]) || (src[j] == '.' && j+1 < len(src) && isIdentStart(src[j+1]))) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[i:j], line: line, start: i, end: j})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(src) && (isIdentChar(src[j]) || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[i:j], line: line, start: i, end: j})
+			i = j
+		default:
+			toks = append(toks, token{kind: tokSymbol, text: string(c), line: line, start: i, end: i + 1})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+type protoFile struct {
+	syntax   string
+	pkg      string
+	imports  []string
+	messages []*protoMessage
+	enums    []*protoEnum
+	comments []token
+}
+
+type protoMessage struct {
+	name     string
+	line     int
+	fields   []protoField
+	messages []*protoMessage
+	enums    []*protoEnum
+}
+
+type protoField struct {
+	label  string
+	typ    string
+	name   string
+	number int
+	line   int
+	// keyType and valueType are set for map fields; typ is then "map".
+	keyType, valueType string
+	oneof              string
+	commented          bool
+}
+
+type protoEnum struct {
+	name   string
+	values []protoEnumValue
+}
+
+type protoEnumValue struct {
+	name   string
+	number int
+}
+
+// types returns every type name referenced by the field.
+func (f protoField) types() []string {
+	if f.typ == "map" {
+		return []string{f.keyType, f.valueType}
+	}
+	return []string{f.typ}
+}
+
+// typeString renders the field's label and type as declared, e.g.
+// "repeated string" or "map<string, int32>".
+func (f protoField) typeString() string {
+	typ := f.typ
+	if f.typ == "map" {
+		typ = "map<" + f.keyType + ", " + f.valueType + ">"
+	}
+	if f.label != "" {
+		typ = f.label + " " + typ
+	}
+	return typ
+}
+
+// walkMessages calls fn for every message in the file, nested ones included,
+// with the dotted path of the message relative to the package.
+func (pf *protoFile) walkMessages(fn func(path string, m *protoMessage)) {
+	var walk func(prefix string, ms []*protoMessage)
+	walk = func(prefix string, ms []*protoMessage) {
+		for _, m := range ms {
+			path := prefix + m.name
+			fn(path, m)
+			walk(path+".", m.messages)
+		}
+	}
+	walk("", pf.messages)
+}
+
+type protoParser struct {
+	toks []token
+	pos  int
+}
+
+// parseProto parses src into a protoFile.
+func parseProto(src string) (*protoFile, error) {
+	all, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	pf := &protoFile{}
+	p := &protoParser{}
+	for i, t := range all {
+		if t.kind == tokComment {
+			pf.comments = append(pf.comments, t)
+			continue
+		}
+		t.commented = leadingComments(all, i) < i
+		p.toks = append(p.toks, t)
+	}
+	if err := p.parseFile(pf); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+func (p *protoParser) peek() token {
+	if p.pos >= len(p.toks) {
+		line := 0
+		if len(p.toks) > 0 {
+			line = p.toks[len(p.toks)-1].line
+		}
+		return token{kind: tokSymbol, line: line}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *protoParser) next() token {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *protoParser) eof() bool { return p.pos >= len(p.toks) }
+
+func (p *protoParser) expect(text string) error {
+	t := p.next()
+	if t.text != text {
+		return fmt.Errorf("line %d: expected %q, found %q", t.line, text, t.text)
+	}
+	return nil
+}
+
+func (p *protoParser) expectIdent() (token, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return t, fmt.Errorf("line %d: expected identifier, found %q", t.line, t.text)
+	}
+	return t, nil
+}
+
+// skipStatement skips to the end of the current statement, including a
+// trailing block if one opens before the terminating semicolon. Bracketed
+// options are skipped whole, so the braces of an aggregate value such as
+// [(my.opt) = { a: 1 }] do not end the statement.
+func (p *protoParser) skipStatement() error {
+	for !p.eof() {
+		t := p.next()
+		switch t.text {
+		case ";":
+			return nil
+		case "[":
+			if err := p.skipOptions(); err != nil {
+				return err
+			}
+		case "{":
+			return p.skipBlock()
+		}
+	}
+	return fmt.Errorf("line %d: unexpected end of file", p.peek().line)
+}
+
+// skipOptions skips tokens up to and including the bracket that closes the
+// option list whose opening bracket was just consumed, tracking the brackets
+// and braces of aggregate values in between.
+func (p *protoParser) skipOptions() error {
+	brackets, braces := 1, 0
+	for !p.eof() {
+		switch p.next().text {
+		case "[":
+			brackets++
+		case "]":
+			brackets--
+			if brackets == 0 && braces == 0 {
+				return nil
+			}
+		case "{":
+			braces++
+		case "}":
+			braces--
+		}
+	}
+	return fmt.Errorf("line %d: unterminated field options", p.peek().line)
+}
+
+// skipBlock skips tokens up to and including the brace that closes the block
+// whose opening brace was just consumed.
+func (p *protoParser) skipBlock() error {
+	depth := 1
+	for !p.eof() {
+		switch p.next().text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("line %d: unterminated block", p.peek().line)
+}
+
+func unquote(s string) string {
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+	return strings.Trim(s, `"'`)
+}
+
+func (p *protoParser) parseFile(pf *protoFile) error {
+	for !p.eof() {
+		t := p.next()
+		switch t.text {
+		case ";":
+		case "syntax", "edition":
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			v := p.next()
+			if v.kind != tokString {
+				return fmt.Errorf("line %d: expected string after %s", v.line, t.text)
+			}
+			if t.text == "syntax" {
+				pf.syntax = unquote(v.text)
+			} else {
+				pf.syntax = "editions"
+			}
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "package":
+			name, err := p.expectIdent()
+			if err != nil {
+				return err
+			}
+			pf.pkg = name.text
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "import":
+			v := p.next()
+			if v.text == "public" || v.text == "weak" {
+				v = p.next()
+			}
+			if v.kind != tokString {
+				return fmt.Errorf("line %d: expected import path", v.line)
+			}
+			pf.imports = append(pf.imports, unquote(v.text))
+			if err := p.expect(";"); err != nil {
+				return err
+			}
+		case "message":
+			m, err := p.parseMessage()
+			if err != nil {
+				return err
+			}
+			pf.messages = append(pf.messages, m)
+		case "enum":
+			e, err := p.parseEnum()
+			if err != nil {
+				return err
+			}
+			pf.enums = append(pf.enums, e)
+		default:
+			// option, service, extend and anything else we don't inspect.
+			p.pos--
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *protoParser) parseMessage() (*protoMessage, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	m := &protoMessage{name: name.text, line: name.line}
+	if err := p.parseMessageBody(m, ""); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseMessageBody parses declarations up to the closing brace of m (or of the
+// oneof named oneof, whose fields are added to m).
+func (p *protoParser) parseMessageBody(m *protoMessage, oneof string) error {
+	for {
+		if p.eof() {
+			return fmt.Errorf("line %d: unterminated message %s", p.peek().line, m.name)
+		}
+		t := p.peek()
+		switch t.text {
+		case "}":
+			p.next()
+			return nil
+		case ";":
+			p.next()
+		case "message":
+			p.next()
+			nested, err := p.parseMessage()
+			if err != nil {
+				return err
+			}
+			m.messages = append(m.messages, nested)
+		case "enum":
+			p.next()
+			e, err := p.parseEnum()
+			if err != nil {
+				return err
+			}
+			m.enums = append(m.enums, e)
+		case "oneof":
+			p.next()
+			name, err := p.expectIdent()
+			if err != nil {
+				return err
+			}
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseMessageBody(m, name.text); err != nil {
+				return err
+			}
+		case "option", "reserved", "extensions", "extend":
+			if err := p.skipStatement(); err != nil {
+				return err
+			}
+		default:
+			f, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				f.oneof = oneof
+				m.fields = append(m.fields, *f)
+			}
+		}
+	}
+}
+
+// parseField parses a field declaration. proto2 groups are skipped and
+// reported as a nil field.
+func (p *protoParser) parseField() (*protoField, error) {
+	first := p.peek()
+	f := &protoField{line: first.line, commented: first.commented}
+	if first.text == "repeated" || first.text == "optional" || first.text == "required" {
+		f.label = p.next().text
+	}
+	typ := p.next()
+	switch {
+	case typ.text == "map":
+		if err := p.expect("<"); err != nil {
+			return nil, err
+		}
+		k, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		v, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(">"); err != nil {
+			return nil, err
+		}
+		f.typ, f.keyType, f.valueType = "map", k.text, v.text
+	case typ.text == "group":
+		return nil, p.skipStatement()
+	case typ.kind == tokIdent:
+		f.typ = typ.text
+	default:
+		return nil, fmt.Errorf("line %d: expected field type, found %q", typ.line, typ.text)
+	}
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	f.name = name.text
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	neg := false
+	num := p.next()
+	if num.text == "-" {
+		neg = true
+		num = p.next()
+	}
+	n, err := strconv.ParseInt(num.text, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: invalid field number %q for %s", num.line, num.text, f.name)
+	}
+	if neg {
+		n = -n
+	}
+	f.number = int(n)
+	return f, p.skipStatement()
+}
+
+func (p *protoParser) parseEnum() (*protoEnum, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	e := &protoEnum{name: name.text}
+	for {
+		if p.eof() {
+			return nil, fmt.Errorf("line %d: unterminated enum %s", p.peek().line, e.name)
+		}
+		t := p.next()
+		switch {
+		case t.text == "}":
+			return e, nil
+		case t.text == ";":
+		case t.text == "option" || t.text == "reserved":
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		case t.kind == tokIdent:
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			neg := false
+			num := p.next()
+			if num.text == "-" {
+				neg = true
+				num = p.next()
+			}
+			n, err := strconv.ParseInt(num.text, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid enum value %q for %s", num.line, num.text, t.text)
+			}
+			if neg {
+				n = -n
+			}
+			e.values = append(e.values, protoEnumValue{name: t.text, number: int(n)})
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q in enum %s", t.line, t.text, e.name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProtoFields(t *testing.T) {
+	src := `syntax = "proto3";
+package a.v1;
+import "google/protobuf/any.proto";
+option go_package = "example.com/a";
+
+message Event {
+  option deprecated = true;
+  reserved 4;
+  repeated string ids = 1 [packed = true];
+  map<string, int32> counts = 2;
+  oneof body {
+    Created created = 3;
+  }
+  message Created {
+    optional .a.v1.Kind kind = 1;
+  }
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+
+enum Top {
+  TOP_UNSPECIFIED = 0;
+  TOP_NEG = -1;
+}
+
+service Events {
+  rpc Get (Event) returns (Event) {}
+}
+`
+	pf, err := parseProto(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.syntax != "proto3" || pf.pkg != "a.v1" || !reflect.DeepEqual(pf.imports, []string{"google/protobuf/any.proto"}) {
+		t.Errorf("syntax %q, package %q, imports %v", pf.syntax, pf.pkg, pf.imports)
+	}
+	if len(pf.messages) != 1 {
+		t.Fatalf("got %d top-level messages, want 1", len(pf.messages))
+	}
+	tests := []struct {
+		name   string
+		typ    string
+		number int
+		oneof  string
+	}{
+		{"ids", "repeated string", 1, ""},
+		{"counts", "map<string, int32>", 2, ""},
+		{"created", "Created", 3, "body"},
+	}
+	fields := pf.messages[0].fields
+	if len(fields) != len(tests) {
+		t.Fatalf("got %d fields, want %d", len(fields), len(tests))
+	}
+	for i, tt := range tests {
+		f := fields[i]
+		if f.name != tt.name || f.typeString() != tt.typ || f.number != tt.number || f.oneof != tt.oneof {
+			t.Errorf("field %d = %s %s = %d (oneof %q), want %s %s = %d (oneof %q)", i, f.typeString(), f.name, f.number, f.oneof, tt.typ, tt.name, tt.number, tt.oneof)
+		}
+	}
+	var paths []string
+	pf.walkMessages(func(path string, m *protoMessage) { paths = append(paths, path) })
+	if want := []string{"Event", "Event.Created"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("walkMessages visited %v, want %v", paths, want)
+	}
+	if len(pf.enums) != 1 || !reflect.DeepEqual(pf.enums[0].values, []protoEnumValue{{"TOP_UNSPECIFIED", 0}, {"TOP_NEG", -1}}) {
+		t.Errorf("top-level enums = %+v", pf.enums)
+	}
+}
+
+func TestParseProtoAggregateOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+	}{
+		{"scalar", `[deprecated = true]`},
+		{"aggregate", `[(my.opt) = { a: 1 }]`},
+		{"nested aggregate", `[(my.opt) = { a: { b: [1, 2] } c: "}" }]`},
+		{"several", `[(my.opt) = { a: 1 }, deprecated = true]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "syntax = \"proto3\";\nmessage E {\n  string x = 1 " + tt.options + ";\n  int32 y = 2;\n}\n"
+			pf, err := parseProto(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range pf.messages[0].fields {
+				got = append(got, f.name)
+			}
+			if want := []string{"x", "y"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("fields = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"unterminated string", "syntax = \"proto3;\n"},
+		{"unterminated comment", "/* open\nmessage E {}\n"},
+		{"unterminated message", "message E {\n  string id = 1;\n"},
+		{"bad field number", "message E {\n  string id = x;\n}\n"},
+		{"unterminated options", "message E {\n  string id = 1 [(my.opt) = { a: 1 };\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseProto(tt.src); err == nil {
+				t.Error("parseProto succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestFieldTypeString(t *testing.T) {
+	tests := []struct {
+		field protoField
+		want  string
+	}{
+		{protoField{typ: "string"}, "string"},
+		{protoField{label: "repeated", typ: "a.v1.Item"}, "repeated a.v1.Item"},
+		{protoField{label: "optional", typ: "int64"}, "optional int64"},
+		{protoField{typ: "map", keyType: "string", valueType: "int32"}, "map<string, int32>"},
+	}
+	for _, tt := range tests {
+		if got := tt.field.typeString(); got != tt.want {
+			t.Errorf("typeString(%+v) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
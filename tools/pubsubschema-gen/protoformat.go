@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Proto formats: raw inputs are the .proto source itself; yaml-embedded
+// inputs are YAML documents carrying the source under --proto-yaml-key;
+// descriptor-set inputs are binary FileDescriptorSets, re-emitted as source.
+const (
+	protoFormatRaw           = "raw"
+	protoFormatYAMLEmbedded  = "yaml-embedded"
+	protoFormatDescriptorSet = "descriptor-set"
+)
+
+// extractEmbeddedProto returns the string at the dotted key path in the YAML
+// document src.
+func extractEmbeddedProto(src, keyPath string) (string, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		return "", fmt.Errorf("parsing YAML: %w", err)
+	}
+	var cur interface{} = doc
+	keys := strings.Split(keyPath, ".")
+	for i, k := range keys {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%s is not a mapping", strings.Join(keys[:i], "."))
+		}
+		if cur, ok = m[k]; !ok {
+			return "", fmt.Errorf("no %s key", strings.Join(keys[:i+1], "."))
+		}
+	}
+	s, ok := cur.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", keyPath)
+	}
+	return s, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractEmbeddedProto(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		keyPath string
+		want    string
+		wantErr string
+	}{
+		{"top level", "definition: |\n  syntax = \"proto3\";\n", "definition", "syntax = \"proto3\";\n", ""},
+		{"nested", "spec:\n  proto:\n    source: |\n      message E {}\n", "spec.proto.source", "message E {}\n", ""},
+		{"missing key", "other: x\n", "definition", "", "no definition key"},
+		{"missing nested key", "spec:\n  proto: {}\n", "spec.proto.source", "", "no spec.proto.source key"},
+		{"not a mapping", "spec: text\n", "spec.proto", "", "spec is not a mapping"},
+		{"not a string", "definition: [a, b]\n", "definition", "", "definition is not a string"},
+		{"invalid yaml", "definition: [\n", "definition", "", "parsing YAML"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractEmbeddedProto(tt.src, tt.keyPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("extractEmbeddedProto = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// indentLines prefixes every non-empty line of s.
+func indentLines(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			lines[i] = prefix + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func TestYAMLEmbeddedInput(t *testing.T) {
+	raw := generate(t, map[string]string{testEventFile: testEventProto})
+	tests := []struct {
+		name   string
+		inputs map[string]string
+		args   []string
+	}{
+		{"default key and glob", map[string]string{"coreapp.test.v1.TestEvent.pubsub.yaml": "# Editor metadata.\nkind: ProtoSource\ndefinition: |\n" + indentLines(testEventProto, "  ")}, nil},
+		{
+			"custom key",
+			map[string]string{"coreapp.test.v1.TestEvent.pubsub.yaml": "meta:\n  proto: |\n" + indentLines(testEventProto, "    ")},
+			[]string{"--proto-yaml-key", "meta.proto"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, tt.inputs, append([]string{"--proto-format", "yaml-embedded"}, tt.args...)...)
+			if got, want := readTree(t, out), readTree(t, raw); !reflect.DeepEqual(got, want) {
+				t.Errorf("output = %v, want the raw input's %v", got, want)
+			}
+		})
+	}
+}
+
+func TestYAMLEmbeddedInputErrors(t *testing.T) {
+	err := generateErr(t, map[string]string{"a.v1.E.pubsub.yaml": "other: x\n"}, "--proto-format", "yaml-embedded")
+	if want := filepath.Join("pubsub", "a.v1.E.pubsub.yaml") + ": no definition key"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."; when it
+// isn't, the module version from the build info is used.
+var version = ""
+
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "(devel)"
+}
+
+type provenanceInput struct {
+	Path   string `json:"path"`
+	Schema string `json:"schema"`
+	Digest string `json:"digest"`
+}
+
+type provenance struct {
+	Tool          string            `json:"tool"`
+	Version       string            `json:"version"`
+	Timestamp     string            `json:"timestamp"`
+	HashAlgorithm string            `json:"hashAlgorithm"`
+	Inputs        []provenanceInput `json:"inputs"`
+}
+
+// runTimestamp is the time recorded in the provenance file. SOURCE_DATE_EPOCH
+// pins it for reproducible output.
+func runTimestamp() (time.Time, error) {
+	if v := os.Getenv("SOURCE_DATE_EPOCH"); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", v)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	return time.Now().UTC(), nil
+}
+
+// writeProvenanceFile records the inputs of the run and their digests, taken
+// over the raw file bytes before transcoding or transforms.
+func writeProvenanceFile(path string, p *plan, opts options) error {
+	ts, err := runTimestamp()
+	if err != nil {
+		return err
+	}
+	inputs := append([]provenanceInput{}, p.provenance...)
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].Path < inputs[j].Path })
+	data, err := json.MarshalIndent(provenance{
+		Tool:          "pubsubschema-gen",
+		Version:       toolVersion(),
+		Timestamp:     ts.Format(time.RFC3339),
+		HashAlgorithm: opts.hashAlgo,
+		Inputs:        inputs,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data)+"\n")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProvenanceFile(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	inputs := map[string]string{
+		testEventFile: testEventProto,
+		// The digest covers the bytes on disk, before newline normalization.
+		"a.v1.Crlf.pubsub.proto": "syntax = \"proto3\";\r\nmessage Crlf {}\r\n",
+	}
+	in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+	writeTree(t, in, inputs)
+	report := filepath.Join(out, "provenance.json")
+	args := []string{"--pubsub-dir", in, "--output-dir", out, "--provenance-file", report}
+	if _, _, err := runTool(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	first := readFile(t, report)
+	var got provenance
+	if err := json.Unmarshal([]byte(first), &got); err != nil {
+		t.Fatal(err)
+	}
+	digest := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	want := provenance{
+		Tool:          "pubsubschema-gen",
+		Version:       toolVersion(),
+		Timestamp:     "2023-11-14T22:13:20Z",
+		HashAlgorithm: "sha256",
+		Inputs: []provenanceInput{
+			{Path: "a.v1.Crlf.pubsub.proto", Schema: "a-v1-crlf", Digest: digest(inputs["a.v1.Crlf.pubsub.proto"])},
+			{Path: testEventFile, Schema: testEventSchema, Digest: digest(testEventProto)},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("provenance = %+v, want %+v", got, want)
+	}
+
+	// A second run keeps the file in the output directory and, with the
+	// time pinned, rewrites it byte for byte.
+	if _, _, err := runTool(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	if again := readFile(t, report); again != first {
+		t.Errorf("second run wrote\n%s\nwant\n%s", again, first)
+	}
+}
+
+func TestProvenanceFileHashAlgo(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "0")
+	report := filepath.Join(t.TempDir(), "provenance.json")
+	generate(t, map[string]string{testEventFile: testEventProto}, "--provenance-file", report, "--hash-algo", "blake2b")
+	var got provenance
+	if err := json.Unmarshal([]byte(readFile(t, report)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.HashAlgorithm != "blake2b" || len(got.Inputs) != 1 || got.Inputs[0].Digest != hashHex("blake2b", []byte(testEventProto)) {
+		t.Errorf("provenance = %+v, want a blake2b digest of the input", got)
+	}
+	if got.Timestamp != "1970-01-01T00:00:00Z" {
+		t.Errorf("timestamp = %s, want the SOURCE_DATE_EPOCH time", got.Timestamp)
+	}
+}
+
+func TestPrunedSuffix(t *testing.T) {
+	tests := []struct {
+		path           string
+		reconcileApply bool
+		want           string
+	}{
+		{"provenance.json", false, ""},
+		{"provenance.json", true, ""},
+		{"out/provenance.schema.yaml", false, ".schema.yaml"},
+		{"provenance.schema.proto", false, rawSuffix},
+		{"provenance.proto", false, ""},
+		{"provenance.topic.yaml", false, topicSuffix},
+		{"provenance.subscription.yaml", false, subscriptionSuffix},
+		{"provenance.schema.md", false, docSuffix},
+		{"provenance.yaml", false, ""},
+		{"provenance.yaml", true, ".yaml"},
+		{"provenance.yml", true, ".yml"},
+	}
+	for _, tt := range tests {
+		if got := prunedSuffix(tt.path, tt.reconcileApply); got != tt.want {
+			t.Errorf("prunedSuffix(%q, %v) = %q, want %q", tt.path, tt.reconcileApply, got, tt.want)
+		}
+	}
+}
+
+func TestReportFlagsRejectPrunedSuffixes(t *testing.T) {
+	for _, flag := range []string{"--rules-report", "--consumers-report", "--provenance-file", "--buf-index", "--change-report", "--state-file"} {
+		for _, name := range []string{"report.schema.yaml", "report.schema.proto", "report.topic.yaml", "report.subscription.yaml"} {
+			t.Run(flag+" "+name, func(t *testing.T) {
+				err := generateErr(t, map[string]string{testEventFile: testEventProto}, flag, filepath.Join(t.TempDir(), name))
+				if !strings.Contains(err.Error(), flag+" must not end in") {
+					t.Errorf("error = %v", err)
+				}
+			})
+		}
+		t.Run(flag+" under --reconcile --apply", func(t *testing.T) {
+			err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--reconcile", "--apply", flag, filepath.Join(t.TempDir(), "report.yaml"))
+			if !strings.Contains(err.Error(), flag+" must not end in .yaml") {
+				t.Errorf("error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// rawIndexName is the index written with --raw-index in --output-format=raw.
+const rawIndexName = "index.json"
+
+// rawSuffix is the suffix of raw outputs. It differs from plain ".proto" so
+// that pruning never touches inputs or hand-written protos.
+const rawSuffix = ".schema.proto"
+
+// rawGeneratedMarker heads every raw output, as a proto comment.
+const rawGeneratedMarker = "// Code generated by pubsubschema-gen. DO NOT EDIT."
+
+// outputSuffix is the file suffix of generated schemas, which also scopes
+// pruning.
+func (opts options) outputSuffix() string {
+	if opts.outputFormat == formatRaw {
+		return rawSuffix
+	}
+	return ".schema.yaml"
+}
+
+// outputMarker is the first line of every generated file in the output
+// directory.
+func (opts options) outputMarker() string {
+	if opts.outputFormat == formatRaw {
+		return rawGeneratedMarker
+	}
+	return generatedMarker
+}
+
+// indexName is the file listing the generated schemas: the kustomization, or
+// in raw mode the optional JSON index. Empty means none is written.
+func (opts options) indexName() string {
+	if opts.outputFormat != formatRaw {
+		return "kustomization.yaml"
+	}
+	if opts.rawIndex {
+		return rawIndexName
+	}
+	return ""
+}
+
+// rawDefinition is the body of a raw output file: the definition as it
+// would be embedded, with no manifest around it, and with the
+// --definition-line-prefix on every line.
+func rawDefinition(opts options, in *schemaInput) string {
+	def := in.definition
+	if opts.definitionLinePrefix != "" {
+		lines := strings.SplitAfter(strings.TrimSuffix(def, "\n"), "\n")
+		def = opts.definitionLinePrefix + strings.Join(lines, opts.definitionLinePrefix)
+		if strings.HasSuffix(in.definition, "\n") {
+			def += "\n"
+		}
+	}
+	return def
+}
+
+// renderRawIndex maps schema names to their raw files.
+func (p *plan) renderRawIndex() (string, error) {
+	index := make(map[string]string, len(p.schemas))
+	for _, s := range p.schemas {
+		index[s.schemaName] = s.name
+	}
+	for _, f := range p.keptFiles() {
+		if f.name == f.schemaName+rawSuffix {
+			index[f.schemaName] = f.name
+		}
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// renderIndex renders the file named by opts.indexName.
+func (p *plan) renderIndex(opts options) (string, error) {
+	if opts.outputFormat == formatRaw {
+		return p.renderRawIndex()
+	}
+	contents := renderKustomization(p.resources(), p.groups(opts), opts)
+	return contents, validateKustomization(contents, opts)
+}
+
+// writeIndex writes the index after the schemas were flushed. It only lists
+// files written this run, or kept from the last one by --state-file, and
+// fails if any planned schema is missing from them.
+func (p *plan) writeIndex(opts options) error {
+	if opts.indexName() == "" {
+		return nil
+	}
+	written := make(map[string]bool, len(p.written))
+	for _, name := range p.written {
+		written[name] = true
+	}
+	listed := p.written
+	for _, f := range p.keptFiles() {
+		written[f.name] = true
+		listed = append(listed, f.name)
+	}
+	for _, name := range p.resources() {
+		if !written[name] {
+			return fmt.Errorf("%s was planned but not written", filepath.Join(p.outputDir, name))
+		}
+	}
+	if opts.outputFormat != formatRaw {
+		return writeKustomization(p.outputDir, listed, p.groups(opts), opts)
+	}
+	contents, err := p.renderRawIndex()
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(p.outputDir, rawIndexName), contents)
+}
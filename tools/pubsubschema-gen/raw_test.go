@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRawOutputMatchesEmbeddedDefinitions(t *testing.T) {
+	inputs := map[string]string{
+		testEventFile:            testEventProto,
+		"a.v1.Crlf.pubsub.proto": "syntax = \"proto3\";\r\n// Comment.\r\nmessage Crlf {}\r\n",
+	}
+	for _, args := range [][]string{nil, {"--comments", "none", "--strip-package"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			manifests := generate(t, inputs, args...)
+			raw := generate(t, inputs, append([]string{"--output-format", "raw"}, args...)...)
+			files := readTree(t, raw)
+			if len(files) != len(inputs) {
+				t.Errorf("raw output = %v, want one %s per input and no index", generatedFiles(t, raw), rawSuffix)
+			}
+			for _, name := range []string{testEventSchema, "a-v1-crlf"} {
+				var doc struct {
+					Spec struct {
+						Definition string `yaml:"definition"`
+					} `yaml:"spec"`
+				}
+				if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(manifests, name+".schema.yaml"))), &doc); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := files[name+rawSuffix], rawGeneratedMarker+"\n"+doc.Spec.Definition; got != want {
+					t.Errorf("%s%s = %q, want the marker and the embedded definition %q", name, rawSuffix, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRawIndex(t *testing.T) {
+	out := generate(t, map[string]string{testEventFile: testEventProto, "a.v1.Other.pubsub.proto": "syntax = \"proto3\";\nmessage Other {}\n"}, "--output-format", "raw", "--raw-index")
+	var index map[string]string
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(out, rawIndexName))), &index); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{testEventSchema: testEventSchema + rawSuffix, "a-v1-other": "a-v1-other" + rawSuffix}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("index = %v, want %v", index, want)
+	}
+}
+
+func TestRawPrunesOnlyRawOutputs(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	writeTree(t, out, map[string]string{
+		"stale" + rawSuffix: rawGeneratedMarker + "\n",
+		"hand.proto":        "syntax = \"proto3\";\n",
+		"stale.schema.yaml": generatedMarker + "\n",
+	})
+	if _, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--output-format", "raw"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := generatedFiles(t, out), []string{testEventSchema + rawSuffix, "hand.proto", "stale.schema.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("output dir = %v, want %v", got, want)
+	}
+}
+
+func TestRawRejectsCRLF(t *testing.T) {
+	err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--output-format", "raw", "--definition-eol", "crlf")
+	if !strings.Contains(err.Error(), "--definition-eol=crlf needs a manifest output format") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestRawDefinitionLinePrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		def    string
+		want   string
+	}{
+		{"no prefix", "", "syntax = \"proto3\";\nmessage E {}\n", "syntax = \"proto3\";\nmessage E {}\n"},
+		{"every line", "> ", "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n}\n", "> syntax = \"proto3\";\n> message E {\n>   string id = 1;\n> }\n"},
+		{"blank lines", "| ", "a\n\nb\n", "| a\n| \n| b\n"},
+		{"no final newline", "#", "a\nb", "#a\n#b"},
+		{"single line", "--", "a\n", "--a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.outputFormat, opts.definitionLinePrefix = formatRaw, tt.prefix
+			if got := rawDefinition(opts, &schemaInput{definition: tt.def}); got != tt.want {
+				t.Errorf("rawDefinition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefinitionLinePrefixFlag(t *testing.T) {
+	out := generate(t, map[string]string{testEventFile: testEventProto}, "--output-format", "raw", "--definition-line-prefix", "proto> ")
+	got := readFile(t, filepath.Join(out, testEventSchema+rawSuffix))
+	if !strings.HasPrefix(got, rawGeneratedMarker+"\n") {
+		t.Fatalf("output lacks the generated marker:\n%s", got)
+	}
+	got = strings.TrimPrefix(got, rawGeneratedMarker+"\n")
+	for i, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+		if !strings.HasPrefix(line, "proto> ") {
+			t.Errorf("line %d %q lacks the prefix", i+1, line)
+		}
+	}
+	if want := strings.Count(testEventProto, "\n"); strings.Count(got, "\n") != want {
+		t.Errorf("%d lines, want %d:\n%s", strings.Count(got, "\n"), want, got)
+	}
+	for _, format := range []string{formatConfigConnector, formatCrossplane} {
+		err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--output-format", format, "--definition-line-prefix", "proto> ")
+		if !strings.Contains(err.Error(), "--definition-line-prefix requires --output-format=raw") {
+			t.Errorf("%s: error = %v", format, err)
+		}
+	}
+}
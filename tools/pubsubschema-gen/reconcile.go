@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unreconciledFiles lists the manifests in p's output directory that the
+// current inputs do not produce: every YAML file (and, in raw mode, every
+// file with the raw suffix) other than the planned manifests and the index.
+// Unlike pruning, this does not rely on the generated suffixes, so it also
+// finds schemas that were written by hand or by other tools.
+func (p *plan) unreconciledFiles(opts options) ([]string, error) {
+	entries, err := os.ReadDir(p.outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	expected := make(map[string]bool)
+	for _, f := range append(p.manifests(), p.keptFiles()...) {
+		expected[f.name] = true
+	}
+	if index := opts.indexName(); index != "" {
+		expected[index] = true
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || expected[name] {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, ".yaml"), strings.HasSuffix(name, ".yml"), strings.HasSuffix(name, opts.outputSuffix()):
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// reconcile reports the unreconciled files of p's output directory and, with
+// --apply, removes them. Nothing is generated in this mode.
+func reconcile(p *plan, opts options) error {
+	files, err := p.unreconciledFiles(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reconcile %s: %d file(s) without a current input\n", p.outputDir, len(files))
+	for _, name := range files {
+		path := filepath.Join(p.outputDir, name)
+		if !opts.reconcileApply {
+			fmt.Printf("Unreconciled %s\n", path)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", path)
+	}
+	return nil
+}
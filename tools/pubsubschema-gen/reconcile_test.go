@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	extra := map[string]string{
+		"a-v1-stale.schema.yaml": generatedMarker + "\napiVersion: v1\n",
+		"hand-made.yaml":         "apiVersion: v1\n",
+		"other-tool.yml":         "apiVersion: v1\n",
+		"notes.txt":              "not a manifest\n",
+		".hidden.yaml":           "apiVersion: v1\n",
+		"nested/inner.yaml":      "apiVersion: v1\n",
+		"a-v1-old.schema.proto":  "syntax = \"proto3\";\n",
+	}
+	tests := []struct {
+		name  string
+		args  []string
+		apply bool
+		want  []string
+	}{
+		{
+			name: "list",
+			want: []string{"a-v1-stale.schema.yaml", "hand-made.yaml", "other-tool.yml"},
+		},
+		{
+			name:  "apply",
+			apply: true,
+			want:  []string{"a-v1-stale.schema.yaml", "hand-made.yaml", "other-tool.yml"},
+		},
+		{
+			name: "raw",
+			args: []string{"--output-format", "raw"},
+			want: []string{"a-v1-old.schema.proto", "a-v1-stale.schema.yaml", "hand-made.yaml", "other-tool.yml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			writeTree(t, in, map[string]string{testEventFile: testEventProto})
+			if _, stderr, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", out}, tt.args...)...); err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			writeTree(t, out, extra)
+			before := readTree(t, out)
+			args := append([]string{"--pubsub-dir", in, "--output-dir", out, "--reconcile"}, tt.args...)
+			if tt.apply {
+				args = append(args, "--apply")
+			}
+			stdout, stderr, err := runTool(t, args...)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			verb := "Unreconciled "
+			if tt.apply {
+				verb = "Removed "
+			}
+			var listed []string
+			for _, line := range strings.Split(stdout, "\n") {
+				if name, ok := strings.CutPrefix(line, verb+out+string(filepath.Separator)); ok {
+					listed = append(listed, name)
+				}
+			}
+			if !reflect.DeepEqual(listed, tt.want) {
+				t.Errorf("listed %v, want %v\n%s", listed, tt.want, stdout)
+			}
+			if want := "Reconcile " + out + ": " + strconv.Itoa(len(tt.want)) + " file(s) without a current input\n"; !strings.HasPrefix(stdout, want) {
+				t.Errorf("stdout does not start with %q:\n%s", want, stdout)
+			}
+			after := readTree(t, out)
+			for name, data := range before {
+				gone := false
+				for _, w := range tt.want {
+					gone = gone || w == name
+				}
+				if _, ok := after[name]; ok == (tt.apply && gone) {
+					t.Errorf("%s present = %v after reconcile (apply %v)", name, ok, tt.apply)
+				} else if ok && after[name] != data {
+					t.Errorf("%s was modified", name)
+				}
+			}
+			if len(after) > len(before) {
+				t.Errorf("reconcile wrote files: %d before, %d after", len(before), len(after))
+			}
+		})
+	}
+}
+
+func TestReconcileWithoutInputs(t *testing.T) {
+	tests := []struct {
+		empty string
+		apply bool
+	}{
+		{emptySkip, false},
+		{emptySkip, true},
+		{emptyWrite, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.empty+" apply "+strconv.FormatBool(tt.apply), func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			writeTree(t, in, map[string]string{testEventFile: testEventProto})
+			if _, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out); err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			writeTree(t, out, map[string]string{"hand-made.yaml": "apiVersion: v1\n"})
+			if err := os.Remove(filepath.Join(in, testEventFile)); err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"--pubsub-dir", in, "--output-dir", out, "--reconcile", "--empty-kustomization", tt.empty}
+			if tt.apply {
+				args = append(args, "--apply")
+			}
+			stdout, stderr, err := runTool(t, args...)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			if want := "Reconcile " + out + ": 2 file(s) without a current input\n"; !strings.HasPrefix(stdout, want) {
+				t.Errorf("stdout does not start with %q:\n%s", want, stdout)
+			}
+			want := []string{testEventSchema + ".schema.yaml", "hand-made.yaml", "kustomization.yaml"}
+			if tt.apply {
+				want = []string{"kustomization.yaml"}
+			}
+			if got := generatedFiles(t, out); !reflect.DeepEqual(got, want) {
+				t.Errorf("output dir = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReconcileMissingOutputDir(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	stdout, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--reconcile", "--apply")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	if want := "Reconcile " + out + ": 0 file(s) without a current input\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("reconcile created %s: %v", out, err)
+	}
+}
+
+func TestReconcileFlagErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"--apply"}, "--apply requires --reconcile"},
+		{[]string{"--reconcile", "--dry-run"}, "--reconcile cannot be combined with --dry-run, --batch-size or --state-file"},
+		{[]string{"--reconcile", "--batch-size", "2"}, "--reconcile cannot be combined"},
+		{[]string{"--reconcile", "--state-file", "state.json"}, "--reconcile cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			err := generateErr(t, map[string]string{testEventFile: testEventProto}, tt.args...)
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
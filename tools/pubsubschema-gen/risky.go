@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// generatedMarker heads every generated schema, topic and subscription
+// manifest. Its absence on a file that is about to be pruned suggests the
+// file was written by hand.
+const generatedMarker = "# Code generated by pubsubschema-gen. DO NOT EDIT."
+
+// hasGeneratedMarker reports whether the first line of path is marker.
+func hasGeneratedMarker(path, marker string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return strings.TrimRight(line, "\r\n") == marker, nil
+}
+
+// splitRiskyRemovals separates the stale files without the generated marker
+// of the output format from the routine removals.
+func (p *plan) splitRiskyRemovals(opts options) (routine, risky []string, err error) {
+	for _, name := range p.stale {
+		marked, err := hasGeneratedMarker(filepath.Join(p.outputDir, name), opts.outputMarker())
+		if err != nil {
+			return nil, nil, err
+		}
+		if marked {
+			routine = append(routine, name)
+		} else {
+			risky = append(risky, name)
+		}
+	}
+	return routine, risky, nil
+}
+
+// printRemovals lists the planned removals from the output directory, with
+// the risky ones in a section of their own and counted on stderr.
+func (p *plan) printRemovals(w io.Writer, opts options) error {
+	routine, risky, err := p.splitRiskyRemovals(opts)
+	if err != nil {
+		return err
+	}
+	for _, name := range routine {
+		fmt.Fprintf(w, "Would remove %s\n", filepath.Join(p.outputDir, name))
+	}
+	if len(risky) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "Risky removals (no %q marker, likely hand-authored):\n", opts.outputMarker())
+	for _, name := range risky {
+		fmt.Fprintf(w, "Would remove %s\n", filepath.Join(p.outputDir, name))
+	}
+	fmt.Fprintf(os.Stderr, "warning: %d file(s) to be removed from %s lack the generated marker\n", len(risky), p.outputDir)
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHasGeneratedMarker(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     bool
+	}{
+		{"marked", generatedMarker + "\napiVersion: v1\n", true},
+		{"marker only", generatedMarker, true},
+		{"crlf", generatedMarker + "\r\napiVersion: v1\r\n", true},
+		{"unmarked", "apiVersion: v1\n", false},
+		{"empty", "", false},
+		{"marker not first", "# Hand-written.\n" + generatedMarker + "\n", false},
+		{"indented marker", " " + generatedMarker + "\n", false},
+		{"other marker", rawGeneratedMarker + "\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.schema.yaml")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := hasGeneratedMarker(path, generatedMarker)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("hasGeneratedMarker = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := hasGeneratedMarker(filepath.Join(t.TempDir(), "missing.schema.yaml"), generatedMarker); err == nil {
+		t.Error("hasGeneratedMarker succeeded on a missing file")
+	}
+}
+
+func TestGeneratedFilesAreMarked(t *testing.T) {
+	src := "// +consumers: billing\n" + testEventProto
+	tests := []struct {
+		args   []string
+		file   string
+		marker string
+	}{
+		{nil, testEventSchema + ".schema.yaml", generatedMarker},
+		{[]string{"--definition-mode", "configmap"}, testEventSchema + ".schema.yaml", generatedMarker},
+		{[]string{"--emit-topics"}, testEventSchema + topicSuffix, generatedMarker},
+		{[]string{"--bundle"}, testEventSchema + "-billing" + subscriptionSuffix, generatedMarker},
+		{[]string{"--output-format", "raw"}, testEventSchema + rawSuffix, rawGeneratedMarker},
+	}
+	for _, tt := range tests {
+		out := generate(t, map[string]string{testEventFile: src}, tt.args...)
+		marked, err := hasGeneratedMarker(filepath.Join(out, tt.file), tt.marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !marked {
+			t.Errorf("%v: %s lacks the marker", tt.args, tt.file)
+		}
+	}
+}
+
+func TestRiskyRemovals(t *testing.T) {
+	stale := map[string]string{
+		"a-v1-gone.schema.yaml":  generatedMarker + "\napiVersion: v1\n",
+		"a-v1-hand.schema.yaml":  "# Maintained by the data team.\napiVersion: v1\n",
+		"a-v1-copy.schema.yaml":  "apiVersion: v1\n",
+		"a-v1-kept.yaml":         "apiVersion: v1\n",
+		"a-v1-gone.schema.proto": rawGeneratedMarker + "\nsyntax = \"proto3\";\n",
+		"a-v1-hand.schema.proto": "syntax = \"proto3\";\n",
+		"a-v1-gone.topic.yaml":   generatedMarker + "\napiVersion: v1\n",
+		"a-v1-hand.topic.yaml":   "apiVersion: v1\n",
+	}
+	tests := []struct {
+		name        string
+		args        []string
+		wantRoutine []string
+		wantRisky   []string
+	}{
+		{
+			name:        "manifests",
+			wantRoutine: []string{"a-v1-gone.schema.yaml"},
+			wantRisky:   []string{"a-v1-copy.schema.yaml", "a-v1-hand.schema.yaml"},
+		},
+		{
+			name:        "topics",
+			args:        []string{"--emit-topics"},
+			wantRoutine: []string{"a-v1-gone.schema.yaml", "a-v1-gone.topic.yaml"},
+			wantRisky:   []string{"a-v1-copy.schema.yaml", "a-v1-hand.schema.yaml", "a-v1-hand.topic.yaml"},
+		},
+		{
+			name:        "raw outputs",
+			args:        []string{"--output-format", "raw"},
+			wantRoutine: []string{"a-v1-gone.schema.proto"},
+			wantRisky:   []string{"a-v1-hand.schema.proto"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			writeTree(t, in, map[string]string{testEventFile: testEventProto})
+			writeTree(t, out, stale)
+			args := append([]string{"--pubsub-dir", in, "--output-dir", out, "--dry-run"}, tt.args...)
+			stdout, stderr, err := runTool(t, args...)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			routine, risky, found := stdout, "", false
+			if i := strings.Index(stdout, "Risky removals"); i >= 0 {
+				routine, risky, found = stdout[:i], stdout[i:], true
+			}
+			if found != (len(tt.wantRisky) > 0) {
+				t.Errorf("risky section present = %v, want %v:\n%s", found, len(tt.wantRisky) > 0, stdout)
+			}
+			check := func(section, text string, want []string) {
+				var got []string
+				for _, line := range strings.Split(text, "\n") {
+					if name, ok := strings.CutPrefix(line, "Would remove "+out+string(filepath.Separator)); ok {
+						got = append(got, name)
+					}
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("%s removals = %v, want %v", section, got, want)
+				}
+			}
+			check("routine", routine, tt.wantRoutine)
+			check("risky", risky, tt.wantRisky)
+			warned := strings.Contains(stderr, "file(s) to be removed from "+out+" lack the generated marker")
+			if warned != found {
+				t.Errorf("risky warning = %v, want %v; stderr:\n%s", warned, found, stderr)
+			}
+			// A dry run removes nothing, risky or not.
+			for name := range stale {
+				if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+					t.Errorf("dry run removed %s: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Rule IDs are stable; they appear in --rules-report output.
+const (
+	ruleNameCollision      = "name-collision"
+	ruleForbidFieldTypes   = "forbid-field-type"
+	ruleImportsResolve     = "imports-resolve"
+	ruleMaxDefinitionLines = "max-definition-lines"
+	ruleUniqueDefinitions  = "unique-definitions"
+	ruleMessageMatchesFile = "message-name-matches-file"
+	ruleFieldComments      = "field-comments"
+	ruleFieldNumbers       = "field-numbers"
+	ruleProto3Syntax       = "proto3-syntax"
+)
+
+type ruleInfo struct {
+	id          string
+	description string
+	enabled     func(opts options) bool
+}
+
+// rules lists every validation rule the generator knows about, in report order.
+var rules = []ruleInfo{
+	{
+		id:          ruleNameCollision,
+		description: "Each input derives a unique schema name.",
+		enabled:     func(options) bool { return true },
+	},
+	{
+		id:          ruleForbidFieldTypes,
+		description: "No message field uses a type listed in --forbid-field-type.",
+		enabled:     func(opts options) bool { return len(opts.forbiddenFieldTypes) > 0 },
+	},
+	{
+		id:          ruleImportsResolve,
+		description: "Every non-well-known import resolves under a --proto-root.",
+		enabled:     func(opts options) bool { return len(opts.protoRoots) > 0 },
+	},
+	{
+		id:          ruleMaxDefinitionLines,
+		description: "Definitions are no longer than --max-definition-lines.",
+		enabled:     func(opts options) bool { return opts.maxDefinitionLines > 0 },
+	},
+	{
+		id:          ruleUniqueDefinitions,
+		description: "No two inputs produce byte-identical definitions.",
+		enabled:     func(opts options) bool { return opts.failOnDuplicateDefinition },
+	},
+	{
+		id:          ruleMessageMatchesFile,
+		description: "A top-level message is named after the input file.",
+		enabled:     func(opts options) bool { return opts.requireMessageMatchesFile },
+	},
+	{
+		id:          ruleFieldComments,
+		description: "Every field of a top-level message (and, with --require-field-comments-nested, of nested messages) has a preceding comment.",
+		enabled:     func(opts options) bool { return opts.requireFieldComments },
+	},
+	{
+		id:          ruleFieldNumbers,
+		description: "Top-level message fields have unique, positive field numbers outside the reserved 19000-19999 range.",
+		enabled:     func(opts options) bool { return opts.validateFieldNumbers },
+	},
+	{
+		id:          ruleProto3Syntax,
+		description: "The definition declares syntax = \"proto3\" (with --fix, a missing syntax statement is added).",
+		enabled:     func(opts options) bool { return opts.enforceProto3 },
+	},
+}
+
+// ruleCount counts the outcomes of one rule. A violation that the
+// configured strategy worked around, such as a name collision under
+// --name-collision=suffix, counts as resolved rather than failed.
+type ruleCount struct {
+	passed, failed, resolved int
+}
+
+// ruleTracker counts rule outcomes during a run. It is safe for concurrent
+// use. A nil tracker is valid and records nothing.
+type ruleTracker struct {
+	mu     sync.Mutex
+	counts map[string]*ruleCount
+}
+
+func newRuleTracker() *ruleTracker {
+	return &ruleTracker{counts: make(map[string]*ruleCount)}
+}
+
+// record counts err as a pass or failure of rule id and returns err unchanged.
+func (t *ruleTracker) record(id string, err error) error {
+	if t == nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.counts[id]
+	if c == nil {
+		c = &ruleCount{}
+		t.counts[id] = c
+	}
+	if err != nil {
+		c.failed++
+	} else {
+		c.passed++
+	}
+	return err
+}
+
+// resolve counts a violation of rule id that did not fail the run.
+func (t *ruleTracker) resolve(id string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.counts[id]
+	if c == nil {
+		c = &ruleCount{}
+		t.counts[id] = c
+	}
+	c.resolved++
+}
+
+type ruleReportEntry struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Passed      int    `json:"passed"`
+	Failed      int    `json:"failed"`
+	Resolved    int    `json:"resolved"`
+}
+
+func (t *ruleTracker) report(opts options) []ruleReportEntry {
+	entries := make([]ruleReportEntry, 0, len(rules))
+	for _, r := range rules {
+		e := ruleReportEntry{ID: r.id, Description: r.description, Enabled: r.enabled(opts)}
+		if c := t.counts[r.id]; c != nil {
+			e.Passed, e.Failed, e.Resolved = c.passed, c.failed, c.resolved
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func writeRulesReport(path string, t *ruleTracker, opts options) error {
+	data, err := json.MarshalIndent(t.report(opts), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data)+"\n")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestRulesReport(t *testing.T) {
+	inputs := map[string]string{
+		testEventFile: testEventProto,
+		"coreapp.other.v1.OtherEvent.pubsub.proto": "syntax = \"proto3\";\nmessage OtherEvent {\n  google.protobuf.Any payload = 1;\n}\n",
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		want    map[string]ruleReportEntry
+	}{
+		{
+			name: "defaults",
+			want: map[string]ruleReportEntry{
+				ruleNameCollision:      {Enabled: true, Passed: 2},
+				ruleForbidFieldTypes:   {},
+				ruleMaxDefinitionLines: {},
+			},
+		},
+		{
+			name: "line limit",
+			args: []string{"--max-definition-lines", "100"},
+			want: map[string]ruleReportEntry{
+				ruleNameCollision:      {Enabled: true, Passed: 2},
+				ruleMaxDefinitionLines: {Enabled: true, Passed: 2},
+			},
+		},
+		{
+			name:    "failing rule",
+			args:    []string{"--forbid-field-type", "google.protobuf.Any"},
+			wantErr: true,
+			want: map[string]ruleReportEntry{
+				// The run stops at the first failing input, which sorts first.
+				ruleForbidFieldTypes:   {Enabled: true, Failed: 1},
+				ruleMaxDefinitionLines: {},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "pubsub")
+			writeTree(t, in, inputs)
+			report := filepath.Join(t.TempDir(), "rules.json")
+			args := append([]string{"--pubsub-dir", in, "--output-dir", t.TempDir(), "--rules-report", report}, tt.args...)
+			if _, _, err := runTool(t, args...); (err != nil) != tt.wantErr {
+				t.Fatalf("run error = %v, want error %v", err, tt.wantErr)
+			}
+			var entries []ruleReportEntry
+			if err := json.Unmarshal([]byte(readFile(t, report)), &entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(rules) {
+				t.Errorf("report has %d entries, want one per rule (%d)", len(entries), len(rules))
+			}
+			byID := make(map[string]ruleReportEntry)
+			for i, e := range entries {
+				if i < len(rules) && e.ID != rules[i].id {
+					t.Errorf("entry %d is %s, want %s", i, e.ID, rules[i].id)
+				}
+				if e.Description == "" {
+					t.Errorf("%s has no description", e.ID)
+				}
+				e.ID, e.Description = "", ""
+				byID[rules[i].id] = e
+			}
+			for id, want := range tt.want {
+				if got := byID[id]; got != want {
+					t.Errorf("%s = %+v, want %+v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRulesReportNameCollision(t *testing.T) {
+	// Both files sanitize to the same schema name.
+	inputs := map[string]string{
+		"a.v1.Event.pubsub.proto": "syntax = \"proto3\";\nmessage Event {}\n",
+		"a.v1.event.pubsub.proto": "syntax = \"proto3\";\nmessage event {}\n",
+	}
+	tests := []struct {
+		strategy string
+		wantErr  bool
+		want     ruleReportEntry
+	}{
+		{collisionError, true, ruleReportEntry{Enabled: true, Passed: 1, Failed: 1}},
+		{collisionSuffix, false, ruleReportEntry{Enabled: true, Passed: 1, Resolved: 1}},
+		{collisionSkip, false, ruleReportEntry{Enabled: true, Passed: 1, Resolved: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			in := filepath.Join(t.TempDir(), "pubsub")
+			writeTree(t, in, inputs)
+			report := filepath.Join(t.TempDir(), "rules.json")
+			_, _, err := runTool(t, "--pubsub-dir", in, "--output-dir", t.TempDir(), "--rules-report", report, "--name-collision", tt.strategy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run error = %v, want error %v", err, tt.wantErr)
+			}
+			var entries []ruleReportEntry
+			if err := json.Unmarshal([]byte(readFile(t, report)), &entries); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.ID != ruleNameCollision {
+					continue
+				}
+				e.ID, e.Description = "", ""
+				if e != tt.want {
+					t.Errorf("%s = %+v, want %+v", ruleNameCollision, e, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRuleTrackerNil(t *testing.T) {
+	var tracker *ruleTracker
+	tracker.resolve(ruleNameCollision)
+	if err := tracker.record(ruleNameCollision, nil); err != nil {
+		t.Errorf("nil tracker record = %v", err)
+	}
+}
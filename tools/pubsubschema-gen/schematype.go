@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultSchemaType is the schema type of every input not matched by
+// --type-for-message.
+const defaultSchemaType = "PROTOCOL_BUFFER"
+
+var schemaTypes = []string{"PROTOCOL_BUFFER", "AVRO"}
+
+func validSchemaType(t string) bool {
+	for _, v := range schemaTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTypeForMessage turns --type-for-message name=TYPE values into a map
+// from message name to schema type. A name given twice is an error.
+func parseTypeForMessage(values []string) (map[string]string, error) {
+	types := make(map[string]string, len(values))
+	for _, v := range values {
+		name, typ, ok := strings.Cut(v, "=")
+		name, typ = strings.TrimSpace(name), strings.TrimSpace(typ)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q: want message=TYPE", v)
+		}
+		if !validSchemaType(typ) {
+			return nil, fmt.Errorf("%q: invalid type %q: want %s", v, typ, strings.Join(schemaTypes, " or "))
+		}
+		if _, dup := types[strings.TrimPrefix(name, ".")]; dup {
+			return nil, fmt.Errorf("message %s given more than once", name)
+		}
+		types[strings.TrimPrefix(name, ".")] = typ
+	}
+	return types, nil
+}
+
+// schemaTypeFor returns the --type-for-message type of in's top-level
+// messages, matched by simple or package-qualified name, or the default.
+// Top-level messages mapped to different types are an error.
+func schemaTypeFor(in *schemaInput, opts options) (string, error) {
+	if len(opts.typeForMessage) == 0 {
+		return defaultSchemaType, nil
+	}
+	pf, err := in.proto()
+	if err != nil {
+		return "", err
+	}
+	pkg := pf.pkg
+	if pkg == "" {
+		pkg = in.strippedPackage
+	}
+	matched := make(map[string]string)
+	for _, m := range pf.messages {
+		typ, ok := opts.typeForMessage[m.name]
+		if !ok && pkg != "" {
+			typ, ok = opts.typeForMessage[pkg+"."+m.name]
+		}
+		if ok {
+			matched[typ] = m.name
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return defaultSchemaType, nil
+	case 1:
+		for typ := range matched {
+			return typ, nil
+		}
+	}
+	var conflicts []string
+	for typ, msg := range matched {
+		conflicts = append(conflicts, msg+"="+typ)
+	}
+	sort.Strings(conflicts)
+	return "", fmt.Errorf("%s: --type-for-message maps its messages to different types: %s", in.path, strings.Join(conflicts, ", "))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseTypeForMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "none", want: map[string]string{}},
+		{
+			name:   "several",
+			values: []string{"Orders=AVRO", " a.v1.Refund = PROTOCOL_BUFFER ", ".b.v1.Audit=AVRO"},
+			want:   map[string]string{"Orders": "AVRO", "a.v1.Refund": "PROTOCOL_BUFFER", "b.v1.Audit": "AVRO"},
+		},
+		{name: "no type", values: []string{"Orders"}, wantErr: `"Orders": want message=TYPE`},
+		{name: "no name", values: []string{"=AVRO"}, wantErr: `"=AVRO": want message=TYPE`},
+		{name: "invalid type", values: []string{"Orders=avro"}, wantErr: `"Orders=avro": invalid type "avro": want PROTOCOL_BUFFER or AVRO`},
+		{name: "duplicate", values: []string{"Orders=AVRO", ".Orders=AVRO"}, wantErr: "message .Orders given more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTypeForMessage(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTypeForMessage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaTypeFor(t *testing.T) {
+	types := map[string]string{"Orders": "AVRO", "a.v1.Refund": "AVRO", "Audit": "PROTOCOL_BUFFER"}
+	tests := []struct {
+		name    string
+		src     string
+		strip   string
+		types   map[string]string
+		want    string
+		wantErr string
+	}{
+		{name: "no map", src: "syntax = \"proto3\";\nmessage Orders {}\n", want: defaultSchemaType},
+		{name: "simple name", src: "syntax = \"proto3\";\npackage b.v1;\nmessage Orders {}\n", types: types, want: "AVRO"},
+		{name: "qualified name", src: "syntax = \"proto3\";\npackage a.v1;\nmessage Refund {}\n", types: types, want: "AVRO"},
+		{name: "other package", src: "syntax = \"proto3\";\npackage b.v1;\nmessage Refund {}\n", types: types, want: defaultSchemaType},
+		{name: "stripped package", src: "syntax = \"proto3\";\nmessage Refund {}\n", strip: "a.v1", types: types, want: "AVRO"},
+		{name: "unmatched", src: "syntax = \"proto3\";\nmessage Other {}\n", types: types, want: defaultSchemaType},
+		{name: "nested only", src: "syntax = \"proto3\";\nmessage Outer {\n  message Orders {}\n}\n", types: types, want: defaultSchemaType},
+		{name: "same type twice", src: "syntax = \"proto3\";\npackage a.v1;\nmessage Orders {}\nmessage Refund {}\n", types: types, want: "AVRO"},
+		{
+			name:    "conflict",
+			src:     "syntax = \"proto3\";\nmessage Orders {}\nmessage Audit {}\n",
+			types:   types,
+			wantErr: "e.pubsub.proto: --type-for-message maps its messages to different types: Audit=PROTOCOL_BUFFER, Orders=AVRO",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.typeForMessage = tt.types
+			in := &schemaInput{path: "e.pubsub.proto", definition: tt.src, strippedPackage: tt.strip}
+			got, err := schemaTypeFor(in, opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("schemaTypeFor = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeForMessage(t *testing.T) {
+	inputs := map[string]string{
+		"a.v1.Orders.pubsub.proto": "syntax = \"proto3\";\npackage a.v1;\nmessage Orders {}\n",
+		"a.v1.Refund.pubsub.proto": "syntax = \"proto3\";\npackage a.v1;\nmessage Refund {}\n",
+		"a.v1.Audit.pubsub.proto":  "syntax = \"proto3\";\npackage a.v1;\nmessage Audit {}\n",
+	}
+	out := generate(t, inputs, "--type-for-message", "Orders=AVRO", "--type-for-message", "a.v1.Refund=PROTOCOL_BUFFER")
+	for name, want := range map[string]string{"a-v1-orders": "AVRO", "a-v1-refund": "PROTOCOL_BUFFER", "a-v1-audit": defaultSchemaType} {
+		var doc struct {
+			Spec struct {
+				Type string `yaml:"type"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, name+".schema.yaml"))), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Spec.Type != want {
+			t.Errorf("%s type = %s, want %s", name, doc.Spec.Type, want)
+		}
+	}
+	err := generateErr(t, inputs, "--type-for-message", "Orders=JSON")
+	if !strings.Contains(err.Error(), `--type-for-message "Orders=JSON": invalid type "JSON"`) {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultAPIVersion = "v1beta1"
+
+// Output formats select the resource flavour the schemas are rendered as.
+const (
+	formatConfigConnector = "config-connector"
+	formatCrossplane      = "crossplane"
+	// formatRaw writes the bare definitions as <name>.schema.proto.
+	formatRaw = "raw"
+)
+
+// Defaults match the Upbound GCP provider; other Crossplane providers can be
+// targeted with --crossplane-api-version and --crossplane-kind.
+const (
+	defaultCrossplaneAPIVersion = "pubsub.gcp.upbound.io/v1beta1"
+	defaultCrossplaneKind       = "Schema"
+)
+
+// schemaShape describes how a PubSubSchema manifest is laid out for one
+// Config Connector CRD version. Field paths are relative to `spec` so versions
+// that rename or nest the definition only need a new table entry.
+type schemaShape struct {
+	apiVersion     string
+	kind           string
+	typePath       []string
+	definitionPath []string
+	// nameAnnotation, when set, is an annotation that carries the schema name to
+	// the cloud resource (Crossplane's external-name).
+	nameAnnotation string
+}
+
+var schemaShapes = map[string]schemaShape{
+	"v1beta1": {
+		apiVersion:     "pubsub.cnrm.cloud.google.com/v1beta1",
+		kind:           "PubSubSchema",
+		typePath:       []string{"type"},
+		definitionPath: []string{"definition"},
+	},
+	// v1 keeps the v1beta1 spec layout; it is listed so the group/version can be
+	// selected explicitly once the CRD is promoted.
+	"v1": {
+		apiVersion:     "pubsub.cnrm.cloud.google.com/v1",
+		kind:           "PubSubSchema",
+		typePath:       []string{"type"},
+		definitionPath: []string{"definition"},
+	},
+}
+
+// crossplaneShape lays a schema out as a Crossplane managed resource: the
+// parameters live under spec.forProvider and the schema ID is pinned through
+// the external-name annotation.
+func crossplaneShape(apiVersion, kind string) schemaShape {
+	return schemaShape{
+		apiVersion:     apiVersion,
+		kind:           kind,
+		typePath:       []string{"forProvider", "type"},
+		definitionPath: []string{"forProvider", "definition"},
+		nameAnnotation: "crossplane.io/external-name",
+	}
+}
+
+// lookupSchemaShape returns the shape for version. Unknown versions return the
+// default shape and false so the caller can warn.
+func lookupSchemaShape(version string) (schemaShape, bool) {
+	if s, ok := schemaShapes[version]; ok {
+		return s, true
+	}
+	return schemaShapes[defaultAPIVersion], false
+}
+
+// definitionIndent is the indentation of the definition literal block: two
+// spaces per nesting level below `spec`, plus one level for the block itself.
+func (s schemaShape) definitionIndent() string {
+	return strings.Repeat("  ", len(s.definitionPath)+1)
+}
+
+// definitionFormat controls how the proto definition value is rendered.
+type definitionFormat struct {
+	// eol is "lf" or "crlf". It is applied after normalizeNewlines has folded the
+	// input to LF, so it always wins over the source file's own line endings.
+	eol string
+	// indent overrides the literal block indentation; empty uses the shape's
+	// definitionIndent.
+	indent string
+	// mode is definitionInline or definitionConfigMap.
+	mode string
+}
+
+// Definition modes: inline embeds the proto in the schema resource;
+// configmap moves it into a ConfigMap that kustomize copies back in.
+const (
+	definitionInline    = "inline"
+	definitionConfigMap = "configmap"
+)
+
+// validateBlockIndent checks a --block-indent override: spaces only, and deeper
+// than the definition key so the literal block still belongs to it.
+func (s schemaShape) validateBlockIndent(indent string) error {
+	if strings.Trim(indent, " ") != "" {
+		return fmt.Errorf("--block-indent must contain only spaces, got %q", indent)
+	}
+	if keyIndent := 2 * len(s.definitionPath); len(indent) <= keyIndent {
+		return fmt.Errorf("--block-indent must be wider than %d spaces for %s", keyIndent, s.apiVersion)
+	}
+	return nil
+}
+
+// renderSpec renders the children of `spec`. Shared parent keys between the
+// type and definition paths are only emitted once.
+func (s schemaShape) renderSpec(schemaType, protoDefinition string, df definitionFormat) string {
+	var b strings.Builder
+	var prev []string
+	emitParents := func(path []string) {
+		common := 0
+		for common < len(prev)-1 && common < len(path)-1 && prev[common] == path[common] {
+			common++
+		}
+		for i := common; i < len(path)-1; i++ {
+			b.WriteString(strings.Repeat("  ", i+1) + path[i] + ":\n")
+		}
+		prev = path
+	}
+
+	emitParents(s.typePath)
+	b.WriteString(strings.Repeat("  ", len(s.typePath)) + s.typePath[len(s.typePath)-1] + ": " + schemaType + "\n")
+
+	if df.mode == definitionConfigMap {
+		// The definition is filled in from the ConfigMap by the kustomization's
+		// replacements.
+		return b.String()
+	}
+	emitParents(s.definitionPath)
+	key := strings.Repeat("  ", len(s.definitionPath)) + s.definitionPath[len(s.definitionPath)-1]
+	writeDefinitionField(&b, key, protoDefinition, df, s.definitionIndent())
+	return b.String()
+}
+
+// definitionFieldPath is the dotted path of the definition field for
+// kustomize field references.
+func (s schemaShape) definitionFieldPath() string {
+	return "spec." + strings.Join(s.definitionPath, ".")
+}
+
+// writeDefinitionField writes `key: <definition>` where key already carries
+// its indentation. defaultIndent is used for the literal block unless
+// overridden by df.indent.
+func writeDefinitionField(b *strings.Builder, key, protoDefinition string, df definitionFormat, defaultIndent string) {
+	if df.eol == "crlf" {
+		// YAML folds every line break inside a literal block to LF when parsing,
+		// so CRLF content has to be carried as escapes in a quoted scalar.
+		b.WriteString(key + ": " + yamlDoubleQuote(strings.ReplaceAll(protoDefinition, "\n", "\r\n")) + "\n")
+		return
+	}
+	b.WriteString(key + ": |\n")
+	indent := df.indent
+	if indent == "" {
+		indent = defaultIndent
+	}
+	b.WriteString(indentForYAMLLiteralBlock(protoDefinition, indent))
+}
+
+// yamlDoubleQuote renders s as a YAML double-quoted scalar, escaping
+// backslashes, quotes and control characters.
+func yamlDoubleQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
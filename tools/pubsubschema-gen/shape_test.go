@@ -0,0 +1,203 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lookupPath returns the value at path in a decoded YAML document.
+func lookupPath(t *testing.T, doc map[string]interface{}, path ...string) interface{} {
+	t.Helper()
+	var cur interface{} = doc
+	for _, key := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: not a mapping", strings.Join(path, "."))
+		}
+		if cur, ok = m[key]; !ok {
+			t.Fatalf("%s: missing", strings.Join(path, "."))
+		}
+	}
+	return cur
+}
+
+func TestAPIVersionSpecLayout(t *testing.T) {
+	tests := []struct {
+		version    string
+		apiVersion string
+		warning    bool
+	}{
+		{"v1beta1", "pubsub.cnrm.cloud.google.com/v1beta1", false},
+		{"v1", "pubsub.cnrm.cloud.google.com/v1", false},
+		{"v2alpha1", "pubsub.cnrm.cloud.google.com/v1beta1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+			writeTree(t, in, map[string]string{testEventFile: testEventProto})
+			_, stderr, err := runTool(t, "--pubsub-dir", in, "--output-dir", out, "--api-version", tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(stderr, "unknown --api-version"); got != tt.warning {
+				t.Errorf("warning printed = %v, want %v; stderr:\n%s", got, tt.warning, stderr)
+			}
+			var doc map[string]interface{}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := lookupPath(t, doc, "apiVersion"); got != tt.apiVersion {
+				t.Errorf("apiVersion = %v, want %s", got, tt.apiVersion)
+			}
+			if got := lookupPath(t, doc, "spec", "type"); got != "PROTOCOL_BUFFER" {
+				t.Errorf("spec.type = %v, want PROTOCOL_BUFFER", got)
+			}
+			if got := lookupPath(t, doc, "spec", "definition"); got != testEventProto {
+				t.Errorf("spec.definition = %q, want %q", got, testEventProto)
+			}
+		})
+	}
+}
+
+func TestRenderSpecNestedPaths(t *testing.T) {
+	shape := schemaShape{typePath: []string{"forProvider", "type"}, definitionPath: []string{"forProvider", "definition"}}
+	got := shape.renderSpec("AVRO", "x\n", definitionFormat{eol: "lf"})
+	want := "  forProvider:\n    type: AVRO\n    definition: |\n      x\n      "
+	if got != want {
+		t.Errorf("renderSpec = %q, want %q", got, want)
+	}
+}
+
+func TestDefinitionEOL(t *testing.T) {
+	crlfSource := strings.ReplaceAll(testEventProto, "\n", "\r\n")
+	tests := []struct {
+		name   string
+		eol    string
+		source string
+		want   string
+	}{
+		{"lf", "lf", testEventProto, testEventProto},
+		{"lf folds crlf source", "lf", crlfSource, testEventProto},
+		{"crlf", "crlf", testEventProto, crlfSource},
+		{"crlf keeps crlf source", "crlf", crlfSource, crlfSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: tt.source}, "--definition-eol", tt.eol)
+			data := readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))
+			if strings.Contains(data, "\r") {
+				t.Error("the manifest itself contains CR bytes; only the definition value should")
+			}
+			var doc map[string]interface{}
+			if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := lookupPath(t, doc, "spec", "definition"); got != tt.want {
+				t.Errorf("spec.definition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockIndent(t *testing.T) {
+	tests := []struct {
+		indent string
+		// args select the shape; crossplane nests the definition deeper.
+		args []string
+	}{
+		{indent: "   "},
+		{indent: "      "},
+		{indent: "        "},
+		{indent: "      ", args: []string{"--output-format", formatCrossplane}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(append([]string{strconv.Itoa(len(tt.indent))}, tt.args...), " "), func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: testEventProto}, append([]string{"--block-indent", tt.indent}, tt.args...)...)
+			data := readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))
+			block := data[strings.Index(data, "definition: |\n")+len("definition: |\n"):]
+			for _, line := range strings.Split(strings.TrimRight(block, " \n"), "\n") {
+				if line != "" && !strings.HasPrefix(line, tt.indent) {
+					t.Errorf("line %q is not indented by %d spaces", line, len(tt.indent))
+				}
+			}
+			if first := strings.SplitN(block, "\n", 2)[0]; strings.HasPrefix(first, tt.indent+" ") {
+				t.Errorf("first line %q is indented deeper than %d spaces", first, len(tt.indent))
+			}
+			var doc map[string]interface{}
+			if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+				t.Fatal(err)
+			}
+			path := []string{"spec", "definition"}
+			if len(tt.args) > 0 {
+				path = []string{"spec", "forProvider", "definition"}
+			}
+			if got := lookupPath(t, doc, path...); got != testEventProto {
+				t.Errorf("definition re-parses as %q, want %q", got, testEventProto)
+			}
+		})
+	}
+}
+
+func TestValidateBlockIndent(t *testing.T) {
+	tests := []struct {
+		shape  schemaShape
+		indent string
+		ok     bool
+	}{
+		{schemaShapes["v1beta1"], "   ", true},
+		{schemaShapes["v1beta1"], "  ", false},
+		{schemaShapes["v1beta1"], "\t\t\t", false},
+		{schemaShapes["v1beta1"], "  \t ", false},
+		{crossplaneShape(defaultCrossplaneAPIVersion, defaultCrossplaneKind), "    ", false},
+		{crossplaneShape(defaultCrossplaneAPIVersion, defaultCrossplaneKind), "     ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shape.apiVersion+" "+strconv.Quote(tt.indent), func(t *testing.T) {
+			if err := tt.shape.validateBlockIndent(tt.indent); (err == nil) != tt.ok {
+				t.Errorf("validateBlockIndent = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCrossplaneResource(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		apiVersion, kind string
+	}{
+		{"defaults", nil, defaultCrossplaneAPIVersion, defaultCrossplaneKind},
+		{"custom", []string{"--crossplane-api-version", "pubsub.gcp.example.com/v1alpha1", "--crossplane-kind", "PubSubSchema"}, "pubsub.gcp.example.com/v1alpha1", "PubSubSchema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: testEventProto}, append([]string{"--output-format", formatCrossplane}, tt.args...)...)
+			var doc map[string]interface{}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]interface{}{
+				"apiVersion": tt.apiVersion,
+				"kind":       tt.kind,
+				"metadata": map[string]interface{}{
+					"name":        testEventSchema,
+					"annotations": map[string]interface{}{"crossplane.io/external-name": testEventSchema},
+				},
+				"spec": map[string]interface{}{
+					"forProvider": map[string]interface{}{"type": "PROTOCOL_BUFFER", "definition": testEventProto},
+				},
+			}
+			if !reflect.DeepEqual(doc, want) {
+				t.Errorf("resource = %v, want %v", doc, want)
+			}
+			if got, want := kustomizationResources(t, out), []string{testEventSchema + ".schema.yaml"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("kustomization resources = %v, want %v", got, want)
+			}
+		})
+	}
+}
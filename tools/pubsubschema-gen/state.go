@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runState is the --state-file contents: the generator configuration and,
+// per input, its digest and the files it produced in --output-dir.
+type runState struct {
+	// Config fingerprints the flags of the run; any change regenerates
+	// everything.
+	Config string                `json:"config"`
+	Inputs map[string]stateInput `json:"inputs"`
+}
+
+type stateInput struct {
+	Digest string      `json:"digest"`
+	Schema string      `json:"schema"`
+	Files  []stateFile `json:"files"`
+}
+
+type stateFile struct {
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+}
+
+// loadState reads the state file. A missing file is an empty state.
+func loadState(path string) (*runState, error) {
+	st := &runState{Inputs: make(map[string]stateInput)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	if st.Inputs == nil {
+		st.Inputs = make(map[string]stateInput)
+	}
+	return st, nil
+}
+
+// saveState replaces the state file atomically, so an interrupted run leaves
+// the previous state in place.
+func saveState(path string, st *runState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// stateConfig fingerprints the flag values that shape the output, so a run
+// with different settings doesn't trust files generated with the old ones.
+func stateConfig(flags map[string]string, algo string) string {
+	keys := make([]string, 0, len(flags))
+	for k := range flags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + flags[k] + "\n")
+	}
+	return hashHex(algo, []byte(b.String()))
+}
+
+// unchangedInputs splits inputs into those to regenerate and those whose
+// digest matches the previous run (under the same configuration) and whose
+// recorded files are all still present. Every input is hashed, since that is
+// how changes are detected.
+func unchangedInputs(inputs []*schemaInput, st *runState, opts options) (changed []*schemaInput, kept map[string]stateInput, err error) {
+	kept = make(map[string]stateInput)
+	for _, in := range inputs {
+		raw, err := opts.readInput(in.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		in.digest = hashHex(opts.hashAlgo, raw)
+		prev, ok := st.Inputs[in.rel]
+		if ok && st.Config == opts.stateConfig && prev.Digest == in.digest && prev.Schema == in.name && filesExist(opts.outputDir, prev.Files) {
+			kept[in.rel] = prev
+			continue
+		}
+		changed = append(changed, in)
+	}
+	return changed, kept, nil
+}
+
+func filesExist(dir string, files []stateFile) bool {
+	for _, f := range files {
+		if st, err := os.Stat(filepath.Join(dir, f.Name)); err != nil || !st.Mode().IsRegular() {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// nextState records the inputs of this run: kept inputs carry their previous
+// entry, regenerated ones the files just planned for them.
+func (p *plan) nextState(opts options) *runState {
+	st := &runState{Config: opts.stateConfig, Inputs: make(map[string]stateInput)}
+	for rel, prev := range p.kept {
+		st.Inputs[rel] = prev
+	}
+	files := make(map[string][]stateFile)
+	for _, f := range p.manifests() {
+		files[f.schemaName] = append(files[f.schemaName], stateFile{Name: f.name, Group: f.group})
+	}
+	for _, in := range p.regenerated {
+		st.Inputs[in.rel] = stateInput{Digest: in.digest, Schema: in.name, Files: files[in.name]}
+	}
+	return st
+}
+
+// keptFiles returns the files of unchanged inputs, which stay in the output
+// directory and the index without being rewritten.
+func (p *plan) keptFiles() []plannedFile {
+	var files []plannedFile
+	for _, in := range p.kept {
+		for _, f := range in.Files {
+			files = append(files, plannedFile{name: f.Name, schemaName: in.Schema, group: f.Group})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files
+}
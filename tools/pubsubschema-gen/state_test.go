@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// wroteSchemas returns the schema names a run reported writing, sorted.
+func wroteSchemas(stdout string) []string {
+	var names []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if rest, ok := strings.CutPrefix(line, "Wrote "); ok {
+			name, _, _ := strings.Cut(rest, " -> ")
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestStateFileAcrossRuns(t *testing.T) {
+	message := func(name, body string) string {
+		return "syntax = \"proto3\";\nmessage " + name + " {" + body + "}\n"
+	}
+	in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+	state := filepath.Join(t.TempDir(), "state", "state.json")
+	args := []string{"--pubsub-dir", in, "--output-dir", out, "--state-file", state}
+	steps := []struct {
+		name   string
+		write  map[string]string
+		remove []string
+		args   []string
+		wrote  []string
+	}{
+		{
+			name:  "first run",
+			write: map[string]string{"a.v1.A.pubsub.proto": message("A", ""), "a.v1.B.pubsub.proto": message("B", ""), "a.v1.C.pubsub.proto": message("C", "")},
+			wrote: []string{"a-v1-a", "a-v1-b", "a-v1-c"},
+		},
+		{
+			name:   "add, modify and delete",
+			write:  map[string]string{"a.v1.B.pubsub.proto": message("B", " string id = 1; "), "a.v1.D.pubsub.proto": message("D", "")},
+			remove: []string{"a.v1.C.pubsub.proto"},
+			wrote:  []string{"a-v1-b", "a-v1-d"},
+		},
+		{name: "unchanged", wrote: nil},
+		{name: "other flags", args: []string{"--comments", "none"}, wrote: []string{"a-v1-a", "a-v1-b", "a-v1-d"}},
+	}
+	for _, step := range steps {
+		writeTree(t, in, step.write)
+		for _, name := range step.remove {
+			if err := os.Remove(filepath.Join(in, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stdout, _, err := runTool(t, append(args, step.args...)...)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := wroteSchemas(stdout); !reflect.DeepEqual(got, step.wrote) {
+			t.Errorf("%s: regenerated %v, want %v", step.name, got, step.wrote)
+		}
+		// Whatever was skipped, the output matches a full run.
+		full := t.TempDir()
+		if _, _, err := runTool(t, append([]string{"--pubsub-dir", in, "--output-dir", full}, step.args...)...); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := readTree(t, out), readTree(t, full); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: output = %v, want %v", step.name, generatedFiles(t, out), generatedFiles(t, full))
+		}
+
+		var st runState
+		if err := json.Unmarshal([]byte(readFile(t, state)), &st); err != nil {
+			t.Fatal(err)
+		}
+		sources := readTree(t, in)
+		if len(st.Inputs) != len(sources) {
+			t.Errorf("%s: state records %d inputs, want %d", step.name, len(st.Inputs), len(sources))
+		}
+		for rel, src := range sources {
+			entry := st.Inputs[rel]
+			if entry.Digest != hashHex("sha256", []byte(src)) || !reflect.DeepEqual(entry.Files, []stateFile{{Name: entry.Schema + ".schema.yaml"}}) {
+				t.Errorf("%s: state for %s = %+v", step.name, rel, entry)
+			}
+		}
+	}
+	if entries, err := os.ReadDir(filepath.Dir(state)); err != nil || len(entries) != 1 {
+		t.Errorf("state directory holds %d entries (%v), want only the state file", len(entries), err)
+	}
+}
+
+func TestStateFileRegeneratesMissingOutputs(t *testing.T) {
+	in, out := filepath.Join(t.TempDir(), "pubsub"), t.TempDir()
+	writeTree(t, in, map[string]string{testEventFile: testEventProto})
+	args := []string{"--pubsub-dir", in, "--output-dir", out, "--state-file", filepath.Join(t.TempDir(), "state.json")}
+	if _, _, err := runTool(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(out, testEventSchema+".schema.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err := runTool(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wroteSchemas(stdout); !reflect.DeepEqual(got, []string{testEventSchema}) {
+		t.Errorf("regenerated %v, want the input whose output went missing", got)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	dir := t.TempDir()
+	st, err := loadState(filepath.Join(dir, "missing.json"))
+	if err != nil || st.Config != "" || st.Inputs == nil || len(st.Inputs) != 0 {
+		t.Errorf("missing state file = %+v, %v; want an empty state", st, err)
+	}
+	writeTree(t, dir, map[string]string{"broken.json": "{"})
+	if _, err := loadState(filepath.Join(dir, "broken.json")); err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "broken.json")+": ") {
+		t.Errorf("broken state file error = %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	topicSuffix     = ".topic.yaml"
+	topicAPIVersion = "pubsub.cnrm.cloud.google.com/v1beta1"
+)
+
+// Topic message encodings accepted by PubSubTopic schemaSettings.
+var topicEncodings = []string{"JSON", "BINARY"}
+
+func validTopicEncoding(enc string) bool {
+	for _, e := range topicEncodings {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// directive returns the single value of directive key, or "" when it is
+// absent. Repeating it with different values is an error.
+func (in *schemaInput) directive(key string) (string, error) {
+	values := in.directives[key]
+	if len(values) == 0 {
+		return "", nil
+	}
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return "", fmt.Errorf("%s: conflicting +%s directives %q and %q", in.path, key, values[0], v)
+		}
+	}
+	return values[0], nil
+}
+
+// topicEncoding is the +encoding directive of in, defaulting to
+// --topic-encoding.
+func topicEncoding(in *schemaInput, opts options) (string, error) {
+	enc, err := in.directive("encoding")
+	if err != nil {
+		return "", err
+	}
+	if enc == "" {
+		return opts.topicEncoding, nil
+	}
+	if !validTopicEncoding(enc) {
+		return "", fmt.Errorf("%s: invalid +encoding %q: want %s", in.path, enc, strings.Join(topicEncodings, " or "))
+	}
+	return enc, nil
+}
+
+// topicManifest renders a PubSubTopic that publishes in's schema. The topic
+// shares the schema's name and labels.
+func topicManifest(in *schemaInput, encoding string) string {
+	var b strings.Builder
+	b.WriteString(generatedMarker + "\n")
+	b.WriteString("apiVersion: " + topicAPIVersion + "\n")
+	b.WriteString("kind: PubSubTopic\n")
+	b.WriteString("metadata:\n")
+	b.WriteString("  name: " + in.name + "\n")
+	writeYAMLMap(&b, "  ", "labels", in.labels)
+	b.WriteString("spec:\n")
+	b.WriteString("  schemaSettings:\n")
+	b.WriteString("    schemaRef:\n")
+	b.WriteString("      name: " + in.name + "\n")
+	b.WriteString("    encoding: " + encoding + "\n")
+	return b.String()
+}
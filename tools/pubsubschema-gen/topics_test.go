@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// topicDoc is the part of a generated PubSubTopic the tests look at.
+type topicDoc struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		SchemaSettings struct {
+			SchemaRef struct {
+				Name string `yaml:"name"`
+			} `yaml:"schemaRef"`
+			Encoding string `yaml:"encoding"`
+		} `yaml:"schemaSettings"`
+	} `yaml:"spec"`
+}
+
+func readTopic(t *testing.T, dir, name string) topicDoc {
+	t.Helper()
+	var doc topicDoc
+	if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(dir, name+topicSuffix))), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestTopicEncoding(t *testing.T) {
+	withDirective := func(enc string) string {
+		return "// +encoding: " + enc + "\n" + testEventProto
+	}
+	tests := []struct {
+		name    string
+		src     string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"default", testEventProto, nil, "JSON", ""},
+		{"flag default", testEventProto, []string{"--topic-encoding", "BINARY"}, "BINARY", ""},
+		{"directive", withDirective("BINARY"), nil, "BINARY", ""},
+		{"directive overrides flag", withDirective("JSON"), []string{"--topic-encoding", "BINARY"}, "JSON", ""},
+		{"repeated directive", "// +encoding: BINARY\n" + withDirective("BINARY"), nil, "BINARY", ""},
+		{"invalid directive", withDirective("AVRO"), nil, "", `invalid +encoding "AVRO": want JSON or BINARY`},
+		{"conflicting directives", "// +encoding: JSON\n" + withDirective("BINARY"), nil, "", `conflicting +encoding directives "JSON" and "BINARY"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := map[string]string{testEventFile: tt.src}
+			args := append([]string{"--emit-topics"}, tt.args...)
+			if tt.wantErr != "" {
+				err := generateErr(t, inputs, args...)
+				if !strings.Contains(err.Error(), testEventFile) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			topic := readTopic(t, generate(t, inputs, args...), testEventSchema)
+			if topic.Kind != "PubSubTopic" || topic.Metadata.Name != testEventSchema || topic.Spec.SchemaSettings.SchemaRef.Name != testEventSchema {
+				t.Errorf("topic = %+v, want one named after and referencing %s", topic, testEventSchema)
+			}
+			if got := topic.Spec.SchemaSettings.Encoding; got != tt.want {
+				t.Errorf("encoding = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopicEncodingIsPerFile(t *testing.T) {
+	out := generate(t, map[string]string{
+		"a.v1.Binary.pubsub.proto": "// +encoding: BINARY\nsyntax = \"proto3\";\nmessage Binary {}\n",
+		"a.v1.Plain.pubsub.proto":  "syntax = \"proto3\";\nmessage Plain {}\n",
+	}, "--emit-topics")
+	for name, want := range map[string]string{"a-v1-binary": "BINARY", "a-v1-plain": "JSON"} {
+		if got := readTopic(t, out, name).Spec.SchemaSettings.Encoding; got != want {
+			t.Errorf("%s encoding = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestConfigMapDefinitionModeWithTopics(t *testing.T) {
+	for _, args := range [][]string{{"--emit-topics"}, {"--bundle"}} {
+		t.Run(args[0], func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: testEventProto}, append([]string{"--definition-mode", "configmap"}, args...)...)
+			var kustomization struct {
+				Replacements []struct {
+					Source struct {
+						Name string `yaml:"name"`
+					} `yaml:"source"`
+					Targets []struct {
+						Select struct {
+							Kind string `yaml:"kind"`
+							Name string `yaml:"name"`
+						} `yaml:"select"`
+					} `yaml:"targets"`
+				} `yaml:"replacements"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, "kustomization.yaml"))), &kustomization); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range kustomization.Replacements {
+				for _, target := range r.Targets {
+					got = append(got, r.Source.Name+" -> "+target.Select.Kind+" "+target.Select.Name)
+				}
+			}
+			if want := []string{testEventSchema + "-definition -> PubSubSchema " + testEventSchema}; !reflect.DeepEqual(got, want) {
+				t.Errorf("replacements = %v, want %v", got, want)
+			}
+		})
+	}
+}
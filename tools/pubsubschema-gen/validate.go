@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateInput runs the opt-in governance checks against one input.
+func validateInput(in *schemaInput, opts options) error {
+	if len(opts.protoRoots) > 0 {
+		if err := opts.rules.record(ruleImportsResolve, resolveImports(in, importResolver{roots: opts.protoRoots})); err != nil {
+			return err
+		}
+	}
+	if opts.maxDefinitionLines > 0 {
+		if err := opts.rules.record(ruleMaxDefinitionLines, checkDefinitionLines(in, opts.maxDefinitionLines)); err != nil {
+			return err
+		}
+	}
+	if opts.requireMessageMatchesFile {
+		if err := opts.rules.record(ruleMessageMatchesFile, checkMessageMatchesFile(in)); err != nil {
+			return err
+		}
+	}
+	if opts.requireFieldComments {
+		if err := opts.rules.record(ruleFieldComments, checkFieldComments(in, opts.requireFieldCommentsNested)); err != nil {
+			return err
+		}
+	}
+	if opts.enforceProto3 {
+		if err := opts.rules.record(ruleProto3Syntax, checkProto3(in)); err != nil {
+			return err
+		}
+	}
+	if opts.validateFieldNumbers {
+		if err := opts.rules.record(ruleFieldNumbers, checkFieldNumbers(in)); err != nil {
+			return err
+		}
+	}
+	if len(opts.forbiddenFieldTypes) > 0 {
+		if err := opts.rules.record(ruleForbidFieldTypes, checkForbiddenFieldTypes(in, opts.forbiddenFieldTypes)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkForbiddenFieldTypes fails on the first field whose type (or map key or
+// value type) is in forbidden. Leading dots on fully-qualified names are
+// ignored on both sides.
+func checkForbiddenFieldTypes(in *schemaInput, forbidden []string) error {
+	pf, err := in.proto()
+	if err != nil {
+		return err
+	}
+	deny := make(map[string]bool, len(forbidden))
+	for _, t := range forbidden {
+		deny[strings.TrimPrefix(t, ".")] = true
+	}
+	var found error
+	pf.walkMessages(func(path string, m *protoMessage) {
+		for _, f := range m.fields {
+			for _, t := range f.types() {
+				if found == nil && deny[strings.TrimPrefix(t, ".")] {
+					found = fmt.Errorf("%s: field %s.%s uses forbidden type %s", in.path, path, f.name, t)
+				}
+			}
+		}
+	})
+	return found
+}
+
+// checkDefinitionLines fails when the normalized definition is longer than
+// max lines, which usually means protos were concatenated by accident.
+func checkDefinitionLines(in *schemaInput, max int) error {
+	if n := strings.Count(in.definition, "\n"); n > max {
+		return fmt.Errorf("%s: definition has %d lines, exceeding --max-definition-lines=%d", in.path, n, max)
+	}
+	return nil
+}
+
+// checkProto3 requires an explicit proto3 syntax statement. A missing one
+// is only fixed by --fix; other syntaxes and editions are always errors.
+func checkProto3(in *schemaInput) error {
+	pf, err := in.proto()
+	if err != nil {
+		return err
+	}
+	switch pf.syntax {
+	case "proto3":
+		return nil
+	case "":
+		return fmt.Errorf("%s: no syntax statement, but --enforce-proto3 requires proto3 (--fix adds %s)", in.path, proto3SyntaxStatement)
+	case "editions":
+		return fmt.Errorf("%s: uses editions, but --enforce-proto3 requires proto3", in.path)
+	}
+	return fmt.Errorf("%s: syntax %q, but --enforce-proto3 requires proto3", in.path, pf.syntax)
+}
+
+// Field number bounds from the protobuf language spec.
+const (
+	maxFieldNumber        = 1<<29 - 1
+	reservedFieldNumberLo = 19000
+	reservedFieldNumberHi = 19999
+)
+
+// checkFieldNumbers fails if a field of a top-level message has a number
+// that is not positive, lies in the range reserved for the protobuf
+// implementation, exceeds the maximum, or is used by an earlier field of the
+// same message (oneof members included). All offending fields are listed.
+func checkFieldNumbers(in *schemaInput) error {
+	pf, err := in.proto()
+	if err != nil {
+		return err
+	}
+	var bad []string
+	for _, m := range pf.messages {
+		seen := make(map[int]string, len(m.fields))
+		for _, f := range m.fields {
+			field := fmt.Sprintf("%s.%s = %d (line %d)", m.name, f.name, f.number, f.line)
+			switch {
+			case f.number <= 0:
+				bad = append(bad, field+": not positive")
+			case f.number >= reservedFieldNumberLo && f.number <= reservedFieldNumberHi:
+				bad = append(bad, field+fmt.Sprintf(": in the reserved range %d-%d", reservedFieldNumberLo, reservedFieldNumberHi))
+			case f.number > maxFieldNumber:
+				bad = append(bad, field+fmt.Sprintf(": above the maximum %d", maxFieldNumber))
+			}
+			if first, ok := seen[f.number]; ok {
+				bad = append(bad, field+": already used by "+first)
+				continue
+			}
+			seen[f.number] = f.name
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s: invalid field numbers: %s", in.path, strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// checkFieldComments fails if a field of a top-level message, or of any
+// message when nested is set, has no comment directly before it. All such
+// fields are listed.
+func checkFieldComments(in *schemaInput, nested bool) error {
+	pf, err := in.proto()
+	if err != nil {
+		return err
+	}
+	var missing []string
+	pf.walkMessages(func(path string, m *protoMessage) {
+		if !nested && strings.Contains(path, ".") {
+			return
+		}
+		for _, f := range m.fields {
+			if !f.commented {
+				missing = append(missing, fmt.Sprintf("%s.%s (line %d)", path, f.name, f.line))
+			}
+		}
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: fields without a comment: %s", in.path, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// schemaWarnings lists the non-fatal issues of in, in a fixed order, for
+// --warnings-as-annotations. A missing package only counts when a
+// package-based feature is in use.
+func schemaWarnings(in *schemaInput, opts options) ([]string, error) {
+	pf, err := in.proto()
+	if err != nil {
+		return nil, err
+	}
+	var warnings []string
+	switch pf.syntax {
+	case "":
+		warnings = append(warnings, "no syntax declaration (proto2 is assumed)")
+	case "proto2":
+		warnings = append(warnings, "proto2 syntax")
+	}
+	if opts.usesPackage() && pf.pkg == "" && in.strippedPackage == "" {
+		warnings = append(warnings, "no package declaration")
+	}
+	if len(pf.messages) == 0 {
+		warnings = append(warnings, "no top-level message")
+	}
+	return warnings, nil
+}
+
+// checkMessageMatchesFile requires a top-level message named like the file:
+// the last dot-separated part of the base name, so
+// coreapp.config.v1.ConfigEvent.pubsub.proto needs message ConfigEvent.
+func checkMessageMatchesFile(in *schemaInput) error {
+	pf, err := in.proto()
+	if err != nil {
+		return err
+	}
+	want := schemaBaseName(in.path)
+	if i := strings.LastIndex(want, "."); i >= 0 {
+		want = want[i+1:]
+	}
+	var got []string
+	for _, m := range pf.messages {
+		if m.name == want {
+			return nil
+		}
+		got = append(got, m.name)
+	}
+	if len(got) == 0 {
+		return fmt.Errorf("%s: no top-level message; want message %s", in.path, want)
+	}
+	return fmt.Errorf("%s: no top-level message %s (found %s)", in.path, want, strings.Join(got, ", "))
+}
+
+// checkDuplicateDefinition fails if another input already produced the same
+// definition as in. Only digests are kept, so it also works across batches
+// after the sources have been released.
+func (p *plan) checkDuplicateDefinition(in *schemaInput, opts options) error {
+	sum := hashHex(opts.hashAlgo, []byte(in.definition))
+	if first, ok := p.definitions[sum]; ok {
+		return fmt.Errorf("%s and %s have identical definitions", first, in.path)
+	}
+	p.definitions[sum] = in.path
+	return nil
+}
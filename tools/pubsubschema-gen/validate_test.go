@@ -0,0 +1,410 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckForbiddenFieldTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name: "avoids",
+			src:  "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n  google.protobuf.Timestamp at = 2;\n}\n",
+		},
+		{
+			name:    "field",
+			src:     "syntax = \"proto3\";\nmessage E {\n  google.protobuf.Any payload = 1;\n}\n",
+			wantErr: "field E.payload uses forbidden type google.protobuf.Any",
+		},
+		{
+			name:    "fully qualified",
+			src:     "syntax = \"proto3\";\nmessage E {\n  repeated .google.protobuf.Any payload = 1;\n}\n",
+			wantErr: "field E.payload uses forbidden type .google.protobuf.Any",
+		},
+		{
+			name:    "map value",
+			src:     "syntax = \"proto3\";\nmessage E {\n  map<string, google.protobuf.Any> extra = 1;\n}\n",
+			wantErr: "field E.extra uses forbidden type google.protobuf.Any",
+		},
+		{
+			name:    "nested oneof",
+			src:     "syntax = \"proto3\";\nmessage E {\n  message Inner {\n    oneof v {\n      google.protobuf.Any any = 1;\n    }\n  }\n}\n",
+			wantErr: "field E.Inner.any uses forbidden type google.protobuf.Any",
+		},
+		{
+			name: "ignores comments and strings",
+			src:  "syntax = \"proto3\";\n// google.protobuf.Any payload = 1;\nmessage E {\n  string s = 1 [default = \"google.protobuf.Any\"];\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &schemaInput{path: "e.pubsub.proto", definition: tt.src}
+			err := checkForbiddenFieldTypes(in, []string{".google.protobuf.Any"})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForbidFieldTypeNamesFile(t *testing.T) {
+	src := "syntax = \"proto3\";\nmessage TestEvent {\n  google.protobuf.Any payload = 1;\n}\n"
+	err := generateErr(t, map[string]string{testEventFile: src}, "--forbid-field-type", "google.protobuf.Any")
+	if !strings.Contains(err.Error(), testEventFile) || !strings.Contains(err.Error(), "TestEvent.payload") {
+		t.Errorf("error %q does not name the file and field", err)
+	}
+}
+
+func TestMaxDefinitionLines(t *testing.T) {
+	// testEventProto has 11 lines.
+	tests := []struct {
+		max     string
+		wantErr bool
+	}{
+		{"12", false},
+		{"11", false},
+		{"10", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.max, func(t *testing.T) {
+			if !tt.wantErr {
+				generate(t, map[string]string{testEventFile: testEventProto}, "--max-definition-lines", tt.max)
+				return
+			}
+			err := generateErr(t, map[string]string{testEventFile: testEventProto}, "--max-definition-lines", tt.max)
+			if want := "definition has 11 lines, exceeding --max-definition-lines=" + tt.max; !strings.Contains(err.Error(), want) || !strings.Contains(err.Error(), testEventFile) {
+				t.Errorf("error = %v, want the file and %q", err, want)
+			}
+		})
+	}
+}
+
+func TestFailOnDuplicateDefinition(t *testing.T) {
+	event := "syntax = \"proto3\";\nmessage E {\n  string id = 1;\n}\n"
+	tests := []struct {
+		name    string
+		inputs  map[string]string
+		args    []string
+		wantErr bool
+	}{
+		{"unique", map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": strings.Replace(event, "id", "key", 1)}, nil, false},
+		{"identical", map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": event}, nil, true},
+		{"identical after normalization", map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": strings.ReplaceAll(event, "\n", "\r\n")}, nil, true},
+		{"identical once comments are stripped", map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": "// Copied.\n" + event}, []string{"--comments", "none"}, true},
+		{"across batches", map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": event}, []string{"--batch-size", "1"}, true},
+		{"other hash algorithm", map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": event}, []string{"--hash-algo", "blake2b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--fail-on-duplicate-definition"}, tt.args...)
+			if !tt.wantErr {
+				generate(t, tt.inputs, args...)
+				return
+			}
+			err := generateErr(t, tt.inputs, args...)
+			want := filepath.Join("pubsub", "a.v1.A.pubsub.proto") + " and "
+			if !strings.Contains(err.Error(), want) || !strings.HasSuffix(err.Error(), filepath.Join("pubsub", "a.v1.B.pubsub.proto")+" have identical definitions") {
+				t.Errorf("error = %v, want the offending pair", err)
+			}
+		})
+	}
+}
+
+func TestDuplicateDefinitionsAllowedByDefault(t *testing.T) {
+	event := "syntax = \"proto3\";\nmessage E {}\n"
+	out := generate(t, map[string]string{"a.v1.A.pubsub.proto": event, "a.v1.B.pubsub.proto": event})
+	if got := len(kustomizationResources(t, out)); got != 2 {
+		t.Errorf("%d resources, want 2", got)
+	}
+}
+
+func TestCheckMessageMatchesFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		src     string
+		wantErr string
+	}{
+		{"matching", testEventFile, testEventProto, ""},
+		{"one of several", testEventFile, "syntax = \"proto3\";\nmessage Header {}\nmessage TestEvent {}\n", ""},
+		{"nested only", testEventFile, "syntax = \"proto3\";\nmessage Envelope {\n  message TestEvent {}\n}\n", "no top-level message TestEvent (found Envelope)"},
+		{"renamed", testEventFile, "syntax = \"proto3\";\nmessage TestEventV2 {}\nmessage Other {}\n", "no top-level message TestEvent (found TestEventV2, Other)"},
+		{"case differs", testEventFile, "syntax = \"proto3\";\nmessage Testevent {}\n", "no top-level message TestEvent (found Testevent)"},
+		{"no messages", testEventFile, "syntax = \"proto3\";\nenum TestEvent { A = 0; }\n", "no top-level message; want message TestEvent"},
+		{"undotted file name", "TestEvent.pubsub.proto", "syntax = \"proto3\";\nmessage TestEvent {}\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMessageMatchesFile(testInput(t, tt.file, tt.src, testOptions()))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkMessageMatchesFile = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.file+": "+tt.wantErr {
+				t.Errorf("checkMessageMatchesFile = %v, want %q", err, tt.file+": "+tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequireMessageNameMatchesFile(t *testing.T) {
+	generate(t, map[string]string{testEventFile: testEventProto}, "--require-message-name-matches-file")
+	generate(t, map[string]string{testEventFile: "syntax = \"proto3\";\nmessage Renamed {}\n"})
+	err := generateErr(t, map[string]string{testEventFile: "syntax = \"proto3\";\nmessage Renamed {}\n"}, "--require-message-name-matches-file")
+	if !strings.Contains(err.Error(), testEventFile) || !strings.Contains(err.Error(), "no top-level message TestEvent (found Renamed)") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestWarningsAsAnnotations(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		args []string
+		// want is the annotation value; empty means no annotation.
+		want string
+	}{
+		{name: "clean", src: testEventProto},
+		{name: "proto2", src: "syntax = \"proto2\";\npackage a.v1;\nmessage TestEvent {}\n", want: "proto2 syntax"},
+		{name: "no syntax", src: "package a.v1;\nmessage TestEvent {}\n", want: "no syntax declaration (proto2 is assumed)"},
+		{name: "several", src: "syntax = \"proto2\";\nenum TestEvent { A = 0; }\n", want: "proto2 syntax; no top-level message"},
+		// A missing package only matters to the package-based flags.
+		{name: "no package", src: "syntax = \"proto3\";\nmessage TestEvent {}\n"},
+		{name: "no package with --package-label", src: "syntax = \"proto3\";\nmessage TestEvent {}\n", args: []string{"--package-label", "proto-package"}, want: "no package declaration"},
+		{name: "no package with group by package", src: "syntax = \"proto3\";\nmessage TestEvent {}\n", args: []string{"--kustomization-group-by", "package"}, want: "no package declaration"},
+		{name: "no package with --buf-index", src: "syntax = \"proto3\";\nmessage TestEvent {}\n", args: []string{"--buf-index", filepath.Join(t.TempDir(), "buf-index.json"), "--buf-module", "proto"}, want: "no package declaration"},
+		// A stripped package still counts as declared.
+		{name: "stripped package", src: testEventProto, args: []string{"--strip-package"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := generate(t, map[string]string{testEventFile: tt.src}, append([]string{"--warnings-as-annotations"}, tt.args...)...)
+			var doc struct {
+				Metadata struct {
+					Annotations map[string]string `yaml:"annotations"`
+				} `yaml:"metadata"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := doc.Metadata.Annotations["configmanagement-poc/warnings"]
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("warnings annotation = %q (present %v), want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestWarningsNotAnnotatedByDefault(t *testing.T) {
+	out := generate(t, map[string]string{testEventFile: "syntax = \"proto2\";\nmessage TestEvent {}\n"})
+	if data := readFile(t, filepath.Join(out, testEventSchema+".schema.yaml")); strings.Contains(data, "warnings") {
+		t.Errorf("schema has warnings without --warnings-as-annotations:\n%s", data)
+	}
+}
+
+func TestCheckFieldComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		nested  bool
+		wantErr string
+	}{
+		{name: "line comment above", body: "  // The ID.\n  string id = 1;\n"},
+		{name: "several lines above", body: "  // The ID.\n  // Unique per event.\n  string id = 1;\n"},
+		{name: "block comment above", body: "  /* The ID,\n     unique per event. */\n  repeated string id = 1;\n"},
+		{name: "none", body: "  string id = 1;\n", wantErr: "E.id (line 3)"},
+		{
+			name:    "trailing comment on the previous field",
+			body:    "  // The ID.\n  string id = 1; // note\n  string name = 2;\n",
+			wantErr: "E.name (line 5)",
+		},
+		{
+			name:    "blank line between",
+			body:    "  // Detached.\n\n  string id = 1;\n",
+			wantErr: "E.id (line 5)",
+		},
+		{
+			name:    "comment on the field's own line",
+			body:    "  /* The ID. */ string id = 1;\n",
+			wantErr: "E.id (line 3)",
+		},
+		{name: "nested skipped", body: "  // Inner.\n  message Inner {\n    string x = 1;\n  }\n"},
+		{
+			name:    "nested",
+			body:    "  // Inner.\n  message Inner {\n    string x = 1;\n  }\n",
+			nested:  true,
+			wantErr: "E.Inner.x (line 5)",
+		},
+		{
+			name:    "all listed",
+			body:    "  string a = 1;\n  // B.\n  string b = 2;\n  string c = 3;\n",
+			wantErr: "fields without a comment: E.a (line 3), E.c (line 6)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "syntax = \"proto3\";\nmessage E {\n" + tt.body + "}\n"
+			err := checkFieldComments(&schemaInput{path: "e.pubsub.proto", definition: src}, tt.nested)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to end in %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequireFieldComments(t *testing.T) {
+	src := "syntax = \"proto3\";\nmessage TestEvent {\n  // The ID.\n  string id = 1; // note\n  string name = 2;\n}\n"
+	generate(t, map[string]string{testEventFile: src})
+	err := generateErr(t, map[string]string{testEventFile: src}, "--require-field-comments")
+	if !strings.Contains(err.Error(), testEventFile) || !strings.HasSuffix(err.Error(), "fields without a comment: TestEvent.name (line 5)") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestCheckFieldNumbers(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "valid", body: "  string a = 1;\n  string b = 2;\n  string c = 536870911;\n"},
+		{name: "around the reserved range", body: "  string a = 18999;\n  string b = 20000;\n"},
+		{name: "zero", body: "  string a = 0;\n", wantErr: "E.a = 0 (line 3): not positive"},
+		{name: "reserved low", body: "  string a = 19000;\n", wantErr: "E.a = 19000 (line 3): in the reserved range 19000-19999"},
+		{name: "reserved high", body: "  string a = 19999;\n", wantErr: "E.a = 19999 (line 3): in the reserved range 19000-19999"},
+		{name: "above maximum", body: "  string a = 536870912;\n", wantErr: "E.a = 536870912 (line 3): above the maximum 536870911"},
+		{name: "duplicate", body: "  string a = 1;\n  int32 b = 1;\n", wantErr: "E.b = 1 (line 4): already used by a"},
+		{name: "duplicate in oneof", body: "  string a = 1;\n  oneof v {\n    int32 b = 1;\n  }\n", wantErr: "E.b = 1 (line 5): already used by a"},
+		{name: "map field", body: "  map<string, string> a = 2;\n  string b = 2;\n", wantErr: "E.b = 2 (line 4): already used by a"},
+		{name: "nested messages are separate", body: "  string a = 1;\n  message Inner {\n    string b = 1;\n    string c = 1;\n  }\n"},
+		{
+			name:    "all listed",
+			body:    "  string a = 0;\n  string b = 19500;\n  string c = 19500;\n",
+			wantErr: "invalid field numbers: E.a = 0 (line 3): not positive, E.b = 19500 (line 4): in the reserved range 19000-19999, E.c = 19500 (line 5): in the reserved range 19000-19999, E.c = 19500 (line 5): already used by b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "syntax = \"proto3\";\nmessage E {\n" + tt.body + "}\n"
+			err := checkFieldNumbers(&schemaInput{path: "e.pubsub.proto", definition: src})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to end in %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFieldNumbers(t *testing.T) {
+	src := "syntax = \"proto3\";\nmessage TestEvent {\n  string id = 1;\n  string name = 1;\n}\n"
+	generate(t, map[string]string{testEventFile: src})
+	err := generateErr(t, map[string]string{testEventFile: src}, "--validate-field-numbers")
+	if !strings.Contains(err.Error(), testEventFile) || !strings.HasSuffix(err.Error(), "invalid field numbers: TestEvent.name = 1 (line 4): already used by id") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestCheckProto3(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{name: "proto3", src: "syntax = \"proto3\";\nmessage E {}\n"},
+		{name: "proto3 single quotes", src: "syntax = 'proto3';\nmessage E {}\n"},
+		{name: "missing", src: "message E {}\n", wantErr: `e.pubsub.proto: no syntax statement, but --enforce-proto3 requires proto3 (--fix adds syntax = "proto3";)`},
+		{name: "proto2", src: "syntax = \"proto2\";\nmessage E {}\n", wantErr: `e.pubsub.proto: syntax "proto2", but --enforce-proto3 requires proto3`},
+		{name: "editions", src: "edition = \"2023\";\nmessage E {}\n", wantErr: "e.pubsub.proto: uses editions, but --enforce-proto3 requires proto3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProto3(&schemaInput{path: "e.pubsub.proto", definition: tt.src})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnforceProto3(t *testing.T) {
+	const body = "package coreapp.test.v1;\n\nmessage TestEvent {\n  string id = 1;\n}\n"
+	tests := []struct {
+		name    string
+		src     string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "proto3", src: "syntax = \"proto3\";\n" + body, want: "syntax = \"proto3\";\n" + body},
+		{name: "proto3 with fix", src: "syntax = \"proto3\";\n" + body, args: []string{"--fix"}, want: "syntax = \"proto3\";\n" + body},
+		{name: "missing", src: body, wantErr: "no syntax statement, but --enforce-proto3 requires proto3"},
+		{name: "missing with fix", src: body, args: []string{"--fix"}, want: "syntax = \"proto3\";\n" + body},
+		{
+			name: "missing with fix and filename comment",
+			src:  body,
+			args: []string{"--fix", "--definition-filename-comment"},
+			want: "syntax = \"proto3\";\n// source: " + testEventFile + "\n" + body,
+		},
+		{name: "proto2", src: "syntax = \"proto2\";\n" + body, wantErr: `syntax "proto2", but --enforce-proto3 requires proto3`},
+		{name: "proto2 with fix", src: "syntax = \"proto2\";\n" + body, args: []string{"--fix"}, wantErr: `syntax "proto2", but --enforce-proto3 requires proto3`},
+		{name: "editions with fix", src: "edition = \"2023\";\n" + body, args: []string{"--fix"}, wantErr: "uses editions, but --enforce-proto3 requires proto3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, out := filepath.Join(t.TempDir(), "pubsub"), filepath.Join(t.TempDir(), "out")
+			writeTree(t, in, map[string]string{testEventFile: tt.src})
+			args := append([]string{"--pubsub-dir", in, "--output-dir", out, "--enforce-proto3"}, tt.args...)
+			_, stderr, err := runTool(t, args...)
+			if data := readFile(t, filepath.Join(in, testEventFile)); data != tt.src {
+				t.Errorf("input was rewritten:\n%s", data)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), filepath.Join(in, testEventFile)+": "+tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, stderr)
+			}
+			var doc struct {
+				Spec struct {
+					Definition string `yaml:"definition"`
+				} `yaml:"spec"`
+			}
+			if err := yaml.Unmarshal([]byte(readFile(t, filepath.Join(out, testEventSchema+".schema.yaml"))), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Spec.Definition != tt.want {
+				t.Errorf("definition =\n%s\nwant\n%s", doc.Spec.Definition, tt.want)
+			}
+		})
+	}
+	err := generateErr(t, map[string]string{testEventFile: body}, "--fix")
+	if !strings.Contains(err.Error(), "--fix requires --enforce-proto3") {
+		t.Errorf("error = %v", err)
+	}
+}